@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"github.com/Fyefhqdishka/LocFinder/internal/config"
+	"github.com/Fyefhqdishka/LocFinder/internal/ipaddr"
+	"github.com/Fyefhqdishka/LocFinder/internal/models"
+	"github.com/Fyefhqdishka/LocFinder/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testConfig loads the configuration of a new SQLite database, providers are not called
+// for the special and invalid IPs the tests look up
+func testConfig(t *testing.T) *config.Config {
+	t.Setenv("DB_DRIVER", config.DBDriverSQLite)
+	t.Setenv("SQLITE_PATH", filepath.Join(t.TempDir(), "locfinder.db"))
+	t.Setenv("GEO_PROVIDERS", "ipapi")
+	t.Setenv("LOCATION_TTL", "24h")
+
+	cfg, err := config.LoadFromEnv()
+	require.NoError(t, err)
+	return cfg
+}
+
+// runCommand runs the command and returns what it wrote to stdout, stderr is discarded
+func runCommand(t *testing.T, cfg *config.Config, run func(cfg *config.Config, args []string) error, args ...string) (string, error) {
+	stdout, err := os.CreateTemp(t.TempDir(), "stdout")
+	require.NoError(t, err)
+	defer stdout.Close()
+	stderr, err := os.Open(os.DevNull)
+	require.NoError(t, err)
+	defer stderr.Close()
+
+	savedStdout, savedStderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = stdout, stderr
+	defer func() { os.Stdout, os.Stderr = savedStdout, savedStderr }()
+
+	runErr := run(cfg, args)
+
+	_, err = stdout.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	out, err := io.ReadAll(stdout)
+	require.NoError(t, err)
+	return string(out), runErr
+}
+
+func seed(t *testing.T, cfg *config.Config, locations ...models.IPLocation) {
+	backend, err := storage.Open(cfg.DB, commandLog())
+	require.NoError(t, err)
+	defer backend.Close()
+
+	for _, l := range locations {
+		save := backend.Locations.Save
+		if l.Provider == "manual" {
+			save = backend.Locations.Create
+		}
+		require.NoError(t, save(context.Background(), l))
+	}
+}
+
+func TestQueryFlags(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    models.LocationQuery
+		empty   bool
+		wantErr string
+	}{
+		{"no filters", nil, models.LocationQuery{}, true, ""},
+		{"filters", []string{"-country", "Kazakhstan", "-provider", "manual", "-cidr", "37.99.0.0/16"},
+			models.LocationQuery{Country: "Kazakhstan", Provider: "manual", Network: "37.99.0.0/16"}, false, ""},
+		{"date", []string{"-created-from", "2025-01-01"},
+			models.LocationQuery{CreatedFrom: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}, false, ""},
+		{"timestamp", []string{"-created-to", "2025-01-01T10:00:00Z"},
+			models.LocationQuery{CreatedTo: time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)}, false, ""},
+		{"invalid time", []string{"-created-from", "yesterday"}, models.LocationQuery{}, false, "invalid -created-from"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flags := flag.NewFlagSet("test", flag.ContinueOnError)
+			filters := newQueryFlags(flags)
+			require.NoError(t, flags.Parse(tt.args))
+
+			assert.Equal(t, tt.empty, filters.empty())
+			query, err := filters.query()
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, query)
+		})
+	}
+}
+
+func TestCommandErrors(t *testing.T) {
+	cfg := testConfig(t)
+
+	tests := []struct {
+		name    string
+		run     func(cfg *config.Config, args []string) error
+		args    []string
+		wantErr string
+	}{
+		{"lookup without IPs", runLookup, nil, "no IPs to look up"},
+		{"lookup of an invalid IP", runLookup, []string{"10.0.0.1", "foo"}, "1 IPs were not located"},
+		{"lookup output format", runLookup, []string{"-o", "xml", "10.0.0.1"}, `unknown output format "xml"`},
+		{"export output format", runExport, []string{"-o", "xml"}, `unknown output format "xml"`},
+		{"export sort field", runExport, []string{"-sort", "zip"}, `unknown sort field "zip"`},
+		{"export time filter", runExport, []string{"-created-to", "tomorrow"}, "invalid -created-to"},
+		{"purge without filters", runPurge, nil, "set a filter or -all"},
+		{"stats top", runStats, []string{"-top", "-1"}, "invalid -top -1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := runCommand(t, cfg, tt.run, tt.args...)
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestLookupCommand(t *testing.T) {
+	cfg := testConfig(t)
+
+	out, err := runCommand(t, cfg, runLookup, "-o", "json", "10.0.0.1", "::1")
+	require.NoError(t, err)
+
+	var results []models.LookupResult
+	require.NoError(t, json.Unmarshal([]byte(out), &results))
+	require.Len(t, results, 2)
+	assert.Equal(t, ipaddr.TypePrivate, results[0].Location.Type)
+	assert.Equal(t, ipaddr.TypeLoopback, results[1].Location.Type)
+}
+
+func TestExportPurgeAndStatsCommands(t *testing.T) {
+	cfg := testConfig(t)
+	seed(t, cfg,
+		models.IPLocation{Network: "37.99.42.0/24", Country: "Kazakhstan", City: "Almaty", Provider: "ipapi"},
+		models.IPLocation{Network: "8.8.8.0/24", Country: "United States", City: "Ashburn", Provider: "ipapi"},
+		models.IPLocation{Network: "2a00:1450::/32", Country: "Ireland", City: "Dublin", Provider: "manual"},
+	)
+
+	out, err := runCommand(t, cfg, runExport, "-o", "csv", "-country", "Kazakhstan")
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	require.Len(t, lines, 2)
+	assert.Contains(t, lines[1], "37.99.42.0/24")
+
+	path := filepath.Join(t.TempDir(), "all.ndjson")
+	out, err = runCommand(t, cfg, runExport, "-o", "ndjson", "-out", path)
+	require.NoError(t, err)
+	assert.Equal(t, "exported 3 locations to "+path+"\n", out)
+
+	out, err = runCommand(t, cfg, runPurge, "-dry-run", "-provider", "ipapi")
+	require.NoError(t, err)
+	assert.Len(t, strings.Split(strings.TrimSpace(out), "\n"), 3)
+
+	_, err = runCommand(t, cfg, runPurge, "-provider", "ipapi")
+	require.NoError(t, err)
+
+	out, err = runCommand(t, cfg, runStats, "-o", "json")
+	require.NoError(t, err)
+	var summary models.LocationSummary
+	require.NoError(t, json.Unmarshal([]byte(out), &summary))
+	assert.Equal(t, int64(1), summary.Total)
+	assert.Equal(t, int64(1), summary.IPv6)
+	assert.Equal(t, []models.NameCount{{Name: "manual", Count: 1}}, summary.Providers)
+}
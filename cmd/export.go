@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/Fyefhqdishka/LocFinder/internal/config"
+	"github.com/Fyefhqdishka/LocFinder/internal/export"
+	"github.com/Fyefhqdishka/LocFinder/internal/models"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// runExport streams stored locations matching the filters of GET /locations. Besides the
+// command output formats it writes the ndjson and geojson downloads of the HTTP export:
+//
+//	app export -o csv -country Kazakhstan > kazakhstan.csv
+//	app export -o geojson -provider manual -out manual.geojson
+func runExport(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := flags.String("o", export.FormatCSV, "output format: table, json, csv, ndjson or geojson")
+	filters := newQueryFlags(flags)
+	sort := flags.String("sort", models.SortNetwork, "sort field: created_at, country, city or network, \"-\" prefix for descending")
+	path := flags.String("out", "", "output file, stdout by default")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: app export [-o table|json|csv|ndjson|geojson] [-out file] [filters]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	query, err := filters.query()
+	if err != nil {
+		return err
+	}
+	query.Sort, query.Desc = strings.TrimPrefix(*sort, "-"), strings.HasPrefix(*sort, "-")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	backend, locService, err := openService(cfg)
+	if err != nil {
+		return err
+	}
+	defer backend.Close()
+	defer locService.Close()
+
+	var w io.Writer = os.Stdout
+	if *path != "" {
+		f, err := os.Create(*path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	out, err := newLocationEncoder(w, *format)
+	if err != nil {
+		return err
+	}
+
+	exported := 0
+	err = locService.ExportLocations(ctx, query, func(l models.IPLocation) error {
+		exported++
+		return out.Encode(l)
+	})
+	if err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	if *path != "" {
+		fmt.Printf("exported %d locations to %s\n", exported, *path)
+	}
+	return nil
+}
+
+// newLocationEncoder returns the encoder of the export format, or writes the command output formats
+func newLocationEncoder(w io.Writer, format string) (export.Encoder, error) {
+	if export.IsFormat(format) {
+		return export.NewEncoder(w, format)
+	}
+
+	out, err := newRecordWriter(w, format, export.Header)
+	if err != nil {
+		return nil, err
+	}
+	return recordEncoder{out}, nil
+}
+
+type recordEncoder struct {
+	recordWriter
+}
+
+func (e recordEncoder) Encode(l models.IPLocation) error {
+	return e.Write(l, export.Record(l))
+}
+
+// queryFlags are the location filters of GET /locations
+type queryFlags struct {
+	country     *string
+	city        *string
+	provider    *string
+	cidr        *string
+	createdFrom *string
+	createdTo   *string
+}
+
+func newQueryFlags(flags *flag.FlagSet) *queryFlags {
+	return &queryFlags{
+		country:     flags.String("country", "", "only locations of the country"),
+		city:        flags.String("city", "", "only locations of the city"),
+		provider:    flags.String("provider", "", "only locations of the provider, e.g. manual"),
+		cidr:        flags.String("cidr", "", "only networks within the CIDR"),
+		createdFrom: flags.String("created-from", "", "only locations created at or after the time (RFC 3339 or date)"),
+		createdTo:   flags.String("created-to", "", "only locations created before the time (RFC 3339 or date)"),
+	}
+}
+
+// empty reports whether no filter is set
+func (f *queryFlags) empty() bool {
+	return *f.country == "" && *f.city == "" && *f.provider == "" && *f.cidr == "" && *f.createdFrom == "" && *f.createdTo == ""
+}
+
+func (f *queryFlags) query() (models.LocationQuery, error) {
+	query := models.LocationQuery{
+		Country:  *f.country,
+		City:     *f.city,
+		Provider: *f.provider,
+		Network:  *f.cidr,
+	}
+
+	var err error
+	if query.CreatedFrom, err = models.ParseCreatedTime(*f.createdFrom); err != nil {
+		return models.LocationQuery{}, fmt.Errorf("invalid -created-from: %v", err)
+	}
+	if query.CreatedTo, err = models.ParseCreatedTime(*f.createdTo); err != nil {
+		return models.LocationQuery{}, fmt.Errorf("invalid -created-to: %v", err)
+	}
+	return query, nil
+}
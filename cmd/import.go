@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/Fyefhqdishka/LocFinder/internal/config"
+	"github.com/Fyefhqdishka/LocFinder/internal/importer"
+	"github.com/Fyefhqdishka/LocFinder/internal/storage"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// runImport loads a CSV range database into the configured database:
+//
+//	app import -format geolite2 -locations GeoLite2-City-Locations-en.csv GeoLite2-City-Blocks-IPv4.csv GeoLite2-City-Blocks-IPv6.csv
+//	app import -format ip2location IP2LOCATION-LITE-DB11.CSV IP2LOCATION-LITE-DB11.IPV6.CSV
+func runImport(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	format := flags.String("format", importer.FormatGeoLite2, "dataset format: geolite2 or ip2location")
+	locations := flags.String("locations", "", "GeoLite2 City Locations CSV file")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: app import [-format geolite2|ip2location] [-locations file] file...")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if cfg.DB.Driver == config.DBDriverMemory {
+		return fmt.Errorf("can't import into the memory database, it's dropped on exit")
+	}
+
+	log := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	backend, err := storage.Open(cfg.DB, log)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %v", err)
+	}
+	defer backend.Close()
+
+	imp := importer.New(backend.Ranges, "", log)
+
+	result, err := imp.Import(ctx, importer.Request{
+		Format:    *format,
+		Files:     flags.Args(),
+		Locations: *locations,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("imported %d ranges (%d rows skipped) in %s\n", result.Rows, result.Skipped, result.Duration)
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"github.com/Fyefhqdishka/LocFinder/internal/config"
+	"github.com/Fyefhqdishka/LocFinder/internal/models"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// runLookup resolves IPs like POST /locations/lookup, stored locations are read from the database
+// and the rest is fetched from providers and saved. With "-" IPs are read from stdin, one per line:
+//
+//	app lookup 8.8.8.8 1.1.1.1
+//	app lookup -o csv - < ips.txt
+func runLookup(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("lookup", flag.ExitOnError)
+	format := outputFlag(flags, outputTable)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: app lookup [-o table|json|csv] ip...|-")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	ips := flags.Args()
+	if len(ips) == 1 && ips[0] == "-" {
+		var err error
+		if ips, err = readLines(os.Stdin); err != nil {
+			return err
+		}
+	}
+	if len(ips) == 0 {
+		flags.Usage()
+		return fmt.Errorf("no IPs to look up")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	backend, locService, err := openService(cfg)
+	if err != nil {
+		return err
+	}
+	defer backend.Close()
+	defer locService.Close()
+
+	out, err := newRecordWriter(os.Stdout, *format, []string{"ip", "type", "network", "country", "regionName", "city", "lat", "lon", "provider", "error"})
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for len(ips) > 0 {
+		batch := ips[:min(len(ips), cfg.Geo.BatchMaxSize)]
+		ips = ips[len(batch):]
+
+		results, err := locService.LookupLocations(ctx, batch)
+		if err != nil {
+			return err
+		}
+		for _, result := range results {
+			if result.Error != "" {
+				failed++
+			}
+			if err := out.Write(result, lookupRow(result)); err != nil {
+				return err
+			}
+		}
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d IPs were not located", failed)
+	}
+	return nil
+}
+
+func lookupRow(result models.LookupResult) []string {
+	l := result.Location
+	if l == nil {
+		return []string{result.IP, "", "", "", "", "", "", "", "", result.Error}
+	}
+	return []string{result.IP, l.Type, l.Network, l.Country, l.Region, l.City, formatCoordinate(l.Lat), formatCoordinate(l.Lon), l.Provider, result.Error}
+}
+
+// readLines reads non-empty lines, e.g. a list of IPs
+func readLines(f *os.File) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
@@ -1,44 +1,95 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"github.com/Fyefhqdishka/LocFinder/internal/app"
 	"github.com/Fyefhqdishka/LocFinder/internal/config"
+	"github.com/Fyefhqdishka/LocFinder/internal/service"
+	"github.com/Fyefhqdishka/LocFinder/internal/storage"
 	"github.com/joho/godotenv"
+	"io/fs"
 	"log"
+	"log/slog"
 	"os"
-	"os/signal"
-	"syscall"
+	"sort"
 )
 
+// commands are selected by the first argument, the server is started when there is none
+var commands = map[string]func(cfg *config.Config, args []string) error{
+	"serve":   runServe,
+	"lookup":  runLookup,
+	"import":  runImport,
+	"export":  runExport,
+	"purge":   runPurge,
+	"migrate": runMigrate,
+	"stats":   runStats,
+}
+
 func main() {
+	name, args := "serve", []string{}
+	if len(os.Args) > 1 {
+		name, args = os.Args[1], os.Args[2:]
+	}
+
+	run, ok := commands[name]
+	if !ok {
+		usage()
+		if name != "help" && name != "-h" && name != "--help" {
+			os.Exit(2)
+		}
+		return
+	}
+
 	cfg, err := config.LoadFromEnv()
 	if err != nil {
 		log.Fatalf("can't load config, err: %v", err)
 	}
 
-	app, err := app.New(cfg)
-	if err != nil {
-		log.Fatalf("can't load server, err: %v", err)
+	if err := run(cfg, args); err != nil {
+		log.Fatalf("%s failed: %v", name, err)
 	}
+}
 
-	go func() {
-		if err = app.Run(); err != nil {
-			log.Fatalf("server failed: %v", err)
-		}
-	}()
-	log.Println("shutting down...")
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
-	<-quit
-	if err := app.Stop(); err != nil {
-		fmt.Errorf("error during shutdown: %v", err)
+	fmt.Fprintln(os.Stderr, "Usage: app [command] [flags], the server is started when no command is given")
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, name := range names {
+		fmt.Fprintln(os.Stderr, "  "+name)
+	}
+	fmt.Fprintln(os.Stderr, "Run app <command> -h for the flags of a command")
+}
+
+// commandLog writes warnings and errors only to stderr, so the output of commands stays clean
+func commandLog() *slog.Logger {
+	return slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+}
+
+// openService opens the configured storage and the location service over it
+func openService(cfg *config.Config) (*storage.Backend, *service.LocService, error) {
+	log := commandLog()
+
+	backend, err := storage.Open(cfg.DB, log)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to database: %v", err)
+	}
+
+	locService, err := service.NewLocService(backend.Locations, backend.Ranges, cfg, log)
+	if err != nil {
+		backend.Close()
+		return nil, nil, fmt.Errorf("failed to create location service: %v", err)
 	}
+	return backend, locService, nil
 }
 
 func init() {
-	if err := godotenv.Load(); err != nil {
+	// the environment may be set without the file, e.g. when the CLI runs outside the project
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
 		log.Fatalf("can't load env file, err=%v", err)
 	}
 }
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/Fyefhqdishka/LocFinder/internal/config"
+	"github.com/Fyefhqdishka/LocFinder/internal/storage"
+	"github.com/pressly/goose/v3"
+	"os"
+	"os/signal"
+	"path"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// runMigrate manages the schema of the configured database with the migrations embedded in the binary:
+//
+//	app migrate up          apply all pending migrations
+//	app migrate down        roll back the last applied migration
+//	app migrate status      list migrations and whether they are applied
+//	app migrate to VERSION  migrate up or down to VERSION, 0 rolls back everything
+func runMigrate(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: app migrate up|down|status|to VERSION")
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("missing migrate command")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if cfg.DB.Driver == config.DBDriverMemory {
+		return fmt.Errorf("the memory database has no schema to migrate")
+	}
+
+	db, err := storage.OpenDB(cfg.DB)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %v", err)
+	}
+	defer db.Close()
+
+	migrator, err := storage.NewMigrator(db, cfg.DB.Driver)
+	if err != nil {
+		return err
+	}
+
+	var results []*goose.MigrationResult
+	switch command := flags.Arg(0); command {
+	case "up":
+		results, err = migrator.Up(ctx)
+	case "down":
+		var result *goose.MigrationResult
+		if result, err = migrator.Down(ctx); result != nil {
+			results = append(results, result)
+		}
+	case "to":
+		if flags.NArg() != 2 {
+			return fmt.Errorf("usage: app migrate to VERSION")
+		}
+		version, parseErr := strconv.ParseInt(flags.Arg(1), 10, 64)
+		if parseErr != nil || version < 0 {
+			return fmt.Errorf("invalid version %q", flags.Arg(1))
+		}
+		results, err = migrateTo(ctx, migrator, version)
+	case "status":
+		return printMigrationStatus(ctx, migrator)
+	default:
+		flags.Usage()
+		return fmt.Errorf("unknown migrate command %q", command)
+	}
+
+	for _, result := range results {
+		fmt.Println(result)
+	}
+	if err != nil {
+		return err
+	}
+
+	current, err := migrator.GetDBVersion(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("database version %d\n", current)
+	return nil
+}
+
+// migrateTo applies or rolls back migrations until the database is at version
+func migrateTo(ctx context.Context, migrator *goose.Provider, version int64) ([]*goose.MigrationResult, error) {
+	current, err := migrator.GetDBVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if version < current {
+		return migrator.DownTo(ctx, version)
+	}
+
+	for _, source := range migrator.ListSources() {
+		if source.Version == version {
+			return migrator.UpTo(ctx, version)
+		}
+	}
+	if version == current {
+		return nil, nil
+	}
+	return nil, fmt.Errorf("no migration with version %d", version)
+}
+
+func printMigrationStatus(ctx context.Context, migrator *goose.Provider) error {
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tSTATE\tAPPLIED AT\tMIGRATION")
+	for _, status := range statuses {
+		appliedAt := "-"
+		if status.State == goose.StateApplied {
+			appliedAt = status.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", status.Source.Version, status.State, appliedAt, path.Base(status.Source.Path))
+	}
+	return w.Flush()
+}
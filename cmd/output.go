@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// output formats of the commands
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputCSV   = "csv"
+)
+
+func outputFlag(flags *flag.FlagSet, fallback string) *string {
+	return flags.String("o", fallback, "output format: table, json or csv")
+}
+
+// recordWriter writes command results as they are produced. Table and CSV output get the row,
+// JSON output gets the record itself and holds all records in one array
+type recordWriter interface {
+	Write(record any, row []string) error
+	Close() error
+}
+
+func newRecordWriter(w io.Writer, format string, header []string) (recordWriter, error) {
+	switch format {
+	case outputTable:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.Join(header, "\t"))
+		return &tableWriter{w: tw}, nil
+	case outputCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(header); err != nil {
+			return nil, err
+		}
+		return &csvWriter{w: cw}, nil
+	case outputJSON:
+		return &jsonWriter{w: w}, nil
+	default:
+		return nil, fmt.Errorf("unknown output format %q", format)
+	}
+}
+
+type tableWriter struct {
+	w *tabwriter.Writer
+}
+
+func (t *tableWriter) Write(_ any, row []string) error {
+	for i, cell := range row {
+		if cell == "" {
+			row[i] = "-"
+		}
+	}
+	_, err := fmt.Fprintln(t.w, strings.Join(row, "\t"))
+	return err
+}
+
+func (t *tableWriter) Close() error {
+	return t.w.Flush()
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvWriter) Write(_ any, row []string) error {
+	return c.w.Write(row)
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type jsonWriter struct {
+	w       io.Writer
+	written bool
+}
+
+func (j *jsonWriter) Write(record any, _ []string) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	sep := ",\n"
+	if !j.written {
+		sep, j.written = "[\n", true
+	}
+	_, err = fmt.Fprintf(j.w, "%s  %s", sep, data)
+	return err
+}
+
+func (j *jsonWriter) Close() error {
+	if !j.written {
+		_, err := fmt.Fprintln(j.w, "[]")
+		return err
+	}
+	_, err := fmt.Fprintln(j.w, "\n]")
+	return err
+}
+
+func formatCoordinate(val float64) string {
+	return strconv.FormatFloat(val, 'f', -1, 64)
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
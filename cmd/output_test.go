@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRecordWriter(t *testing.T) {
+	type record struct {
+		IP   string `json:"ip"`
+		City string `json:"city"`
+	}
+
+	tests := []struct {
+		name    string
+		format  string
+		records []record
+		want    string
+		wantErr bool
+	}{
+		{"table", outputTable, []record{{"8.8.8.8", "Ashburn"}, {"10.0.0.1", ""}}, "ip        city\n8.8.8.8   Ashburn\n10.0.0.1  -\n", false},
+		{"empty table", outputTable, nil, "ip  city\n", false},
+		{"csv", outputCSV, []record{{"8.8.8.8", "Ashburn, VA"}, {"10.0.0.1", ""}}, "ip,city\n8.8.8.8,\"Ashburn, VA\"\n10.0.0.1,\n", false},
+		{"json", outputJSON, []record{{"8.8.8.8", "Ashburn"}, {"10.0.0.1", ""}}, "[\n  {\"ip\":\"8.8.8.8\",\"city\":\"Ashburn\"},\n  {\"ip\":\"10.0.0.1\",\"city\":\"\"}\n]\n", false},
+		{"empty json", outputJSON, nil, "[]\n", false},
+		{"unknown format", "xml", nil, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			out, err := newRecordWriter(&buf, tt.format, []string{"ip", "city"})
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+
+			for _, r := range tt.records {
+				assert.NoError(t, out.Write(r, []string{r.IP, r.City}))
+			}
+			assert.NoError(t, out.Close())
+			assert.Equal(t, tt.want, buf.String())
+		})
+	}
+}
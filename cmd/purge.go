@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/Fyefhqdishka/LocFinder/internal/config"
+	"github.com/Fyefhqdishka/LocFinder/internal/export"
+	"github.com/Fyefhqdishka/LocFinder/internal/models"
+	"github.com/Fyefhqdishka/LocFinder/internal/storage/repositoryInterfaces"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// runPurge deletes stored locations matching the filters of GET /locations and lists them.
+// Networks are deleted one by one, nested networks not matching the filters are kept:
+//
+//	app purge -provider ipapi -created-to 2025-01-01
+//	app purge -dry-run -cidr 10.0.0.0/8
+func runPurge(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("purge", flag.ExitOnError)
+	format := outputFlag(flags, outputTable)
+	filters := newQueryFlags(flags)
+	all := flags.Bool("all", false, "delete all stored locations, required when no filter is set")
+	dryRun := flags.Bool("dry-run", false, "list the locations without deleting them")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: app purge [-o table|json|csv] [-dry-run] -all|filters")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if filters.empty() && !*all {
+		flags.Usage()
+		return fmt.Errorf("set a filter or -all")
+	}
+	query, err := filters.query()
+	if err != nil {
+		return err
+	}
+	query.Sort = models.SortNetwork
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	backend, locService, err := openService(cfg)
+	if err != nil {
+		return err
+	}
+	defer backend.Close()
+	defer locService.Close()
+
+	// the matching networks are listed before deleting, so a failed listing deletes nothing
+	var matching []models.IPLocation
+	err = locService.ExportLocations(ctx, query, func(l models.IPLocation) error {
+		matching = append(matching, l)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	out, err := newRecordWriter(os.Stdout, *format, export.Header)
+	if err != nil {
+		return err
+	}
+
+	deleted := 0
+	for _, l := range matching {
+		if !*dryRun {
+			err := backend.Locations.DeleteNetwork(ctx, l.Network)
+			if errors.Is(err, repositoryInterfaces.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				out.Close()
+				return fmt.Errorf("failed to delete %s after %d deleted: %v", l.Network, deleted, err)
+			}
+		}
+		deleted++
+		if err := out.Write(l, export.Record(l)); err != nil {
+			return err
+		}
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	if *dryRun {
+		fmt.Fprintf(os.Stderr, "%d locations would be deleted\n", deleted)
+	} else {
+		fmt.Fprintf(os.Stderr, "deleted %d locations\n", deleted)
+	}
+	return nil
+}
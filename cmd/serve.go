@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/Fyefhqdishka/LocFinder/internal/app"
+	"github.com/Fyefhqdishka/LocFinder/internal/config"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// runServe starts the HTTP server and stops it gracefully on SIGINT or SIGTERM:
+//
+//	app serve
+func runServe(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	flags.Parse(args)
+
+	app, err := app.New(cfg)
+	if err != nil {
+		return fmt.Errorf("can't load server, err: %v", err)
+	}
+
+	go func() {
+		if err := app.Run(); err != nil {
+			log.Fatalf("server failed: %v", err)
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	<-quit
+	log.Println("shutting down...")
+	if err := app.Stop(); err != nil {
+		log.Printf("error during shutdown: %v", err)
+	}
+	return nil
+}
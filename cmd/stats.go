@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/Fyefhqdishka/LocFinder/internal/config"
+	"github.com/Fyefhqdishka/LocFinder/internal/storage"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// runStats reports the number of stored locations by address family, provider and country:
+//
+//	app stats
+//	app stats -o json -top 20
+func runStats(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("stats", flag.ExitOnError)
+	format := outputFlag(flags, outputTable)
+	top := flags.Int("top", 10, "number of countries to report")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: app stats [-o table|json|csv] [-top n]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if *top < 0 {
+		return fmt.Errorf("invalid -top %d", *top)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	backend, err := storage.Open(cfg.DB, commandLog())
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %v", err)
+	}
+	defer backend.Close()
+
+	var staleBefore time.Time
+	if cfg.Refresh.TTL > 0 {
+		staleBefore = time.Now().Add(-cfg.Refresh.TTL)
+	}
+	stats, err := backend.Locations.Summarize(ctx, staleBefore, *top)
+	if err != nil {
+		return err
+	}
+
+	if *format == outputJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(stats)
+	}
+
+	out, err := newRecordWriter(os.Stdout, *format, []string{"metric", "name", "value"})
+	if err != nil {
+		return err
+	}
+	rows := [][]string{
+		{"total", "", strconv.FormatInt(stats.Total, 10)},
+		{"family", "ipv4", strconv.FormatInt(stats.IPv4, 10)},
+		{"family", "ipv6", strconv.FormatInt(stats.IPv6, 10)},
+		{"stale", "", strconv.FormatInt(stats.Stale, 10)},
+		{"created", "oldest", formatTime(stats.Oldest)},
+		{"created", "newest", formatTime(stats.Newest)},
+	}
+	for _, provider := range stats.Providers {
+		rows = append(rows, []string{"provider", provider.Name, strconv.FormatInt(provider.Count, 10)})
+	}
+	for _, country := range stats.Countries {
+		rows = append(rows, []string{"country", country.Name, strconv.FormatInt(country.Count, 10)})
+	}
+	for _, row := range rows {
+		if err := out.Write(nil, row); err != nil {
+			return err
+		}
+	}
+	return out.Close()
+}
@@ -2,10 +2,10 @@ package app
 
 import (
 	"context"
-	"database/sql"
 	"fmt"
 	"github.com/Fyefhqdishka/LocFinder/internal/config"
 	"github.com/Fyefhqdishka/LocFinder/internal/handlers"
+	"github.com/Fyefhqdishka/LocFinder/internal/importer"
 	"github.com/Fyefhqdishka/LocFinder/internal/service"
 	"github.com/Fyefhqdishka/LocFinder/internal/storage"
 	"github.com/Fyefhqdishka/LocFinder/internal/storage/repositories"
@@ -15,33 +15,61 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
+	"sync"
 	"time"
 )
 
 type App struct {
-	db     *sql.DB
-	server *http.Server
-	log    *slog.Logger
+	storage *storage.Backend
+	server  *http.Server
+	service *service.LocService
+	admin   *handlers.AdminHandler
+	refresh config.Refresh
+	log     *slog.Logger
+
+	// ctx is cancelled by Stop, background work of the app runs with it and is tracked by tasks
+	ctx    context.Context
+	cancel context.CancelFunc
+	tasks  sync.WaitGroup
 }
 
 func (s *App) Run() error {
+	if s.refresh.Interval > 0 && s.refresh.TTL > 0 {
+		s.tasks.Add(1)
+		go func() {
+			defer s.tasks.Done()
+			s.runRefresher(s.ctx)
+		}()
+	}
+
 	if err := s.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		return fmt.Errorf("server error: %v", err)
 	}
 	return nil
 }
 
+// Stop waits for in-flight requests and background work, then closes the service and the storage
 func (s *App) Stop() error {
 	var errs []error
 
-	if err := s.db.Close(); err != nil {
-		errs = append(errs, fmt.Errorf("failed to close database: %v", err))
-	}
-
 	if err := s.server.Shutdown(context.Background()); err != nil {
 		errs = append(errs, fmt.Errorf("failed to shutdown server: %v", err))
 	}
 
+	s.cancel()
+	s.tasks.Wait()
+	if s.admin != nil {
+		s.admin.Wait()
+	}
+
+	if err := s.service.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close location service: %v", err))
+	}
+
+	if err := s.storage.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close database: %v", err))
+	}
+
 	if len(errs) > 0 {
 		return fmt.Errorf("shutdown errors: %v", errs)
 	}
@@ -51,43 +79,57 @@ func (s *App) Stop() error {
 
 // New creates new instance of application, sets the dependencies and applies migrations
 func New(cfg *config.Config) (*App, error) {
-	connStr := fmt.Sprintf("postgresql://%s:%s@%s:%s/%s?sslmode=disable",
-		cfg.DB.User,
-		cfg.DB.Pass,
-		cfg.DB.Host,
-		cfg.DB.Port,
-		cfg.DB.Name,
-	)
-
-	db, err := storage.ConnectDB(connStr)
+	log := initLogging()
+
+	backend, err := storage.Open(cfg.DB, log)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to database: %v", err)
 	}
 
-	if err := db.Ping(); err != nil {
-		return nil, fmt.Errorf("failed to ping database: %v", err)
+	locRepo := backend.Locations
+	if cfg.Cache.Size > 0 {
+		locRepo = repositories.NewCachedRepository(locRepo, cfg.Cache.Size, cfg.Cache.TTL)
 	}
 
-	log := initLogging()
+	rangeRepo := backend.Ranges
 
-	locRepo := repositories.NewLocRepository(db, log)
-	locService := service.NewLocService(locRepo, log)
-	locHandler := handlers.NewLocHandler(locService, log)
+	locService, err := service.NewLocService(locRepo, rangeRepo, cfg, log)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create location service: %v", err)
+	}
+	locHandler := handlers.NewLocHandler(locService, cfg.ClientIP, cfg.Geo.BatchMaxSize, log)
 
 	r := mux.NewRouter()
 	corsHandler := cors.New(cors.Options{
 		AllowedOrigins: []string{"http://localhost:5173"},
-		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowedHeaders: []string{"Content-Type", "Authorization"},
 	}).Handler(r)
 	routes.RegisterRoutes(r, *locHandler)
+	ctx, cancel := context.WithCancel(context.Background())
+	var adminHandler *handlers.AdminHandler
+	switch {
+	case cfg.Admin.Token == "":
+	case cfg.Admin.ImportDir == "":
+		// without a directory the importer would read any file of the server
+		log.Warn("admin import is disabled, IMPORT_DIR is not set")
+	default:
+		adminHandler = handlers.NewAdminHandler(ctx, importer.New(rangeRepo, cfg.Admin.ImportDir, log), cfg.Admin.Token, log)
+		routes.AdminRoutes(r, *adminHandler)
+	}
 
 	addr := fmt.Sprintf("%s:%s", cfg.Server.Host, cfg.Server.Port)
 
-	log.Info("server starting in port", cfg.Server.Port)
+	log.Info("server starting", "port", cfg.Server.Port, "db_driver", cfg.DB.Driver)
 
 	app := &App{
-		db: db,
+		ctx:     ctx,
+		cancel:  cancel,
+		admin:   adminHandler,
+		storage: backend,
+		service: locService,
+		refresh: cfg.Refresh,
+		log:     log,
 		server: &http.Server{
 			Addr:         addr,
 			Handler:      corsHandler,
@@ -100,6 +142,29 @@ func New(cfg *config.Config) (*App, error) {
 	return app, nil
 }
 
+// runRefresher periodically re-fetches the oldest expired locations,
+// at most refresh.BatchSize of them per refresh.Interval
+func (s *App) runRefresher(ctx context.Context) {
+	ticker := time.NewTicker(s.refresh.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			refreshed, err := s.service.RefreshStale(ctx, s.refresh.BatchSize)
+			if err != nil {
+				s.log.Error("background refresh failed", "error", err)
+				continue
+			}
+			if refreshed > 0 {
+				s.log.Info("stale locations refreshed", "count", refreshed)
+			}
+		}
+	}
+}
+
 func initLogging() *slog.Logger {
 	logFileName := "logs/app-" + time.Now().Format("2006-01-02") + ".log"
 	logfile, err := os.OpenFile(logFileName, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
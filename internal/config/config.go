@@ -2,21 +2,48 @@ package config
 
 import (
 	"fmt"
+	"github.com/Fyefhqdishka/LocFinder/internal/ipaddr"
+	"net/netip"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type Config struct {
-	DB     DB
-	Server Server
+	DB       DB
+	Server   Server
+	Geo      Geo
+	ClientIP ClientIP
+	Refresh  Refresh
+	Cache    Cache
+	Network  Network
+	Admin    Admin
 }
 
+// DB selects the storage backend by Driver. Host, Port, User, Pass and Name configure Postgres,
+// Path is the SQLite database file. The memory driver keeps locations until restart.
+// With AutoMigrate off the schema is changed only by the migrate command
 type DB struct {
-	Host string
-	Port string
-	User string
-	Pass string
-	Name string
+	Driver      string
+	Host        string
+	Port        string
+	User        string
+	Pass        string
+	Name        string
+	Path        string
+	AutoMigrate bool
+}
+
+const (
+	DBDriverPostgres = "postgres"
+	DBDriverSQLite   = "sqlite"
+	DBDriverMemory   = "memory"
+)
+
+// ConnString returns the Postgres connection string
+func (d DB) ConnString() string {
+	return fmt.Sprintf("postgresql://%s:%s@%s:%s/%s?sslmode=disable", d.User, d.Pass, d.Host, d.Port, d.Name)
 }
 
 type Server struct {
@@ -26,25 +53,198 @@ type Server struct {
 	IdleTimeout time.Duration
 }
 
+// Geo describes which geolocation providers the service uses.
+// Providers are tried in the listed order until one of them answers
+type Geo struct {
+	Providers []string
+	// Timeout is the default time limit of a single provider request
+	Timeout time.Duration
+	// Timeouts overrides Timeout per provider name
+	Timeouts map[string]time.Duration
+	// RateLimits holds allowed requests per minute per provider name, zero means unlimited
+	RateLimits map[string]int
+	// RateLimitMaxWait is how long a request may wait for the provider quota
+	RateLimitMaxWait time.Duration
+	// BatchWorkers limits concurrent provider calls of a batch lookup
+	BatchWorkers int
+	// BatchMaxSize limits the number of IPs accepted by a batch lookup
+	BatchMaxSize int
+	Retry        Retry
+	Breaker      Breaker
+	IPAPI        IPAPI
+	IPWhois      IPWhois
+	MMDB         MMDB
+}
+
+// ProviderTimeout returns the request time limit of the named provider
+func (g Geo) ProviderTimeout(name string) time.Duration {
+	if timeout, ok := g.Timeouts[name]; ok {
+		return timeout
+	}
+	return g.Timeout
+}
+
+// Retry controls retries of transient provider errors (network failures, timeouts, 5xx).
+// Attempts is the total number of calls, delays grow exponentially from BaseDelay up to MaxDelay with random jitter
+type Retry struct {
+	Attempts  int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// Breaker opens the circuit of a provider after Threshold consecutive transient failures,
+// the provider is skipped for OpenTimeout and then probed again. Zero Threshold disables it
+type Breaker struct {
+	Threshold   int
+	OpenTimeout time.Duration
+}
+
+// IPAPI configures ip-api.com. Its /batch endpoint has its own quota, BatchRateLimit holds
+// allowed batch requests per minute, zero means unlimited
+type IPAPI struct {
+	BaseURL        string
+	BatchRateLimit int
+}
+
+type IPWhois struct {
+	BaseURL string
+}
+
+// MMDB points to a local GeoLite2/GeoIP2 City database used for offline lookups
+type MMDB struct {
+	Path string
+}
+
+// ClientIP controls how GET /location detects the address to locate when no ip is given.
+// In "request" mode the caller's address is taken from the request, forwarding headers are
+// trusted only from TrustedProxies. In "external" mode the server's public IP is used
+type ClientIP struct {
+	Mode           string
+	TrustedProxies []netip.Prefix
+}
+
+const (
+	ClientIPModeRequest  = "request"
+	ClientIPModeExternal = "external"
+)
+
+// Refresh controls how long stored locations are considered fresh. Expired locations are
+// fetched again on lookup, or served as is and refreshed in background when ServeStale is set.
+// Every Interval the oldest BatchSize expired locations are refreshed, which bounds provider usage.
+// Zero TTL disables expiration, zero Interval disables the background refresh
+type Refresh struct {
+	TTL        time.Duration
+	ServeStale bool
+	Interval   time.Duration
+	BatchSize  int
+}
+
+// Cache configures the in-memory LRU cache in front of the database, zero Size disables it
+type Cache struct {
+	Size int
+	TTL  time.Duration
+}
+
+// Network sets the prefix length of the range a location is stored for when the provider
+// doesn't report the network itself. 32 and 128 store every address separately
+type Network struct {
+	IPv4Prefix int
+	IPv6Prefix int
+}
+
+// Admin protects administrative endpoints, they are not served while Token is empty.
+// ImportDir restricts the files the import endpoint may read, the endpoint is not served without it
+type Admin struct {
+	Token     string
+	ImportDir string
+}
+
+const DefaultSQLitePath = "locfinder.db"
+
 // default value for write and read timeouts
 const (
 	DefaultTimeout     = 10 * time.Second
 	DefaultIdleTimeout = 60 * time.Second
 )
 
+// default geolocation provider settings
+const (
+	DefaultGeoProviders        = "ipapi"
+	DefaultGeoTimeout          = 5 * time.Second
+	DefaultBatchWorkers        = 8
+	DefaultBatchMaxSize        = 5000
+	DefaultIPAPIRateLimit      = 45
+	DefaultIPAPIBatchRateLimit = 15
+	DefaultRateLimitWait       = 2 * time.Second
+	DefaultIPAPIBaseURL        = "http://ip-api.com"
+	DefaultIPWhoisBaseURL      = "https://ipwho.is"
+)
+
+// default resilience settings of provider calls
+const (
+	DefaultRetryAttempts      = 3
+	DefaultRetryBaseDelay     = 100 * time.Millisecond
+	DefaultRetryMaxDelay      = time.Second
+	DefaultBreakerThreshold   = 5
+	DefaultBreakerOpenTimeout = 30 * time.Second
+)
+
+// default freshness settings of stored locations
+const (
+	DefaultLocationTTL      = 30 * 24 * time.Hour
+	DefaultRefreshInterval  = 10 * time.Minute
+	DefaultRefreshBatchSize = 40
+)
+
+// default prefix lengths of stored networks
+const (
+	DefaultIPv4Prefix = 24
+	DefaultIPv6Prefix = 48
+)
+
+// default in-memory cache settings
+const (
+	DefaultCacheSize = 10000
+	DefaultCacheTTL  = 5 * time.Minute
+)
+
 func LoadFromEnv() (*Config, error) {
 	cfg := &Config{
 		DB: DB{
-			Host: os.Getenv("DB_HOST"),
-			Port: os.Getenv("DB_PORT"),
-			User: os.Getenv("DB_USER"),
-			Pass: os.Getenv("DB_PASS"),
-			Name: os.Getenv("DB_NAME"),
+			Driver: getEnv("DB_DRIVER", DBDriverPostgres),
+			Host:   os.Getenv("DB_HOST"),
+			Port:   os.Getenv("DB_PORT"),
+			User:   os.Getenv("DB_USER"),
+			Pass:   os.Getenv("DB_PASS"),
+			Name:   os.Getenv("DB_NAME"),
+			Path:   getEnv("SQLITE_PATH", DefaultSQLitePath),
 		},
 		Server: Server{
 			Host: os.Getenv("SRV_HOST"),
 			Port: os.Getenv("SRV_PORT"),
 		},
+		Admin: Admin{
+			Token:     os.Getenv("ADMIN_TOKEN"),
+			ImportDir: os.Getenv("IMPORT_DIR"),
+		},
+		Geo: Geo{
+			Providers: splitList(getEnv("GEO_PROVIDERS", DefaultGeoProviders)),
+			IPAPI: IPAPI{
+				BaseURL: getEnv("IPAPI_BASE_URL", DefaultIPAPIBaseURL),
+			},
+			IPWhois: IPWhois{
+				BaseURL: getEnv("IPWHOIS_BASE_URL", DefaultIPWhoisBaseURL),
+			},
+			MMDB: MMDB{
+				Path: os.Getenv("MMDB_PATH"),
+			},
+		},
+	}
+
+	switch cfg.DB.Driver {
+	case DBDriverPostgres, DBDriverSQLite, DBDriverMemory:
+	default:
+		return nil, fmt.Errorf("invalid DB_DRIVER: %q", cfg.DB.Driver)
 	}
 
 	timeout := DefaultTimeout
@@ -68,5 +268,177 @@ func LoadFromEnv() (*Config, error) {
 	cfg.Server.Timeout = timeout
 	cfg.Server.IdleTimeout = idleTimeout
 
+	var err error
+	if cfg.DB.AutoMigrate, err = getBool("DB_AUTO_MIGRATE", true); err != nil {
+		return nil, err
+	}
+
+	if cfg.Geo.Timeout, err = getDuration("GEO_PROVIDER_TIMEOUT", DefaultGeoTimeout); err != nil {
+		return nil, err
+	}
+	cfg.Geo.Timeouts = make(map[string]time.Duration)
+	for _, name := range cfg.Geo.Providers {
+		key := "GEO_PROVIDER_TIMEOUT_" + strings.ToUpper(name)
+		if os.Getenv(key) == "" {
+			continue
+		}
+		if cfg.Geo.Timeouts[name], err = getDuration(key, cfg.Geo.Timeout); err != nil {
+			return nil, err
+		}
+	}
+	// ip-api.com free tier allows 45 requests per minute to /json and bans clients exceeding it
+	cfg.Geo.RateLimits = map[string]int{"ipapi": DefaultIPAPIRateLimit}
+	for _, name := range cfg.Geo.Providers {
+		key := "GEO_RATE_LIMIT_" + strings.ToUpper(name)
+		if cfg.Geo.RateLimits[name], err = getNonNegativeInt(key, cfg.Geo.RateLimits[name]); err != nil {
+			return nil, err
+		}
+	}
+	// its /batch endpoint has a separate quota of 15 requests per minute
+	if cfg.Geo.IPAPI.BatchRateLimit, err = getNonNegativeInt("GEO_RATE_LIMIT_IPAPI_BATCH", DefaultIPAPIBatchRateLimit); err != nil {
+		return nil, err
+	}
+	if cfg.Geo.RateLimitMaxWait, err = getDuration("GEO_RATE_LIMIT_MAX_WAIT", DefaultRateLimitWait); err != nil {
+		return nil, err
+	}
+	if cfg.Geo.BatchWorkers, err = getPositiveInt("GEO_BATCH_WORKERS", DefaultBatchWorkers); err != nil {
+		return nil, err
+	}
+	if cfg.Geo.BatchMaxSize, err = getPositiveInt("GEO_BATCH_MAX_SIZE", DefaultBatchMaxSize); err != nil {
+		return nil, err
+	}
+	if cfg.Geo.Retry.Attempts, err = getPositiveInt("GEO_RETRY_ATTEMPTS", DefaultRetryAttempts); err != nil {
+		return nil, err
+	}
+	if cfg.Geo.Retry.BaseDelay, err = getDuration("GEO_RETRY_BASE_DELAY", DefaultRetryBaseDelay); err != nil {
+		return nil, err
+	}
+	if cfg.Geo.Retry.MaxDelay, err = getDuration("GEO_RETRY_MAX_DELAY", DefaultRetryMaxDelay); err != nil {
+		return nil, err
+	}
+	if cfg.Geo.Breaker.Threshold, err = getNonNegativeInt("GEO_BREAKER_THRESHOLD", DefaultBreakerThreshold); err != nil {
+		return nil, err
+	}
+	if cfg.Geo.Breaker.OpenTimeout, err = getDuration("GEO_BREAKER_OPEN_TIMEOUT", DefaultBreakerOpenTimeout); err != nil {
+		return nil, err
+	}
+
+	if cfg.Refresh.TTL, err = getDuration("LOCATION_TTL", DefaultLocationTTL); err != nil {
+		return nil, err
+	}
+	if cfg.Refresh.ServeStale, err = getBool("LOCATION_SERVE_STALE", false); err != nil {
+		return nil, err
+	}
+	if cfg.Refresh.Interval, err = getDuration("REFRESH_INTERVAL", DefaultRefreshInterval); err != nil {
+		return nil, err
+	}
+	if cfg.Refresh.BatchSize, err = getPositiveInt("REFRESH_BATCH_SIZE", DefaultRefreshBatchSize); err != nil {
+		return nil, err
+	}
+
+	if len(cfg.Geo.Providers) == 0 {
+		return nil, fmt.Errorf("GEO_PROVIDERS must contain at least one provider")
+	}
+
+	if cfg.Cache.Size, err = getNonNegativeInt("CACHE_SIZE", DefaultCacheSize); err != nil {
+		return nil, err
+	}
+	if cfg.Cache.TTL, err = getDuration("CACHE_TTL", DefaultCacheTTL); err != nil {
+		return nil, err
+	}
+
+	if cfg.Network.IPv4Prefix, err = getPositiveInt("NETWORK_PREFIX_IPV4", DefaultIPv4Prefix); err != nil {
+		return nil, err
+	}
+	if cfg.Network.IPv4Prefix > 32 {
+		return nil, fmt.Errorf("invalid NETWORK_PREFIX_IPV4: %d", cfg.Network.IPv4Prefix)
+	}
+	if cfg.Network.IPv6Prefix, err = getPositiveInt("NETWORK_PREFIX_IPV6", DefaultIPv6Prefix); err != nil {
+		return nil, err
+	}
+	if cfg.Network.IPv6Prefix > 128 {
+		return nil, fmt.Errorf("invalid NETWORK_PREFIX_IPV6: %d", cfg.Network.IPv6Prefix)
+	}
+
+	cfg.ClientIP.Mode = getEnv("CLIENT_IP_MODE", ClientIPModeRequest)
+	if cfg.ClientIP.Mode != ClientIPModeRequest && cfg.ClientIP.Mode != ClientIPModeExternal {
+		return nil, fmt.Errorf("invalid CLIENT_IP_MODE: %q", cfg.ClientIP.Mode)
+	}
+
+	cfg.ClientIP.TrustedProxies, err = ipaddr.ParsePrefixes(splitList(os.Getenv("TRUSTED_PROXIES")))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TRUSTED_PROXIES: %v", err)
+	}
+
 	return cfg, nil
 }
+
+func getEnv(key, fallback string) string {
+	if val := os.Getenv(key); val != "" {
+		return val
+	}
+	return fallback
+}
+
+func getDuration(key string, fallback time.Duration) (time.Duration, error) {
+	val := os.Getenv(key)
+	if val == "" {
+		return fallback, nil
+	}
+
+	parsed, err := time.ParseDuration(val)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", key, err)
+	}
+	return parsed, nil
+}
+
+func getPositiveInt(key string, fallback int) (int, error) {
+	val := os.Getenv(key)
+	if val == "" {
+		return fallback, nil
+	}
+
+	parsed, err := strconv.Atoi(val)
+	if err != nil || parsed < 1 {
+		return 0, fmt.Errorf("invalid %s: %q", key, val)
+	}
+	return parsed, nil
+}
+
+func getNonNegativeInt(key string, fallback int) (int, error) {
+	val := os.Getenv(key)
+	if val == "" {
+		return fallback, nil
+	}
+
+	parsed, err := strconv.Atoi(val)
+	if err != nil || parsed < 0 {
+		return 0, fmt.Errorf("invalid %s: %q", key, val)
+	}
+	return parsed, nil
+}
+
+func getBool(key string, fallback bool) (bool, error) {
+	val := os.Getenv(key)
+	if val == "" {
+		return fallback, nil
+	}
+
+	parsed, err := strconv.ParseBool(val)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s: %v", key, err)
+	}
+	return parsed, nil
+}
+
+// splitList parses a comma-separated list, skipping empty items
+func splitList(val string) []string {
+	var items []string
+	for _, item := range strings.Split(val, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
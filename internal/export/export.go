@@ -0,0 +1,183 @@
+// Package export encodes stored locations for download as CSV, newline-delimited JSON or GeoJSON.
+// Encoders write every location as it comes, so a whole table can be streamed without holding it
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/Fyefhqdishka/LocFinder/internal/models"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Export formats
+const (
+	FormatCSV     = "csv"
+	FormatNDJSON  = "ndjson"
+	FormatGeoJSON = "geojson"
+)
+
+// mediaTypes maps media types of the Accept header to formats
+var mediaTypes = map[string]string{
+	"text/csv":             FormatCSV,
+	"application/x-ndjson": FormatNDJSON,
+	"application/ndjson":   FormatNDJSON,
+	"application/geo+json": FormatGeoJSON,
+}
+
+// ContentType returns the media type a format is sent with
+func ContentType(format string) string {
+	switch format {
+	case FormatCSV:
+		return "text/csv; charset=utf-8"
+	case FormatNDJSON:
+		return "application/x-ndjson"
+	case FormatGeoJSON:
+		return "application/geo+json"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// IsFormat reports whether format is one of the export formats
+func IsFormat(format string) bool {
+	switch format {
+	case FormatCSV, FormatNDJSON, FormatGeoJSON:
+		return true
+	default:
+		return false
+	}
+}
+
+// FormatFromAccept returns the export format of the first listed media type of an Accept header
+// that has one, or "" when there is none
+func FormatFromAccept(accept string) string {
+	for _, item := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(item))
+		if err != nil {
+			continue
+		}
+		if format, ok := mediaTypes[mediaType]; ok {
+			return format
+		}
+	}
+	return ""
+}
+
+// Encoder writes locations in an export format, Close completes the document
+type Encoder interface {
+	Encode(location models.IPLocation) error
+	Close() error
+}
+
+func NewEncoder(w io.Writer, format string) (Encoder, error) {
+	switch format {
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(Header); err != nil {
+			return nil, err
+		}
+		return &csvEncoder{w: cw}, nil
+	case FormatNDJSON:
+		return &ndjsonEncoder{enc: json.NewEncoder(w)}, nil
+	case FormatGeoJSON:
+		return &geoJSONEncoder{w: w}, nil
+	default:
+		return nil, fmt.Errorf("unknown export format %q", format)
+	}
+}
+
+// Header names the CSV columns, they match the JSON fields of models.IPLocation
+var Header = []string{"network", "country", "regionName", "city", "zip", "lat", "lon", "timezone", "isp", "org", "as", "provider", "createdAt", "fetchedAt"}
+
+// Record returns the CSV columns of a location
+func Record(l models.IPLocation) []string {
+	return []string{
+		l.Network, l.Country, l.Region, l.City, l.Zip,
+		strconv.FormatFloat(l.Lat, 'f', -1, 64), strconv.FormatFloat(l.Lon, 'f', -1, 64),
+		l.Timezone, l.ISP, l.Org, l.AS, l.Provider,
+		formatTime(l.CreatedAt), formatTime(l.FetchedAt),
+	}
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+type csvEncoder struct {
+	w *csv.Writer
+}
+
+func (e *csvEncoder) Encode(l models.IPLocation) error {
+	return e.w.Write(Record(l))
+}
+
+func (e *csvEncoder) Close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type ndjsonEncoder struct {
+	enc *json.Encoder
+}
+
+func (e *ndjsonEncoder) Encode(l models.IPLocation) error {
+	return e.enc.Encode(l)
+}
+
+func (e *ndjsonEncoder) Close() error {
+	return nil
+}
+
+// geoJSONEncoder writes a FeatureCollection with a Point feature per location,
+// locations without coordinates (0, 0) have a null geometry
+type geoJSONEncoder struct {
+	w       io.Writer
+	started bool
+}
+
+type feature struct {
+	Type       string            `json:"type"`
+	Geometry   *point            `json:"geometry"`
+	Properties models.IPLocation `json:"properties"`
+}
+
+// point holds the longitude and latitude, in this order
+type point struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+func (e *geoJSONEncoder) Encode(l models.IPLocation) error {
+	f := feature{Type: "Feature", Properties: l}
+	if l.Lat != 0 || l.Lon != 0 {
+		f.Geometry = &point{Type: "Point", Coordinates: [2]float64{l.Lon, l.Lat}}
+	}
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+
+	sep := ",\n"
+	if !e.started {
+		sep, e.started = `{"type":"FeatureCollection","features":[`+"\n", true
+	}
+	_, err = fmt.Fprintf(e.w, "%s%s", sep, data)
+	return err
+}
+
+func (e *geoJSONEncoder) Close() error {
+	if !e.started {
+		_, err := io.WriteString(e.w, `{"type":"FeatureCollection","features":[]}`+"\n")
+		return err
+	}
+	_, err := io.WriteString(e.w, "\n]}\n")
+	return err
+}
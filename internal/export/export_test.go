@@ -0,0 +1,101 @@
+package export_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/Fyefhqdishka/LocFinder/internal/export"
+	"github.com/Fyefhqdishka/LocFinder/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	createdAt = time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	almaty    = models.IPLocation{IP: "37.99.42.0", Network: "37.99.42.0/24", Country: "Kazakhstan", City: "Almaty, Medeu", Lat: 43.25, Lon: 76.9167, Provider: "manual", CreatedAt: &createdAt}
+	unknown   = models.IPLocation{IP: "10.1.0.0", Network: "10.1.0.0/16", Country: "Unknown", CreatedAt: &createdAt}
+)
+
+func encode(t *testing.T, format string, locations ...models.IPLocation) string {
+	var buf bytes.Buffer
+	enc, err := export.NewEncoder(&buf, format)
+	require.NoError(t, err)
+	for _, l := range locations {
+		require.NoError(t, enc.Encode(l))
+	}
+	require.NoError(t, enc.Close())
+	return buf.String()
+}
+
+func TestCSV(t *testing.T) {
+	out := encode(t, export.FormatCSV, almaty)
+	assert.Equal(t, "network,country,regionName,city,zip,lat,lon,timezone,isp,org,as,provider,createdAt,fetchedAt\n"+
+		"37.99.42.0/24,Kazakhstan,,\"Almaty, Medeu\",,43.25,76.9167,,,,,manual,2025-03-01T12:00:00Z,\n", out)
+
+	assert.Equal(t, strings.Join(export.Header, ",")+"\n", encode(t, export.FormatCSV))
+}
+
+func TestNDJSON(t *testing.T) {
+	out := encode(t, export.FormatNDJSON, almaty, unknown)
+
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	require.Len(t, lines, 2)
+	var got models.IPLocation
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &got))
+	assert.Equal(t, "10.1.0.0/16", got.Network)
+
+	assert.Empty(t, encode(t, export.FormatNDJSON))
+}
+
+func TestGeoJSON(t *testing.T) {
+	var collection struct {
+		Type     string `json:"type"`
+		Features []struct {
+			Type     string `json:"type"`
+			Geometry *struct {
+				Type        string    `json:"type"`
+				Coordinates []float64 `json:"coordinates"`
+			} `json:"geometry"`
+			Properties models.IPLocation `json:"properties"`
+		} `json:"features"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(encode(t, export.FormatGeoJSON, almaty, unknown)), &collection))
+
+	assert.Equal(t, "FeatureCollection", collection.Type)
+	require.Len(t, collection.Features, 2)
+	assert.Equal(t, "Feature", collection.Features[0].Type)
+	assert.Equal(t, "Point", collection.Features[0].Geometry.Type)
+	assert.Equal(t, []float64{76.9167, 43.25}, collection.Features[0].Geometry.Coordinates)
+	assert.Equal(t, "Almaty, Medeu", collection.Features[0].Properties.City)
+	assert.Nil(t, collection.Features[1].Geometry)
+
+	require.NoError(t, json.Unmarshal([]byte(encode(t, export.FormatGeoJSON)), &collection))
+	assert.Empty(t, collection.Features)
+}
+
+func TestFormatFromAccept(t *testing.T) {
+	tests := []struct {
+		accept string
+		format string
+	}{
+		{"text/csv", export.FormatCSV},
+		{"application/x-ndjson", export.FormatNDJSON},
+		{"application/geo+json;q=0.9, application/json", export.FormatGeoJSON},
+		{"application/json, text/csv; charset=utf-8", export.FormatCSV},
+		{"application/json", ""},
+		{"*/*", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.format, export.FormatFromAccept(tt.accept), tt.accept)
+	}
+}
+
+func TestUnknownFormat(t *testing.T) {
+	_, err := export.NewEncoder(&bytes.Buffer{}, "xml")
+	assert.Error(t, err)
+	assert.False(t, export.IsFormat("xml"))
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"github.com/Fyefhqdishka/LocFinder/internal/importer"
+	"github.com/Fyefhqdishka/LocFinder/internal/models"
+	"github.com/gorilla/mux"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// importJobsKept is the number of finished imports whose state is still served
+const importJobsKept = 20
+
+// AdminHandler serves administrative endpoints guarded by a bearer token
+type AdminHandler struct {
+	Importer importer.ImporterInterface
+	jobs     *importJobs
+	token    string
+	log      *slog.Logger
+
+	// ctx bounds background imports, running tracks them
+	ctx     context.Context
+	running *sync.WaitGroup
+}
+
+// NewAdminHandler creates the handler, imports started through it run until they finish or ctx is cancelled
+func NewAdminHandler(ctx context.Context, Importer importer.ImporterInterface, token string, log *slog.Logger) *AdminHandler {
+	return &AdminHandler{
+		Importer: Importer,
+		jobs:     &importJobs{jobs: make(map[string]*models.ImportJob)},
+		token:    token,
+		log:      log,
+		ctx:      ctx,
+		running:  &sync.WaitGroup{},
+	}
+}
+
+// Wait blocks until background imports return, the storage they write to must stay open until then
+func (h *AdminHandler) Wait() {
+	h.running.Wait()
+}
+
+// importJobs keeps the state of imports started through the endpoint, one of them runs at a time
+type importJobs struct {
+	mu      sync.Mutex
+	seq     int
+	running bool
+	jobs    map[string]*models.ImportJob
+}
+
+// start registers a running import, false is returned while another one runs
+func (j *importJobs) start(format string) (models.ImportJob, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.running {
+		return models.ImportJob{}, false
+	}
+	j.running = true
+	j.seq++
+	delete(j.jobs, strconv.Itoa(j.seq-importJobsKept))
+
+	job := &models.ImportJob{ID: strconv.Itoa(j.seq), Status: models.ImportRunning, Format: format, StartedAt: time.Now().UTC()}
+	j.jobs[job.ID] = job
+	return *job, true
+}
+
+func (j *importJobs) finish(id string, result models.ImportResult, errMsg string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	job := j.jobs[id]
+	finished := time.Now().UTC()
+	job.FinishedAt = &finished
+	if errMsg != "" {
+		job.Status, job.Error = models.ImportFailed, errMsg
+	} else {
+		job.Status, job.Result = models.ImportDone, &result
+	}
+	j.running = false
+}
+
+func (j *importJobs) get(id string) (models.ImportJob, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	job, ok := j.jobs[id]
+	if !ok {
+		return models.ImportJob{}, false
+	}
+	return *job, true
+}
+
+// authorized checks the "Authorization: Bearer <token>" header
+func (h *AdminHandler) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && h.token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) == 1
+}
+
+// Import checks the request and starts loading a GeoLite2 or IP2Location CSV dataset from files
+// on the server in the background. The import outlives the request and its write timeout,
+// the response is the job whose state ImportStatus serves
+func (h *AdminHandler) Import(w http.ResponseWriter, r *http.Request) {
+	if !h.authorized(r) {
+		h.response(w, SendError(CodeUnauthorized, "Unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	var req importer.Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.response(w, SendError(CodeInvalidRequest, "Invalid request body"), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.Importer.Check(req); err != nil {
+		h.response(w, SendError(CodeInvalidRequest, "Can't import dataset: "+err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	job, ok := h.jobs.start(req.Format)
+	if !ok {
+		h.response(w, SendError(CodeConflict, "Another import is running"), http.StatusConflict)
+		return
+	}
+	h.running.Add(1)
+	go func() {
+		defer h.running.Done()
+		h.runImport(job.ID, req)
+	}()
+
+	w.Header().Set("Location", "/admin/import/"+job.ID)
+	h.response(w, SendSuccess(job), http.StatusAccepted)
+}
+
+func (h *AdminHandler) runImport(id string, req importer.Request) {
+	result, err := h.Importer.Import(h.ctx, req)
+	switch {
+	case errors.Is(err, importer.ErrInvalidRequest):
+		h.jobs.finish(id, result, "Can't import dataset: "+err.Error())
+	case err != nil:
+		h.log.Error("Не удалось импортировать базу диапазонов", "job", id, "error", err)
+		h.jobs.finish(id, result, "Can't import dataset")
+	default:
+		h.jobs.finish(id, result, "")
+	}
+}
+
+// ImportStatus returns the state of an import started by Import
+func (h *AdminHandler) ImportStatus(w http.ResponseWriter, r *http.Request) {
+	if !h.authorized(r) {
+		h.response(w, SendError(CodeUnauthorized, "Unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	job, ok := h.jobs.get(mux.Vars(r)["id"])
+	if !ok {
+		h.response(w, SendError(CodeNotFound, "Import not found"), http.StatusNotFound)
+		return
+	}
+	h.response(w, SendSuccess(job), http.StatusOK)
+}
+
+func (h *AdminHandler) response(w http.ResponseWriter, r Response, statusCode int) {
+	writeResponse(w, r, statusCode, h.log)
+}
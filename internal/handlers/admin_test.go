@@ -0,0 +1,176 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Fyefhqdishka/LocFinder/internal/handlers"
+	"github.com/Fyefhqdishka/LocFinder/internal/importer"
+	"github.com/Fyefhqdishka/LocFinder/internal/models"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Мок импортёра
+type MockImporter struct {
+	mock.Mock
+}
+
+func (m *MockImporter) Check(req importer.Request) error {
+	args := m.Called(req)
+	return args.Error(0)
+}
+
+func (m *MockImporter) Import(ctx context.Context, req importer.Request) (models.ImportResult, error) {
+	args := m.Called(ctx, req)
+	return args.Get(0).(models.ImportResult), args.Error(1)
+}
+
+func adminRouter(h *handlers.AdminHandler) *mux.Router {
+	router := mux.NewRouter()
+	router.HandleFunc("/admin/import", h.Import).Methods("POST")
+	router.HandleFunc("/admin/import/{id}", h.ImportStatus).Methods("GET")
+	return router
+}
+
+func adminRequest(t *testing.T, router http.Handler, method, url, token, body string) *httptest.ResponseRecorder {
+	r, err := http.NewRequest(method, url, bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		r.Header.Set("Authorization", token)
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, r)
+	return rr
+}
+
+func importJob(t *testing.T, rr *httptest.ResponseRecorder) models.ImportJob {
+	var resp struct {
+		Result models.ImportJob `json:"result"`
+	}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	return resp.Result
+}
+
+func TestAdminImport(t *testing.T) {
+	mockImporter := new(MockImporter)
+	log := slog.Logger{}
+
+	router := adminRouter(handlers.NewAdminHandler(context.Background(), mockImporter, "secret", &log))
+
+	mockImporter.On("Check", importer.Request{Format: "xml", Files: []string{"db11.csv"}}).
+		Return(fmt.Errorf("%w: unknown format", importer.ErrInvalidRequest)).Once()
+
+	tests := []struct {
+		name   string
+		token  string
+		body   string
+		status int
+	}{
+		{"no token", "", `{"format":"ip2location","files":["db11.csv"]}`, http.StatusUnauthorized},
+		{"wrong token", "Bearer wrong", `{"format":"ip2location","files":["db11.csv"]}`, http.StatusUnauthorized},
+		{"invalid body", "Bearer secret", `{`, http.StatusBadRequest},
+		{"invalid request", "Bearer secret", `{"format":"xml","files":["db11.csv"]}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := adminRequest(t, router, "POST", "/admin/import", tt.token, tt.body)
+			assert.Equal(t, tt.status, rr.Code)
+		})
+	}
+
+	mockImporter.AssertExpectations(t)
+}
+
+func TestAdminImportRunsInBackground(t *testing.T) {
+	mockImporter := new(MockImporter)
+	handler := handlers.NewAdminHandler(context.Background(), mockImporter, "secret", slog.New(slog.NewTextHandler(io.Discard, nil)))
+	router := adminRouter(handler)
+
+	req := importer.Request{Format: importer.FormatIP2Location, Files: []string{"db11.csv"}}
+	release := make(chan time.Time)
+	mockImporter.On("Check", req).Return(nil)
+	mockImporter.On("Import", mock.Anything, req).WaitUntil(release).
+		Return(models.ImportResult{Format: importer.FormatIP2Location, Rows: 42, Duration: "1s"}, nil).Once()
+
+	body := `{"format":"ip2location","files":["db11.csv"]}`
+	rr := adminRequest(t, router, "POST", "/admin/import", "Bearer secret", body)
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+	job := importJob(t, rr)
+	assert.Equal(t, models.ImportRunning, job.Status)
+	assert.Equal(t, "/admin/import/"+job.ID, rr.Header().Get("Location"))
+
+	rr = adminRequest(t, router, "POST", "/admin/import", "Bearer secret", body)
+	assert.Equal(t, http.StatusConflict, rr.Code)
+
+	rr = adminRequest(t, router, "GET", "/admin/import/"+job.ID, "", "")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	rr = adminRequest(t, router, "GET", "/admin/import/404", "Bearer secret", "")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	close(release)
+	assert.Eventually(t, func() bool {
+		rr := adminRequest(t, router, "GET", "/admin/import/"+job.ID, "Bearer secret", "")
+		return importJob(t, rr).Status == models.ImportDone
+	}, time.Second, 10*time.Millisecond)
+
+	job = importJob(t, adminRequest(t, router, "GET", "/admin/import/"+job.ID, "Bearer secret", ""))
+	if assert.NotNil(t, job.Result) {
+		assert.Equal(t, int64(42), job.Result.Rows)
+	}
+	assert.NotNil(t, job.FinishedAt)
+
+	mockImporter.On("Import", mock.Anything, req).Return(models.ImportResult{}, fmt.Errorf("disk full")).Once()
+	rr = adminRequest(t, router, "POST", "/admin/import", "Bearer secret", body)
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+	failed := importJob(t, rr)
+	assert.NotEqual(t, job.ID, failed.ID)
+
+	assert.Eventually(t, func() bool {
+		rr := adminRequest(t, router, "GET", "/admin/import/"+failed.ID, "Bearer secret", "")
+		return importJob(t, rr).Status == models.ImportFailed
+	}, time.Second, 10*time.Millisecond)
+	failed = importJob(t, adminRequest(t, router, "GET", "/admin/import/"+failed.ID, "Bearer secret", ""))
+	assert.Equal(t, "Can't import dataset", failed.Error)
+
+	mockImporter.AssertExpectations(t)
+}
+
+func TestAdminImportStopsWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	mockImporter := new(MockImporter)
+	handler := handlers.NewAdminHandler(ctx, mockImporter, "secret", slog.New(slog.NewTextHandler(io.Discard, nil)))
+	router := adminRouter(handler)
+
+	req := importer.Request{Format: importer.FormatIP2Location, Files: []string{"db11.csv"}}
+	started := make(chan struct{})
+	mockImporter.On("Check", req).Return(nil)
+	mockImporter.On("Import", mock.Anything, req).Run(func(args mock.Arguments) {
+		close(started)
+		<-args.Get(0).(context.Context).Done()
+	}).Return(models.ImportResult{}, context.Canceled)
+
+	rr := adminRequest(t, router, "POST", "/admin/import", "Bearer secret", `{"format":"ip2location","files":["db11.csv"]}`)
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+	job := importJob(t, rr)
+
+	<-started
+	cancel()
+	handler.Wait()
+
+	job = importJob(t, adminRequest(t, router, "GET", "/admin/import/"+job.ID, "Bearer secret", ""))
+	assert.Equal(t, models.ImportFailed, job.Status)
+	mockImporter.AssertExpectations(t)
+}
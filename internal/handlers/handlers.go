@@ -2,37 +2,71 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Fyefhqdishka/LocFinder/internal/config"
+	"github.com/Fyefhqdishka/LocFinder/internal/export"
+	"github.com/Fyefhqdishka/LocFinder/internal/ipaddr"
 	"github.com/Fyefhqdishka/LocFinder/internal/models"
 	"github.com/Fyefhqdishka/LocFinder/internal/service"
 	"github.com/gorilla/mux"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type LocHandler struct {
-	Service service.ServiceInterface
-	log     *slog.Logger
+	Service  service.ServiceInterface
+	clientIP config.ClientIP
+	// maxLookupBody limits the body of a batch lookup, zero means unlimited
+	maxLookupBody int64
+	log           *slog.Logger
 }
 
-func NewLocHandler(Service service.ServiceInterface, log *slog.Logger) *LocHandler {
-	return &LocHandler{Service: Service, log: log}
+// lookupBytesPerIP is the room taken by an IP in the body of a batch lookup: the longest
+// address text with quotes, a separator and some whitespace
+const lookupBytesPerIP = 64
+
+// NewLocHandler creates the handler, batchMaxSize is the largest batch lookup the service
+// accepts, the body of a larger one is not read. Zero doesn't limit it
+func NewLocHandler(Service service.ServiceInterface, clientIP config.ClientIP, batchMaxSize int, log *slog.Logger) *LocHandler {
+	h := &LocHandler{Service: Service, clientIP: clientIP, log: log}
+	if batchMaxSize > 0 {
+		h.maxLookupBody = int64(batchMaxSize)*lookupBytesPerIP + 1024
+	}
+	return h
 }
 
 func (h *LocHandler) GetLocationByIP(w http.ResponseWriter, r *http.Request) {
 	ip := r.URL.Query().Get("ip")
 
-	if ip == "" {
-		var err error
-		ip, err = h.Service.GetExternalIP()
+	var err error
+	if ip != "" {
+		if ip, err = ipaddr.Normalize(ip); err != nil {
+			h.response(w, SendError(CodeInvalidIP, err.Error()), http.StatusBadRequest)
+			return
+		}
+	} else if h.clientIP.Mode == config.ClientIPModeExternal {
+		ip, err = h.Service.GetExternalIP(r.Context())
+		if err != nil {
+			h.responseError(w, "Unable to retrieve external IP", err)
+			return
+		}
+	} else {
+		addr, err := ipaddr.FromRequest(r, h.clientIP.TrustedProxies)
 		if err != nil {
-			h.response(w, SendError("Unable to retrieve external IP: "+err.Error()), http.StatusInternalServerError)
+			h.response(w, SendError(CodeInvalidIP, "Unable to detect client IP: "+err.Error()), http.StatusBadRequest)
 			return
 		}
+		ip = addr.String()
 	}
 
-	location, err := h.Service.GetLocationByIP(ip)
+	location, err := h.Service.GetLocationByIP(r.Context(), ip)
 	if err != nil {
-		h.response(w, SendError("Can't get location: "+err.Error()), http.StatusInternalServerError)
+		h.responseError(w, "Can't get location", err)
 		return
 	}
 
@@ -40,55 +74,289 @@ func (h *LocHandler) GetLocationByIP(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *LocHandler) GetLocationForProvidedIP(w http.ResponseWriter, r *http.Request) {
-	ip := mux.Vars(r)["ip"]
-	location, err := h.Service.GetLocationByIP(ip)
+	ip, err := ipaddr.Normalize(mux.Vars(r)["ip"])
 	if err != nil {
-		h.response(w, SendError("Can't get location: "+err.Error()), http.StatusInternalServerError)
+		h.response(w, SendError(CodeInvalidIP, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	location, err := h.Service.GetLocationByIP(r.Context(), ip)
+	if err != nil {
+		h.responseError(w, "Can't get location", err)
 		return
 	}
 
 	h.response(w, SendSuccess(location), http.StatusOK)
 }
 
-func (h *LocHandler) UpdateLocation(w http.ResponseWriter, r *http.Request) {
+func (h *LocHandler) LookupLocations(w http.ResponseWriter, r *http.Request) {
+	if h.maxLookupBody > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, h.maxLookupBody)
+	}
+
+	var ips []string
+	if err := json.NewDecoder(r.Body).Decode(&ips); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			h.response(w, SendError(CodeInvalidRequest, "Request body is too large, too many IP addresses in batch"), http.StatusRequestEntityTooLarge)
+			return
+		}
+		h.response(w, SendError(CodeInvalidRequest, "Invalid request body, expected JSON array of IP addresses"), http.StatusBadRequest)
+		return
+	}
+
+	if len(ips) == 0 {
+		h.response(w, SendError(CodeInvalidRequest, "At least one IP address is required"), http.StatusBadRequest)
+		return
+	}
+
+	results, err := h.Service.LookupLocations(r.Context(), ips)
+	if err != nil {
+		h.responseError(w, "Can't lookup locations", err)
+		return
+	}
+
+	h.response(w, SendSuccess(results), http.StatusOK)
+}
+
+// CreateLocation stores a manual location for the network or the single IP of the body,
+// it answers 409 when the network is already stored
+func (h *LocHandler) CreateLocation(w http.ResponseWriter, r *http.Request) {
 	var location models.IPLocation
 	if err := json.NewDecoder(r.Body).Decode(&location); err != nil {
-		h.response(w, SendError("Invalid request body"), http.StatusBadRequest)
+		h.response(w, SendError(CodeInvalidRequest, "Invalid request body"), http.StatusBadRequest)
 		return
 	}
 
-	if location.IP == "" {
-		h.response(w, SendError("IP address is required"), http.StatusBadRequest)
+	location, err := h.Service.CreateLocation(r.Context(), location)
+	if err != nil {
+		h.responseError(w, "Can't create location", err)
+		return
+	}
+
+	w.Header().Set("Location", "/location/"+location.IP)
+	h.response(w, SendSuccess(location), http.StatusCreated)
+}
+
+// ReplaceLocation creates or replaces the manual location of the IP in the path, or of the network
+// in the body containing it. It answers 201 when the location was created
+func (h *LocHandler) ReplaceLocation(w http.ResponseWriter, r *http.Request) {
+	ip, err := ipaddr.Normalize(mux.Vars(r)["ip"])
+	if err != nil {
+		h.response(w, SendError(CodeInvalidIP, err.Error()), http.StatusBadRequest)
 		return
 	}
 
-	err := h.Service.UpdateLocation(location.IP, location.Country, location.City)
+	var location models.IPLocation
+	if err := json.NewDecoder(r.Body).Decode(&location); err != nil {
+		h.response(w, SendError(CodeInvalidRequest, "Invalid request body"), http.StatusBadRequest)
+		return
+	}
+
+	location, created, err := h.Service.ReplaceLocation(r.Context(), ip, location)
 	if err != nil {
-		h.response(w, SendError("Can't update location: "+err.Error()), http.StatusInternalServerError)
+		h.responseError(w, "Can't replace location", err)
 		return
 	}
 
-	h.response(w, SendSuccess("Location updated"), http.StatusOK)
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	h.response(w, SendSuccess(location), status)
+}
+
+// PatchLocation changes the fields given in the body of the stored location of the IP in the path
+func (h *LocHandler) PatchLocation(w http.ResponseWriter, r *http.Request) {
+	ip, err := ipaddr.Normalize(mux.Vars(r)["ip"])
+	if err != nil {
+		h.response(w, SendError(CodeInvalidIP, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	var patch models.LocationPatch
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		h.response(w, SendError(CodeInvalidRequest, "Invalid request body"), http.StatusBadRequest)
+		return
+	}
+
+	location, err := h.Service.PatchLocation(r.Context(), ip, patch)
+	if err != nil {
+		h.responseError(w, "Can't update location", err)
+		return
+	}
+
+	h.response(w, SendSuccess(location), http.StatusOK)
 }
 
 func (h *LocHandler) DeleteLocation(w http.ResponseWriter, r *http.Request) {
-	ip := mux.Vars(r)["ip"]
+	ip, err := ipaddr.Normalize(mux.Vars(r)["ip"])
+	if err != nil {
+		h.response(w, SendError(CodeInvalidIP, err.Error()), http.StatusBadRequest)
+		return
+	}
 
-	err := h.Service.DeleteLocation(ip)
+	err = h.Service.DeleteLocation(r.Context(), ip)
 	if err != nil {
-		h.response(w, SendError("Can't delete location: "+err.Error()), http.StatusInternalServerError)
+		h.responseError(w, "Can't delete location", err)
 		return
 	}
 
 	h.response(w, SendSuccess("Location deleted"), http.StatusOK)
 }
 
+// GetAllLocations returns a page of stored locations. Query parameters:
+// country, city, provider, cidr (networks within it), created_from and exclusive created_to (RFC 3339 or date),
+// sort (created_at, country, city or network, "-" prefix for descending), limit and cursor.
+// The total number of matching locations is sent in X-Total-Count, the next page in X-Next-Cursor and Link.
+// CSV, NDJSON and GeoJSON requested with format or the Accept header export all matching locations instead
 func (h *LocHandler) GetAllLocations(w http.ResponseWriter, r *http.Request) {
-	locations, err := h.Service.GetAllLocations()
+	format, err := exportFormat(r)
+	if err != nil {
+		h.response(w, SendError(CodeInvalidRequest, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	query, err := parseLocationQuery(r.URL.Query())
+	if err != nil {
+		h.response(w, SendError(CodeInvalidRequest, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	if format != "" {
+		h.exportLocations(w, r, query, format)
+		return
+	}
+
+	page, err := h.Service.GetAllLocations(r.Context(), query)
 	if err != nil {
-		h.response(w, SendError("Can't fetch all locations: "+err.Error()), http.StatusInternalServerError)
+		h.responseError(w, "Can't fetch locations", err)
 		return
 	}
 
+	w.Header().Set("X-Total-Count", strconv.FormatInt(page.Total, 10))
+	if page.NextCursor != "" {
+		next := *r.URL
+		params := next.Query()
+		params.Set("cursor", page.NextCursor)
+		next.RawQuery = params.Encode()
+
+		w.Header().Set("X-Next-Cursor", page.NextCursor)
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next.RequestURI()))
+	}
+
+	locations := page.Locations
+	if locations == nil {
+		locations = []models.IPLocation{}
+	}
 	h.response(w, SendSuccess(locations), http.StatusOK)
 }
+
+// exportFormat returns the export format given by the format parameter or the Accept header,
+// it's empty for the JSON response
+func exportFormat(r *http.Request) (string, error) {
+	format := r.URL.Query().Get("format")
+	switch {
+	case format == "":
+		return export.FormatFromAccept(r.Header.Get("Accept")), nil
+	case format == "json":
+		return "", nil
+	case export.IsFormat(format):
+		return format, nil
+	default:
+		return "", fmt.Errorf("unknown format %q, expected json, csv, ndjson or geojson", format)
+	}
+}
+
+// exportLocations streams the locations matching query as they are read from the storage.
+// Errors found before anything is sent get the usual JSON response, later ones truncate the download
+func (h *LocHandler) exportLocations(w http.ResponseWriter, r *http.Request, query models.LocationQuery, format string) {
+	out := &exportWriter{w: w, format: format}
+	enc, err := export.NewEncoder(out, format)
+	if err == nil {
+		err = h.Service.ExportLocations(r.Context(), query, enc.Encode)
+	}
+	if err == nil {
+		err = enc.Close()
+	}
+
+	switch {
+	case err != nil && !out.started:
+		h.responseError(w, "Can't export locations", err)
+	case err != nil:
+		h.log.Error("Выгрузка локаций прервана", "format", format, "error", err)
+	default:
+		// an empty NDJSON export has no bytes to write
+		out.start()
+	}
+}
+
+// exportWriter sends the headers of a download with its first bytes
+type exportWriter struct {
+	w       http.ResponseWriter
+	format  string
+	started bool
+}
+
+func (e *exportWriter) start() {
+	if e.started {
+		return
+	}
+	e.started = true
+
+	e.w.Header().Set("Content-Type", export.ContentType(e.format))
+	e.w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="locations.%s"`, e.format))
+	// a download of the whole table may take longer than the server write timeout,
+	// it's still cancelled when the client goes away
+	http.NewResponseController(e.w).SetWriteDeadline(time.Time{})
+	e.w.WriteHeader(http.StatusOK)
+}
+
+func (e *exportWriter) Write(p []byte) (int, error) {
+	e.start()
+	return e.w.Write(p)
+}
+
+func parseLocationQuery(params url.Values) (models.LocationQuery, error) {
+	query := models.LocationQuery{
+		Country:  params.Get("country"),
+		City:     params.Get("city"),
+		Provider: params.Get("provider"),
+		Network:  params.Get("cidr"),
+		Cursor:   params.Get("cursor"),
+	}
+
+	if sort := params.Get("sort"); sort != "" {
+		query.Sort, query.Desc = strings.TrimPrefix(sort, "-"), strings.HasPrefix(sort, "-")
+	}
+
+	if val := params.Get("limit"); val != "" {
+		limit, err := strconv.Atoi(val)
+		if err != nil || limit < 1 {
+			return models.LocationQuery{}, fmt.Errorf("invalid limit: %q", val)
+		}
+		query.Limit = limit
+	}
+
+	var err error
+	if query.CreatedFrom, err = models.ParseCreatedTime(params.Get("created_from")); err != nil {
+		return models.LocationQuery{}, fmt.Errorf("invalid created_from: %v", err)
+	}
+	if query.CreatedTo, err = models.ParseCreatedTime(params.Get("created_to")); err != nil {
+		return models.LocationQuery{}, fmt.Errorf("invalid created_to: %v", err)
+	}
+
+	return query, nil
+}
+
+// Health reports provider circuit breakers, it answers 503 when no provider can be called
+func (h *LocHandler) Health(w http.ResponseWriter, r *http.Request) {
+	health := h.Service.Health(r.Context())
+
+	status := http.StatusOK
+	if health.Status == service.HealthUnavailable {
+		status = http.StatusServiceUnavailable
+	}
+
+	h.response(w, SendSuccess(health), status)
+}
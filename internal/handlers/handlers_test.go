@@ -2,16 +2,25 @@ package handlers_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Fyefhqdishka/LocFinder/internal/config"
 	"github.com/Fyefhqdishka/LocFinder/internal/handlers"
 	"github.com/Fyefhqdishka/LocFinder/internal/models"
+	"github.com/Fyefhqdishka/LocFinder/internal/service"
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"slices"
 	"testing"
+	"time"
 )
 
 // Мок сервиса
@@ -19,42 +28,72 @@ type MockService struct {
 	mock.Mock
 }
 
-func (m *MockService) GetExternalIP() (string, error) {
+func (m *MockService) GetExternalIP(ctx context.Context) (string, error) {
 	args := m.Called()
 	return args.String(0), args.Error(1)
 }
 
-func (m *MockService) GetLocationByIP(ip string) (*models.IPLocation, error) {
+func (m *MockService) GetLocationByIP(ctx context.Context, ip string) (*models.IPLocation, error) {
 	args := m.Called(ip)
 	return args.Get(0).(*models.IPLocation), args.Error(1)
 }
 
-func (m *MockService) UpdateLocation(ip, country, city string) error {
-	args := m.Called(ip, country, city)
-	return args.Error(0)
+func (m *MockService) LookupLocations(ctx context.Context, ips []string) ([]models.LookupResult, error) {
+	args := m.Called(ips)
+	return args.Get(0).([]models.LookupResult), args.Error(1)
+}
+
+func (m *MockService) CreateLocation(ctx context.Context, location models.IPLocation) (models.IPLocation, error) {
+	args := m.Called(location)
+	return args.Get(0).(models.IPLocation), args.Error(1)
+}
+
+func (m *MockService) ReplaceLocation(ctx context.Context, ip string, location models.IPLocation) (models.IPLocation, bool, error) {
+	args := m.Called(ip, location)
+	return args.Get(0).(models.IPLocation), args.Bool(1), args.Error(2)
+}
+
+func (m *MockService) PatchLocation(ctx context.Context, ip string, patch models.LocationPatch) (models.IPLocation, error) {
+	args := m.Called(ip, patch)
+	return args.Get(0).(models.IPLocation), args.Error(1)
 }
 
-func (m *MockService) DeleteLocation(ip string) error {
+func (m *MockService) DeleteLocation(ctx context.Context, ip string) error {
 	args := m.Called(ip)
 	return args.Error(0)
 }
 
-func (m *MockService) GetAllLocations() ([]models.IPLocation, error) {
-	args := m.Called()
-	return args.Get(0).([]models.IPLocation), args.Error(1)
+func (m *MockService) GetAllLocations(ctx context.Context, query models.LocationQuery) (models.LocationPage, error) {
+	args := m.Called(query)
+	return args.Get(0).(models.LocationPage), args.Error(1)
+}
+
+func (m *MockService) ExportLocations(ctx context.Context, query models.LocationQuery, fn func(models.IPLocation) error) error {
+	args := m.Called(query)
+	for _, location := range args.Get(0).([]models.IPLocation) {
+		if err := fn(location); err != nil {
+			return err
+		}
+	}
+	return args.Error(1)
 }
 
 // Добавляем метод FetchFromAPI
-func (m *MockService) FetchFromAPI(ip string) (models.IPLocation, error) {
+func (m *MockService) FetchFromAPI(ctx context.Context, ip string) (models.IPLocation, error) {
 	args := m.Called(ip)
 	return args.Get(0).(models.IPLocation), args.Error(1)
 }
 
+func (m *MockService) Health(ctx context.Context) models.Health {
+	args := m.Called()
+	return args.Get(0).(models.Health)
+}
+
 func TestDeleteLocation(t *testing.T) {
 	mockService := new(MockService)
 	log := slog.Logger{}
 
-	handler := handlers.NewLocHandler(mockService, &log)
+	handler := handlers.NewLocHandler(mockService, config.ClientIP{}, 0, &log)
 
 	ip := "37.99.42.212"
 
@@ -75,37 +114,126 @@ func TestDeleteLocation(t *testing.T) {
 	mockService.AssertExpectations(t)
 }
 
-func TestUpdateLocation(t *testing.T) {
+func TestDeleteLocationNotFound(t *testing.T) {
 	mockService := new(MockService)
 	log := slog.Logger{}
 
-	handler := handlers.NewLocHandler(mockService, &log)
+	handler := handlers.NewLocHandler(mockService, config.ClientIP{}, 0, &log)
 
-	mockService.On("UpdateLocation", "37.99.42.212", "Updated Country", "Updated City").Return(nil)
+	mockService.On("DeleteLocation", "37.99.42.212").Return(service.ErrNotFound)
+
+	req, err := http.NewRequest("DELETE", "/locations/37.99.42.212", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	router := mux.NewRouter()
+	router.HandleFunc("/locations/{ip}", handler.DeleteLocation).Methods("DELETE")
+
+	router.ServeHTTP(rr, req)
+
+	var resp handlers.Response
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Equal(t, handlers.CodeNotFound, resp.Code)
+	mockService.AssertExpectations(t)
+}
+
+func TestReplaceLocation(t *testing.T) {
+	mockService := new(MockService)
+	log := slog.Logger{}
+
+	handler := handlers.NewLocHandler(mockService, config.ClientIP{}, 0, &log)
 
 	location := models.IPLocation{
-		IP:      "37.99.42.212",
-		Country: "Updated Country",
-		City:    "Updated City",
+		Country:  "Updated Country",
+		Region:   "Updated Region",
+		City:     "Updated City",
+		Lat:      43.25,
+		Lon:      76.95,
+		Timezone: "Asia/Almaty",
 	}
+
+	stored := location
+	stored.IP, stored.Network, stored.Provider = "37.99.42.212", "37.99.42.212/32", service.ProviderManual
+	mockService.On("ReplaceLocation", "37.99.42.212", location).Return(stored, true, nil)
 	locationJSON, err := json.Marshal(location)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	req, err := http.NewRequest("PUT", "/locations", bytes.NewBuffer(locationJSON))
+	req, err := http.NewRequest("PUT", "/location/37.99.42.212", bytes.NewBuffer(locationJSON))
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	rr := httptest.NewRecorder()
 	router := mux.NewRouter()
-	router.HandleFunc("/locations", handler.UpdateLocation).Methods("PUT")
+	router.HandleFunc("/location/{ip}", handler.ReplaceLocation).Methods("PUT")
 
 	router.ServeHTTP(rr, req)
 
-	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+
+	mockService.AssertExpectations(t)
+}
+
+func TestCreateLocationConflict(t *testing.T) {
+	mockService := new(MockService)
+	log := slog.Logger{}
+
+	handler := handlers.NewLocHandler(mockService, config.ClientIP{}, 0, &log)
+
+	location := models.IPLocation{Network: "37.99.0.0/16", Country: "Kazakhstan"}
+	mockService.On("CreateLocation", location).Return(models.IPLocation{}, fmt.Errorf("%w: locations_network_key", service.ErrConflict))
+
+	req, err := http.NewRequest("POST", "/locations", bytes.NewBufferString(`{"network":"37.99.0.0/16","country":"Kazakhstan"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	router := mux.NewRouter()
+	router.HandleFunc("/locations", handler.CreateLocation).Methods("POST")
+
+	router.ServeHTTP(rr, req)
+
+	var resp handlers.Response
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Equal(t, handlers.CodeConflict, resp.Code)
+	mockService.AssertExpectations(t)
+}
+
+func TestPatchLocation(t *testing.T) {
+	mockService := new(MockService)
+	log := slog.Logger{}
+
+	handler := handlers.NewLocHandler(mockService, config.ClientIP{}, 0, &log)
+
+	city := "Astana"
+	mockService.On("PatchLocation", "37.99.42.212", models.LocationPatch{City: &city}).
+		Return(models.IPLocation{IP: "37.99.42.212", Country: "Kazakhstan", City: city}, nil)
+
+	req, err := http.NewRequest("PATCH", "/location/37.99.42.212", bytes.NewBufferString(`{"city":"Astana"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	router := mux.NewRouter()
+	router.HandleFunc("/location/{ip}", handler.PatchLocation).Methods("PATCH")
 
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
 	mockService.AssertExpectations(t)
 }
 
@@ -113,10 +241,11 @@ func TestGetAllLocations(t *testing.T) {
 	mockService := new(MockService)
 	log := slog.Logger{}
 
-	handler := handlers.NewLocHandler(mockService, &log)
+	handler := handlers.NewLocHandler(mockService, config.ClientIP{}, 0, &log)
 
-	mockService.On("GetAllLocations").Return([]models.IPLocation{
-		{IP: "37.99.42.212", Country: "Kazakhstan", City: "Almaty"},
+	mockService.On("GetAllLocations", models.LocationQuery{}).Return(models.LocationPage{
+		Locations: []models.IPLocation{{IP: "37.99.42.212", Country: "Kazakhstan", City: "Almaty"}},
+		Total:     1,
 	}, nil)
 
 	req, err := http.NewRequest("GET", "/locations", nil)
@@ -151,11 +280,71 @@ func TestGetAllLocations(t *testing.T) {
 	mockService.AssertExpectations(t)
 }
 
+func TestExportLocations(t *testing.T) {
+	mockService := new(MockService)
+	log := slog.Logger{}
+	handler := handlers.NewLocHandler(mockService, config.ClientIP{}, 0, &log)
+
+	mockService.On("ExportLocations", models.LocationQuery{Country: "Kazakhstan"}).Return([]models.IPLocation{
+		{IP: "37.99.42.0", Network: "37.99.42.0/24", Country: "Kazakhstan", City: "Almaty", Lat: 43.25, Lon: 76.9167},
+		{IP: "37.99.0.0", Network: "37.99.0.0/16", Country: "Kazakhstan"},
+	}, nil)
+
+	tests := []struct {
+		url         string
+		accept      string
+		contentType string
+		contains    string
+	}{
+		{"/locations?country=Kazakhstan&format=csv", "", "text/csv; charset=utf-8", "37.99.42.0/24,Kazakhstan,,Almaty,,43.25,76.9167,"},
+		{"/locations?country=Kazakhstan", "application/x-ndjson", "application/x-ndjson", `"network":"37.99.0.0/16"`},
+		{"/locations?country=Kazakhstan&format=geojson", "text/csv", "application/geo+json", `"coordinates":[76.9167,43.25]`},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.url, nil)
+		req.Header.Set("Accept", tt.accept)
+		rr := httptest.NewRecorder()
+		handler.GetAllLocations(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code, tt.url)
+		assert.Equal(t, tt.contentType, rr.Header().Get("Content-Type"), tt.url)
+		assert.Contains(t, rr.Header().Get("Content-Disposition"), "attachment", tt.url)
+		assert.Contains(t, rr.Body.String(), tt.contains, tt.url)
+	}
+
+	mockService.AssertNotCalled(t, "GetAllLocations", mock.Anything)
+}
+
+func TestExportLocationsErrors(t *testing.T) {
+	mockService := new(MockService)
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	handler := handlers.NewLocHandler(mockService, config.ClientIP{}, 0, log)
+
+	rr := httptest.NewRecorder()
+	handler.GetAllLocations(rr, httptest.NewRequest("GET", "/locations?format=xml", nil))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	// nothing is sent yet, so the error gets the JSON response
+	mockService.On("ExportLocations", models.LocationQuery{Sort: "zip"}).Return([]models.IPLocation{}, service.ErrInvalidFilter)
+	rr = httptest.NewRecorder()
+	handler.GetAllLocations(rr, httptest.NewRequest("GET", "/locations?sort=zip&format=csv", nil))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+
+	// a failure in the middle truncates the download
+	mockService.On("ExportLocations", models.LocationQuery{Provider: "ipapi"}).Return([]models.IPLocation{{Network: "8.8.8.0/24"}}, errors.New("connection reset"))
+	rr = httptest.NewRecorder()
+	handler.GetAllLocations(rr, httptest.NewRequest("GET", "/locations?provider=ipapi&format=ndjson", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, 1, bytes.Count(rr.Body.Bytes(), []byte("\n")))
+}
+
 func TestGetLocationByIP(t *testing.T) {
 	mockService := new(MockService)
 	log := slog.Logger{}
 
-	handler := handlers.NewLocHandler(mockService, &log)
+	handler := handlers.NewLocHandler(mockService, config.ClientIP{Mode: config.ClientIPModeExternal}, 0, &log)
 
 	mockService.On("GetExternalIP").Return("37.99.42.212", nil)
 
@@ -191,3 +380,355 @@ func TestGetLocationByIP(t *testing.T) {
 
 	mockService.AssertExpectations(t)
 }
+
+func TestGetLocationProviderDown(t *testing.T) {
+	chain, err := service.NewChainProvider(config.Geo{
+		Providers: []string{service.ProviderIPAPI},
+		IPAPI:     config.IPAPI{BaseURL: "http://127.0.0.1:1"},
+	}, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, lookupErr := chain.Lookup(context.Background(), "37.99.42.212")
+
+	mockService := new(MockService)
+	handler := handlers.NewLocHandler(mockService, config.ClientIP{}, 0, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	mockService.On("GetLocationByIP", "37.99.42.212").Return((*models.IPLocation)(nil), lookupErr)
+
+	rr := httptest.NewRecorder()
+	router := mux.NewRouter()
+	router.HandleFunc("/location/{ip}", handler.GetLocationForProvidedIP).Methods("GET")
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/location/37.99.42.212", nil))
+
+	var resp handlers.Response
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Equal(t, handlers.CodeUpstreamUnavailable, resp.Code)
+	assert.NotContains(t, resp.Message, "127.0.0.1")
+}
+
+func TestGetLocationForProvidedIPErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{
+		{"invalid query", service.ErrInvalidQuery, http.StatusBadRequest, handlers.CodeInvalidIP},
+		{"reserved range", service.ErrReservedRange, http.StatusUnprocessableEntity, handlers.CodeReservedRange},
+		{"not found", service.ErrEmptyLocation, http.StatusNotFound, handlers.CodeNotFound},
+		{"quota exceeded", service.ErrQuotaExceeded, http.StatusTooManyRequests, handlers.CodeQuotaExceeded},
+		{"unavailable", service.ErrCircuitOpen, http.StatusServiceUnavailable, handlers.CodeUpstreamUnavailable},
+		{"unknown error", errors.New("pq: relation \"locations\" does not exist"), http.StatusInternalServerError, handlers.CodeInternal},
+		// another provider could have known the IP, so it isn't reported as missing
+		{"quota over miss", errors.Join(service.ErrEmptyLocation, &service.QuotaError{Provider: "ipapi", RetryAfter: time.Second}),
+			http.StatusTooManyRequests, handlers.CodeQuotaExceeded},
+		{"reserved over quota", errors.Join(&service.QuotaError{Provider: "ipapi", RetryAfter: time.Second}, service.ErrReservedRange),
+			http.StatusUnprocessableEntity, handlers.CodeReservedRange},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := new(MockService)
+			log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+			handler := handlers.NewLocHandler(mockService, config.ClientIP{}, 0, log)
+
+			mockService.On("GetLocationByIP", "10.0.0.1").Return((*models.IPLocation)(nil), fmt.Errorf("ipapi: %w", tt.err))
+
+			req, err := http.NewRequest("GET", "/location/10.0.0.1", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rr := httptest.NewRecorder()
+			router := mux.NewRouter()
+			router.HandleFunc("/location/{ip}", handler.GetLocationForProvidedIP).Methods("GET")
+
+			router.ServeHTTP(rr, req)
+
+			var resp handlers.Response
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, tt.status, rr.Code)
+			assert.Equal(t, tt.code, resp.Code)
+			assert.NotContains(t, resp.Message, "pq:")
+			assert.NotContains(t, resp.Message, "ipapi")
+			if tt.status != http.StatusTooManyRequests {
+				assert.Empty(t, rr.Header().Get("Retry-After"))
+			}
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestInvalidIP(t *testing.T) {
+	mockService := new(MockService)
+	log := slog.Logger{}
+
+	handler := handlers.NewLocHandler(mockService, config.ClientIP{}, 0, &log)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/location/{ip}", handler.GetLocationForProvidedIP).Methods("GET")
+	router.HandleFunc("/location/{ip}", handler.DeleteLocation).Methods("DELETE")
+
+	for _, method := range []string{"GET", "DELETE"} {
+		req, err := http.NewRequest(method, "/location/not-an-ip", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code, method)
+	}
+
+	mockService.AssertExpectations(t)
+}
+
+func TestGetLocationForProvidedIPNormalizes(t *testing.T) {
+	mockService := new(MockService)
+	log := slog.Logger{}
+
+	handler := handlers.NewLocHandler(mockService, config.ClientIP{}, 0, &log)
+
+	mockService.On("GetLocationByIP", "2001:db8::1").Return(&models.IPLocation{IP: "2001:db8::1"}, nil)
+
+	req, err := http.NewRequest("GET", "/location/2001:DB8::1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	router := mux.NewRouter()
+	router.HandleFunc("/location/{ip}", handler.GetLocationForProvidedIP).Methods("GET")
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	mockService.AssertExpectations(t)
+}
+
+func TestGetLocationByClientIP(t *testing.T) {
+	mockService := new(MockService)
+	log := slog.Logger{}
+
+	handler := handlers.NewLocHandler(mockService, config.ClientIP{
+		Mode:           config.ClientIPModeRequest,
+		TrustedProxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+	}, 0, &log)
+
+	mockService.On("GetLocationByIP", "37.99.42.212").Return(&models.IPLocation{IP: "37.99.42.212"}, nil)
+
+	req, err := http.NewRequest("GET", "/location", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.RemoteAddr = "10.0.0.5:52311"
+	req.Header.Set("X-Forwarded-For", "1.2.3.4, 37.99.42.212, 10.0.0.7")
+
+	rr := httptest.NewRecorder()
+	router := mux.NewRouter()
+	router.HandleFunc("/location", handler.GetLocationByIP).Methods("GET")
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	mockService.AssertExpectations(t)
+	mockService.AssertNotCalled(t, "GetExternalIP")
+}
+
+func TestLookupLocations(t *testing.T) {
+	mockService := new(MockService)
+	log := slog.Logger{}
+
+	handler := handlers.NewLocHandler(mockService, config.ClientIP{}, 0, &log)
+
+	ips := []string{"37.99.42.212", "foo"}
+	mockService.On("LookupLocations", ips).Return([]models.LookupResult{
+		{IP: "37.99.42.212", Location: &models.IPLocation{IP: "37.99.42.212", Country: "Kazakhstan", City: "Almaty"}},
+		{IP: "foo", Error: "invalid IP address"},
+	}, nil)
+
+	body, err := json.Marshal(ips)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("POST", "/locations/lookup", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	router := mux.NewRouter()
+	router.HandleFunc("/locations/lookup", handler.LookupLocations).Methods("POST")
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var response struct {
+		Result []models.LookupResult `json:"result"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Len(t, response.Result, 2)
+	assert.Equal(t, "Almaty", response.Result[0].Location.City)
+	assert.Equal(t, "invalid IP address", response.Result[1].Error)
+
+	mockService.AssertExpectations(t)
+}
+
+func TestLookupLocationsBodyTooLarge(t *testing.T) {
+	mockService := new(MockService)
+	log := slog.Logger{}
+
+	handler := handlers.NewLocHandler(mockService, config.ClientIP{}, 2, &log)
+
+	body, err := json.Marshal(slices.Repeat([]string{"2a00:1450:4001:0830:0000:0000:0000:200e"}, 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("POST", "/locations/lookup", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	router := mux.NewRouter()
+	router.HandleFunc("/locations/lookup", handler.LookupLocations).Methods("POST")
+
+	router.ServeHTTP(rr, req)
+
+	var resp handlers.Response
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+	assert.Equal(t, handlers.CodeInvalidRequest, resp.Code)
+	mockService.AssertNotCalled(t, "LookupLocations", mock.Anything)
+}
+
+func TestGetLocationQuotaRetryAfter(t *testing.T) {
+	mockService := new(MockService)
+	log := slog.Logger{}
+
+	handler := handlers.NewLocHandler(mockService, config.ClientIP{}, 0, &log)
+
+	quotaErr := &service.QuotaError{Provider: "ipapi", RetryAfter: 42 * time.Second}
+	mockService.On("GetLocationByIP", "37.99.42.212").Return((*models.IPLocation)(nil), fmt.Errorf("all providers failed: %w", quotaErr))
+
+	req, err := http.NewRequest("GET", "/location/37.99.42.212", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	router := mux.NewRouter()
+	router.HandleFunc("/location/{ip}", handler.GetLocationForProvidedIP).Methods("GET")
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "42", rr.Header().Get("Retry-After"))
+	mockService.AssertExpectations(t)
+}
+
+func TestHealth(t *testing.T) {
+	mockService := new(MockService)
+	log := slog.Logger{}
+
+	handler := handlers.NewLocHandler(mockService, config.ClientIP{}, 0, &log)
+
+	mockService.On("Health").Return(models.Health{
+		Status:    service.HealthUnavailable,
+		Providers: []models.ProviderHealth{{Name: "ipapi", State: service.BreakerOpen, Failures: 5}},
+	})
+
+	req, err := http.NewRequest("GET", "/health", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.Health(rr, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"state":"open"`)
+	mockService.AssertExpectations(t)
+}
+
+func TestGetAllLocationsQuery(t *testing.T) {
+	mockService := new(MockService)
+	log := slog.Logger{}
+
+	handler := handlers.NewLocHandler(mockService, config.ClientIP{}, 0, &log)
+
+	query := models.LocationQuery{
+		Country:     "Kazakhstan",
+		Provider:    "ipapi",
+		Network:     "37.99.0.0/16",
+		CreatedFrom: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		CreatedTo:   time.Date(2025, 2, 1, 12, 0, 0, 0, time.UTC),
+		Sort:        models.SortCity,
+		Desc:        true,
+		Limit:       2,
+		Cursor:      "abc",
+	}
+	mockService.On("GetAllLocations", query).Return(models.LocationPage{
+		Locations: []models.IPLocation{
+			{IP: "37.99.42.212", Country: "Kazakhstan", City: "Astana"},
+			{IP: "37.99.43.1", Country: "Kazakhstan", City: "Almaty"},
+		},
+		Total:      5,
+		NextCursor: "def",
+	}, nil)
+
+	req, err := http.NewRequest("GET", "/locations?country=Kazakhstan&provider=ipapi&cidr=37.99.0.0/16"+
+		"&created_from=2025-01-01&created_to=2025-02-01T12:00:00Z&sort=-city&limit=2&cursor=abc", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.GetAllLocations(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "5", rr.Header().Get("X-Total-Count"))
+	assert.Equal(t, "def", rr.Header().Get("X-Next-Cursor"))
+	assert.Contains(t, rr.Header().Get("Link"), "cursor=def")
+	mockService.AssertExpectations(t)
+}
+
+func TestGetAllLocationsInvalidQuery(t *testing.T) {
+	mockService := new(MockService)
+	log := slog.Logger{}
+
+	handler := handlers.NewLocHandler(mockService, config.ClientIP{}, 0, &log)
+
+	mockService.On("GetAllLocations", models.LocationQuery{Sort: "ip"}).
+		Return(models.LocationPage{}, fmt.Errorf("%w: unknown sort field", service.ErrInvalidFilter))
+
+	for _, query := range []string{"limit=0", "created_from=yesterday", "sort=ip"} {
+		t.Run(query, func(t *testing.T) {
+			req, err := http.NewRequest("GET", "/locations?"+query, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rr := httptest.NewRecorder()
+			handler.GetAllLocations(rr, req)
+
+			assert.Equal(t, http.StatusBadRequest, rr.Code)
+		})
+	}
+}
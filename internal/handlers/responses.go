@@ -2,7 +2,12 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"github.com/Fyefhqdishka/LocFinder/internal/service"
+	"log/slog"
+	"math"
 	"net/http"
+	"strconv"
 )
 
 const (
@@ -10,8 +15,22 @@ const (
 	statusErr = "Error"
 )
 
+// Error codes sent in Response.Code, clients should rely on them rather than on messages
+const (
+	CodeInvalidIP           = service.CodeInvalidIP
+	CodeInvalidRequest      = service.CodeInvalidRequest
+	CodeReservedRange       = service.CodeReservedRange
+	CodeNotFound            = service.CodeNotFound
+	CodeConflict            = service.CodeConflict
+	CodeQuotaExceeded       = service.CodeQuotaExceeded
+	CodeUpstreamUnavailable = service.CodeUpstreamUnavailable
+	CodeUnauthorized        = "unauthorized"
+	CodeInternal            = service.CodeInternal
+)
+
 type Response struct {
 	Status  string `json:"status"`
+	Code    string `json:"code,omitempty"`
 	Message string `json:"message"`
 	Result  any    `json:"result"`
 }
@@ -23,19 +42,25 @@ func SendSuccess(result any) Response {
 	}
 }
 
-func SendError(msg string) Response {
+func SendError(code, msg string) Response {
 	return Response{
 		Status:  statusErr,
+		Code:    code,
 		Message: msg,
 	}
 }
 
 func (h *LocHandler) response(w http.ResponseWriter, r Response, statusCode int) {
+	writeResponse(w, r, statusCode, h.log)
+}
+
+func writeResponse(w http.ResponseWriter, r Response, statusCode int, log *slog.Logger) {
 	data, err := json.Marshal(r)
 	if err != nil {
 		msg := "can't marshal response"
-		h.log.Error(msg, ", err=", err)
-		r = SendError(msg)
+		log.Error(msg, ", err=", err)
+		r = SendError(CodeInternal, msg)
+		data, _ = json.Marshal(r)
 		statusCode = http.StatusInternalServerError
 	}
 
@@ -43,3 +68,47 @@ func (h *LocHandler) response(w http.ResponseWriter, r Response, statusCode int)
 	w.WriteHeader(statusCode)
 	w.Write(data)
 }
+
+// responseError sends an error returned by the service with the matching status and code.
+// The message is the one of service.ErrorCode, the error itself is only logged, so storage
+// details and responses of providers never reach the client.
+// When a provider quota is exhausted the client is told when to retry
+func (h *LocHandler) responseError(w http.ResponseWriter, msg string, err error) {
+	code, message := service.ErrorCode(err)
+	status := errorStatus(code)
+	switch status {
+	case http.StatusInternalServerError:
+		h.log.Error(msg, "error", err)
+		h.response(w, SendError(code, msg), status)
+		return
+	case http.StatusTooManyRequests:
+		var quotaErr *service.QuotaError
+		if errors.As(err, &quotaErr) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(quotaErr.RetryAfter.Seconds()))))
+		}
+	case http.StatusServiceUnavailable:
+		h.log.Warn(msg, "error", err)
+	}
+
+	h.response(w, SendError(code, msg+": "+message), status)
+}
+
+// errorStatus maps error codes of the service to HTTP status codes
+func errorStatus(code string) int {
+	switch code {
+	case CodeInvalidIP, CodeInvalidRequest:
+		return http.StatusBadRequest
+	case CodeReservedRange:
+		return http.StatusUnprocessableEntity
+	case CodeNotFound:
+		return http.StatusNotFound
+	case CodeConflict:
+		return http.StatusConflict
+	case CodeQuotaExceeded:
+		return http.StatusTooManyRequests
+	case CodeUpstreamUnavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
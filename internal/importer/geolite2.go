@@ -0,0 +1,169 @@
+package importer
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/Fyefhqdishka/LocFinder/internal/ipaddr"
+	"github.com/Fyefhqdishka/LocFinder/internal/models"
+	"io"
+	"net/netip"
+	"strconv"
+)
+
+// geoLite2Place is a row of GeoLite2-City-Locations-*.csv
+type geoLite2Place struct {
+	country  string
+	region   string
+	city     string
+	timezone string
+}
+
+// GeoLite2Reader streams ranges of GeoLite2-City-Blocks-IPv4/IPv6.csv files, joined with
+// places of GeoLite2-City-Locations-*.csv by geoname_id. Rows without a network or
+// a known place are skipped
+type GeoLite2Reader struct {
+	places  map[string]geoLite2Place
+	blocks  []io.Reader
+	current *csv.Reader
+	columns map[string]int
+	skipped int64
+}
+
+// NewGeoLite2Reader reads all places into memory, blocks are read lazily one file after another
+func NewGeoLite2Reader(locations io.Reader, blocks ...io.Reader) (*GeoLite2Reader, error) {
+	places, err := readGeoLite2Places(locations)
+	if err != nil {
+		return nil, err
+	}
+	return &GeoLite2Reader{places: places, blocks: blocks}, nil
+}
+
+func readGeoLite2Places(r io.Reader) (map[string]geoLite2Place, error) {
+	reader := newCSVReader(r)
+	columns, err := readHeader(reader, "geoname_id", "country_name", "subdivision_1_name", "city_name", "time_zone")
+	if err != nil {
+		return nil, fmt.Errorf("locations: %w", err)
+	}
+
+	places := make(map[string]geoLite2Place)
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return places, nil
+		}
+		if errors.Is(err, csv.ErrFieldCount) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("locations: %w", err)
+		}
+
+		places[record[columns["geoname_id"]]] = geoLite2Place{
+			country:  record[columns["country_name"]],
+			region:   record[columns["subdivision_1_name"]],
+			city:     record[columns["city_name"]],
+			timezone: record[columns["time_zone"]],
+		}
+	}
+}
+
+func (r *GeoLite2Reader) Next() (models.IPRange, error) {
+	for {
+		if r.current == nil {
+			if len(r.blocks) == 0 {
+				return models.IPRange{}, io.EOF
+			}
+
+			r.current = newCSVReader(r.blocks[0])
+			r.blocks = r.blocks[1:]
+
+			columns, err := readHeader(r.current, "network", "geoname_id", "registered_country_geoname_id", "postal_code", "latitude", "longitude")
+			if err != nil {
+				return models.IPRange{}, fmt.Errorf("blocks: %w", err)
+			}
+			r.columns = columns
+		}
+
+		record, err := r.current.Read()
+		if errors.Is(err, io.EOF) {
+			r.current = nil
+			continue
+		}
+		if errors.Is(err, csv.ErrFieldCount) {
+			r.skipped++
+			continue
+		}
+		if err != nil {
+			return models.IPRange{}, fmt.Errorf("blocks: %w", err)
+		}
+
+		rng, ok := r.parse(record)
+		if !ok {
+			r.skipped++
+			continue
+		}
+		return rng, nil
+	}
+}
+
+func (r *GeoLite2Reader) parse(record []string) (models.IPRange, bool) {
+	network, err := netip.ParsePrefix(record[r.columns["network"]])
+	if err != nil {
+		return models.IPRange{}, false
+	}
+
+	// anonymous and satellite networks have no place, only the registered country
+	place, ok := r.places[record[r.columns["geoname_id"]]]
+	if !ok {
+		if place, ok = r.places[record[r.columns["registered_country_geoname_id"]]]; !ok {
+			return models.IPRange{}, false
+		}
+	}
+
+	network = network.Masked()
+	lat, _ := strconv.ParseFloat(record[r.columns["latitude"]], 64)
+	lon, _ := strconv.ParseFloat(record[r.columns["longitude"]], 64)
+
+	return models.IPRange{
+		From:     network.Addr(),
+		To:       ipaddr.LastAddr(network),
+		Country:  place.country,
+		Region:   place.region,
+		City:     place.city,
+		Zip:      record[r.columns["postal_code"]],
+		Lat:      lat,
+		Lon:      lon,
+		Timezone: place.timezone,
+	}, true
+}
+
+// Skipped returns the number of rows skipped so far
+func (r *GeoLite2Reader) Skipped() int64 {
+	return r.skipped
+}
+
+func newCSVReader(r io.Reader) *csv.Reader {
+	reader := csv.NewReader(r)
+	reader.ReuseRecord = true
+	return reader
+}
+
+// readHeader maps column names to indexes and checks that all required columns are present
+func readHeader(reader *csv.Reader, required ...string) (map[string]int, error) {
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("can't read header: %w", err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[name] = i
+	}
+	for _, name := range required {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("%w: missing column %q", ErrInvalidRequest, name)
+		}
+	}
+	return columns, nil
+}
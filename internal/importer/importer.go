@@ -0,0 +1,200 @@
+package importer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Fyefhqdishka/LocFinder/internal/models"
+	"github.com/Fyefhqdishka/LocFinder/internal/storage/repositoryInterfaces"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Supported dataset formats
+const (
+	FormatGeoLite2    = "geolite2"
+	FormatIP2Location = "ip2location"
+)
+
+// ErrInvalidRequest is returned for an unknown format, missing or unreadable files
+var ErrInvalidRequest = errors.New("invalid import request")
+
+type ImporterInterface interface {
+	Check(req Request) error
+	Import(ctx context.Context, req Request) (models.ImportResult, error)
+}
+
+// Request describes the files of a dataset. For GeoLite2 Files are City-Blocks-IPv4/IPv6 files
+// and Locations is a City-Locations file, for IP2Location Files are DB11 CSV files
+type Request struct {
+	Format    string   `json:"format"`
+	Files     []string `json:"files"`
+	Locations string   `json:"locations"`
+}
+
+// Importer loads CSV range databases into the range storage, replacing the active dataset
+type Importer struct {
+	ranges repositoryInterfaces.RangeStorage
+	// dir restricts files to the directory, relative paths are resolved against it. Empty means any
+	// path and is meant for the command line only
+	dir string
+	log *slog.Logger
+}
+
+func New(ranges repositoryInterfaces.RangeStorage, dir string, log *slog.Logger) *Importer {
+	return &Importer{ranges: ranges, dir: dir, log: log}
+}
+
+// skippedCounter is implemented by readers skipping malformed or unusable rows
+type skippedCounter interface {
+	Skipped() int64
+}
+
+// Check validates the request and the files it names without reading them
+func (i *Importer) Check(req Request) error {
+	if len(req.Files) == 0 {
+		return fmt.Errorf("%w: no files given", ErrInvalidRequest)
+	}
+	switch req.Format {
+	case FormatGeoLite2:
+		if req.Locations == "" {
+			return fmt.Errorf("%w: GeoLite2 locations file is required", ErrInvalidRequest)
+		}
+	case FormatIP2Location:
+	default:
+		return fmt.Errorf("%w: unknown format %q", ErrInvalidRequest, req.Format)
+	}
+
+	names := req.Files
+	if req.Locations != "" {
+		names = append(slices.Clone(names), req.Locations)
+	}
+	for _, name := range names {
+		path, err := i.resolve(name)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+		}
+	}
+	return nil
+}
+
+func (i *Importer) Import(ctx context.Context, req Request) (models.ImportResult, error) {
+	if err := i.Check(req); err != nil {
+		return models.ImportResult{}, err
+	}
+
+	var closers []io.Closer
+	defer func() {
+		for _, c := range closers {
+			c.Close()
+		}
+	}()
+	open := func(name string) (io.Reader, error) {
+		f, err := i.open(name)
+		if err != nil {
+			return nil, err
+		}
+		closers = append(closers, f)
+		return f, nil
+	}
+
+	files := make([]io.Reader, len(req.Files))
+	for n, name := range req.Files {
+		f, err := open(name)
+		if err != nil {
+			return models.ImportResult{}, err
+		}
+		files[n] = f
+	}
+
+	start := time.Now()
+
+	var reader interface {
+		repositoryInterfaces.RangeReader
+		skippedCounter
+	}
+	switch req.Format {
+	case FormatGeoLite2:
+		locations, err := open(req.Locations)
+		if err != nil {
+			return models.ImportResult{}, err
+		}
+		if reader, err = NewGeoLite2Reader(locations, files...); err != nil {
+			return models.ImportResult{}, err
+		}
+	default:
+		reader = NewIP2LocationReader(files...)
+	}
+
+	i.log.Info("Импорт базы диапазонов начат", "format", req.Format, "files", req.Files)
+	rows, err := i.ranges.ReplaceRanges(ctx, reader)
+	if err != nil {
+		i.log.Error("Не удалось импортировать базу диапазонов", "format", req.Format, "error", err)
+		return models.ImportResult{}, err
+	}
+
+	result := models.ImportResult{
+		Format:   req.Format,
+		Rows:     rows,
+		Skipped:  reader.Skipped(),
+		Duration: time.Since(start).Round(time.Millisecond).String(),
+	}
+	i.log.Info("Импорт базы диапазонов завершён", "format", result.Format, "rows", result.Rows, "skipped", result.Skipped, "duration", result.Duration)
+	return result, nil
+}
+
+func (i *Importer) open(name string) (*os.File, error) {
+	path, err := i.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+	return f, nil
+}
+
+// resolve returns the path of the named file, which must stay inside the import directory
+// after following symbolic links
+func (i *Importer) resolve(name string) (string, error) {
+	if i.dir == "" {
+		return name, nil
+	}
+
+	path := name
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(i.dir, path)
+	}
+	if !within(i.dir, path) {
+		return "", fmt.Errorf("%w: %s is outside of the import directory", ErrInvalidRequest, name)
+	}
+
+	dir, err := filepath.EvalSymlinks(i.dir)
+	if err != nil {
+		return "", fmt.Errorf("%w: import directory: %v", ErrInvalidRequest, err)
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+	if !within(dir, resolved) {
+		return "", fmt.Errorf("%w: %s is outside of the import directory", ErrInvalidRequest, name)
+	}
+	return resolved, nil
+}
+
+// within tells whether path is dir or lies under it
+func within(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,209 @@
+package importer_test
+
+import (
+	"context"
+	"errors"
+	"github.com/Fyefhqdishka/LocFinder/internal/importer"
+	"github.com/Fyefhqdishka/LocFinder/internal/models"
+	"github.com/Fyefhqdishka/LocFinder/internal/storage/repositoryInterfaces"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const geoLite2Locations = `geoname_id,locale_code,continent_code,continent_name,country_iso_code,country_name,subdivision_1_iso_code,subdivision_1_name,subdivision_2_iso_code,subdivision_2_name,city_name,metro_code,time_zone,is_in_european_union
+1526384,en,AS,Asia,KZ,Kazakhstan,ALA,Almaty,,,Almaty,,Asia/Almaty,0
+1522867,en,AS,Asia,KZ,Kazakhstan,,,,,,,Asia/Almaty,0
+`
+
+const geoLite2Blocks = `network,geoname_id,registered_country_geoname_id,represented_country_geoname_id,is_anonymous_proxy,is_satellite_provider,postal_code,latitude,longitude,accuracy_radius
+37.99.42.0/24,1526384,1522867,,0,0,050000,43.2500,76.9167,20
+37.99.43.0/25,,1522867,,1,0,,,,
+bad,1526384,1522867,,0,0,,,,
+37.99.44.0/24,999,999,,0,0,,,,
+`
+
+func readAll(t *testing.T, reader repositoryInterfaces.RangeReader) []models.IPRange {
+	var ranges []models.IPRange
+	for {
+		rng, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return ranges
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		ranges = append(ranges, rng)
+	}
+}
+
+func TestGeoLite2Reader(t *testing.T) {
+	reader, err := importer.NewGeoLite2Reader(strings.NewReader(geoLite2Locations), strings.NewReader(geoLite2Blocks))
+	assert.NoError(t, err)
+
+	ranges := readAll(t, reader)
+	assert.Len(t, ranges, 2)
+	assert.Equal(t, models.IPRange{
+		From:     netip.MustParseAddr("37.99.42.0"),
+		To:       netip.MustParseAddr("37.99.42.255"),
+		Country:  "Kazakhstan",
+		Region:   "Almaty",
+		City:     "Almaty",
+		Zip:      "050000",
+		Lat:      43.25,
+		Lon:      76.9167,
+		Timezone: "Asia/Almaty",
+	}, ranges[0])
+
+	// the anonymous proxy falls back to the registered country
+	assert.Equal(t, "Kazakhstan", ranges[1].Country)
+	assert.Equal(t, "", ranges[1].City)
+	assert.Equal(t, "37.99.43.127", ranges[1].To.String())
+
+	assert.Equal(t, int64(2), reader.Skipped())
+}
+
+func TestGeoLite2ReaderMissingColumn(t *testing.T) {
+	_, err := importer.NewGeoLite2Reader(strings.NewReader("geoname_id,country_name\n1,Kazakhstan\n"))
+	assert.ErrorIs(t, err, importer.ErrInvalidRequest)
+}
+
+func TestIP2LocationReader(t *testing.T) {
+	ipv4 := `"0","16777215","-","-","-","-","0.000000","0.000000","-","-"
+"627255808","627256063","KZ","Kazakhstan","Almaty","Almaty","43.250000","76.916700","050000","+06:00"
+`
+	ipv6 := `"0","281470681743359","-","-","-","-","0.000000","0.000000","-","-"
+"281471308999168","281471308999423","KZ","Kazakhstan","Almaty","Almaty","43.250000","76.916700","050000","+06:00"
+"55827987809411540836515382960316219392","55827987809411540836515382960316284927","DE","Germany","Hessen","Frankfurt am Main","50.115520","8.684170","60306","+01:00"
+`
+
+	reader := importer.NewIP2LocationReader(strings.NewReader(ipv4), strings.NewReader(ipv6))
+	ranges := readAll(t, reader)
+	assert.Len(t, ranges, 2)
+
+	assert.Equal(t, "37.99.42.0", ranges[0].From.String())
+	assert.Equal(t, "37.99.42.255", ranges[0].To.String())
+	assert.Equal(t, "Almaty", ranges[0].City)
+	assert.Equal(t, 43.25, ranges[0].Lat)
+
+	assert.Equal(t, "2a00:1450::", ranges[1].From.String())
+	assert.Equal(t, "2a00:1450::ffff", ranges[1].To.String())
+
+	assert.Equal(t, int64(2), reader.Skipped())
+
+	// IPv4 ranges of the IPv6 file are unmapped, the IPv4 file read after it repeats them
+	for _, files := range [][]io.Reader{
+		{strings.NewReader(ipv6)},
+		{strings.NewReader(ipv6), strings.NewReader(ipv4)},
+	} {
+		ranges := readAll(t, importer.NewIP2LocationReader(files...))
+		assert.Len(t, ranges, 2)
+		assert.Equal(t, "37.99.42.0", ranges[0].From.String())
+		assert.Equal(t, "37.99.42.255", ranges[0].To.String())
+		assert.Equal(t, "2a00:1450::", ranges[1].From.String())
+	}
+}
+
+type fakeRanges struct {
+	ranges []models.IPRange
+}
+
+func (f *fakeRanges) FindRange(ctx context.Context, ip string) (models.IPRange, error) {
+	return models.IPRange{}, errors.New("not implemented")
+}
+
+func (f *fakeRanges) ReplaceRanges(ctx context.Context, ranges repositoryInterfaces.RangeReader) (int64, error) {
+	for {
+		rng, err := ranges.Next()
+		if errors.Is(err, io.EOF) {
+			return int64(len(f.ranges)), nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		f.ranges = append(f.ranges, rng)
+	}
+}
+
+func TestImport(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), fs.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("locations.csv", geoLite2Locations)
+	write("blocks.csv", geoLite2Blocks)
+
+	ranges := &fakeRanges{}
+	imp := importer.New(ranges, dir, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	result, err := imp.Import(context.Background(), importer.Request{
+		Format:    importer.FormatGeoLite2,
+		Files:     []string{"blocks.csv"},
+		Locations: "locations.csv",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, importer.FormatGeoLite2, result.Format)
+	assert.Equal(t, int64(2), result.Rows)
+	assert.Equal(t, int64(2), result.Skipped)
+	assert.NotEmpty(t, result.Duration)
+
+	_, err = imp.Import(context.Background(), importer.Request{Format: importer.FormatIP2Location, Files: []string{"../etc/passwd"}})
+	assert.ErrorIs(t, err, importer.ErrInvalidRequest)
+
+	_, err = imp.Import(context.Background(), importer.Request{Format: "csv", Files: []string{"blocks.csv"}})
+	assert.ErrorIs(t, err, importer.ErrInvalidRequest)
+}
+
+func TestImportCheck(t *testing.T) {
+	outside := filepath.Join(t.TempDir(), "secret.csv")
+	if err := os.WriteFile(outside, []byte("1,2\n"), fs.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "db11.csv"), nil, fs.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(dir, "link.csv")); err != nil {
+		t.Skip("symbolic links are not supported:", err)
+	}
+
+	imp := importer.New(&fakeRanges{}, dir, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	tests := []struct {
+		name  string
+		req   importer.Request
+		valid bool
+	}{
+		{"file", importer.Request{Format: importer.FormatIP2Location, Files: []string{"db11.csv"}}, true},
+		{"absolute path inside", importer.Request{Format: importer.FormatIP2Location, Files: []string{filepath.Join(dir, "db11.csv")}}, true},
+		{"no files", importer.Request{Format: importer.FormatIP2Location}, false},
+		{"missing file", importer.Request{Format: importer.FormatIP2Location, Files: []string{"db5.csv"}}, false},
+		{"absolute path outside", importer.Request{Format: importer.FormatIP2Location, Files: []string{outside}}, false},
+		{"symlink outside", importer.Request{Format: importer.FormatIP2Location, Files: []string{"link.csv"}}, false},
+		{"no locations", importer.Request{Format: importer.FormatGeoLite2, Files: []string{"db11.csv"}}, false},
+		{"locations outside", importer.Request{Format: importer.FormatGeoLite2, Files: []string{"db11.csv"}, Locations: "link.csv"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := imp.Check(tt.req)
+			if tt.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, importer.ErrInvalidRequest)
+			}
+		})
+	}
+
+	_, err := imp.Import(context.Background(), importer.Request{Format: importer.FormatIP2Location, Files: []string{"link.csv"}})
+	assert.ErrorIs(t, err, importer.ErrInvalidRequest)
+}
@@ -0,0 +1,130 @@
+package importer
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/Fyefhqdishka/LocFinder/internal/models"
+	"io"
+	"math/big"
+	"net/netip"
+	"strconv"
+)
+
+// IP2LocationReader streams ranges of IP2Location LITE DB11 CSV files (IPv4 or IPv6). Columns are
+// ip_from, ip_to, country_code, country_name, region_name, city_name, latitude, longitude,
+// zip_code and time_zone, addresses are decimal numbers. Unallocated ranges marked "-" are skipped.
+// IPv6 files repeat the IPv4 ranges as ::ffff:0:0/96, IPv4 ranges are taken from the first file
+// having them, so the IPv4 and IPv6 files can be imported together
+type IP2LocationReader struct {
+	files   []io.Reader
+	current *csv.Reader
+	skipped int64
+	// file is the number of the file being read, ipv4File the one IPv4 ranges come from
+	file     int
+	ipv4File int
+}
+
+func NewIP2LocationReader(files ...io.Reader) *IP2LocationReader {
+	return &IP2LocationReader{files: files, ipv4File: -1}
+}
+
+func (r *IP2LocationReader) Next() (models.IPRange, error) {
+	for {
+		if r.current == nil {
+			if len(r.files) == 0 {
+				return models.IPRange{}, io.EOF
+			}
+			r.current = newCSVReader(r.files[0])
+			r.files = r.files[1:]
+			r.file++
+		}
+
+		record, err := r.current.Read()
+		if errors.Is(err, io.EOF) {
+			r.current = nil
+			continue
+		}
+		if errors.Is(err, csv.ErrFieldCount) {
+			r.skipped++
+			continue
+		}
+		if err != nil {
+			return models.IPRange{}, fmt.Errorf("ip2location: %w", err)
+		}
+
+		rng, ok := parseIP2Location(record)
+		if !ok {
+			r.skipped++
+			continue
+		}
+		if rng.From.Is4() {
+			if r.ipv4File == -1 {
+				r.ipv4File = r.file
+			}
+			if r.ipv4File != r.file {
+				// the same range was read from another file, it isn't counted as skipped
+				continue
+			}
+		}
+		return rng, nil
+	}
+}
+
+func parseIP2Location(record []string) (models.IPRange, bool) {
+	if len(record) < 10 || record[2] == "-" {
+		return models.IPRange{}, false
+	}
+
+	from, ok := parseDecimalAddr(record[0])
+	if !ok {
+		return models.IPRange{}, false
+	}
+	to, ok := parseDecimalAddr(record[1])
+	if !ok {
+		return models.IPRange{}, false
+	}
+
+	// IPv6 files hold IPv4 as ::ffff:0:0/96, ranges crossing its bounds can't be stored
+	from, to = from.Unmap(), to.Unmap()
+	if from.BitLen() != to.BitLen() || to.Less(from) {
+		return models.IPRange{}, false
+	}
+
+	lat, _ := strconv.ParseFloat(record[6], 64)
+	lon, _ := strconv.ParseFloat(record[7], 64)
+
+	return models.IPRange{
+		From:     from,
+		To:       to,
+		Country:  record[3],
+		Region:   record[4],
+		City:     record[5],
+		Zip:      record[8],
+		Lat:      lat,
+		Lon:      lon,
+		Timezone: record[9],
+	}, true
+}
+
+// parseDecimalAddr parses an address written as a decimal number, numbers
+// up to 2^32-1 are IPv4 addresses, larger ones are IPv6
+func parseDecimalAddr(s string) (netip.Addr, bool) {
+	if n, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return netip.AddrFrom4([4]byte{byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}), true
+	}
+
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok || n.Sign() < 0 || n.BitLen() > 128 {
+		return netip.Addr{}, false
+	}
+
+	var bytes [16]byte
+	n.FillBytes(bytes[:])
+	return netip.AddrFrom16(bytes), true
+}
+
+// Skipped returns the number of rows skipped so far
+func (r *IP2LocationReader) Skipped() int64 {
+	return r.skipped
+}
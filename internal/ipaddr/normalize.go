@@ -0,0 +1,30 @@
+package ipaddr
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+var ErrInvalid = errors.New("invalid IP address")
+
+// Parse validates s and returns its canonical form: surrounding spaces and
+// IPv6 zones are dropped and IPv4-mapped IPv6 addresses are collapsed to IPv4
+func Parse(s string) (netip.Addr, error) {
+	addr, err := netip.ParseAddr(strings.TrimSpace(s))
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("%w: %q", ErrInvalid, s)
+	}
+
+	return addr.WithZone("").Unmap(), nil
+}
+
+// Normalize returns the canonical string representation of the IP address s
+func Normalize(s string) (string, error) {
+	addr, err := Parse(s)
+	if err != nil {
+		return "", err
+	}
+	return addr.String(), nil
+}
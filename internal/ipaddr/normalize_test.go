@@ -0,0 +1,34 @@
+package ipaddr_test
+
+import (
+	"github.com/Fyefhqdishka/LocFinder/internal/ipaddr"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		in  string
+		out string
+	}{
+		{"1.1.1.1", "1.1.1.1"},
+		{" 1.1.1.1 ", "1.1.1.1"},
+		{"::ffff:1.1.1.1", "1.1.1.1"},
+		{"2001:DB8::1", "2001:db8::1"},
+		{"2001:0db8:0000:0000:0000:0000:0000:0001", "2001:db8::1"},
+		{"fe80::1%eth0", "fe80::1"},
+	}
+
+	for _, tt := range tests {
+		out, err := ipaddr.Normalize(tt.in)
+		assert.NoError(t, err, tt.in)
+		assert.Equal(t, tt.out, out, tt.in)
+	}
+}
+
+func TestNormalizeInvalid(t *testing.T) {
+	for _, in := range []string{"", "foo", "1.1.1", "1.1.1.256", "01.1.1.1", "1.1.1.1/24", "::g"} {
+		_, err := ipaddr.Normalize(in)
+		assert.ErrorIs(t, err, ipaddr.ErrInvalid, in)
+	}
+}
@@ -0,0 +1,33 @@
+package ipaddr
+
+import "net/netip"
+
+// LastAddr returns the last address of the prefix
+func LastAddr(prefix netip.Prefix) netip.Addr {
+	prefix = prefix.Masked()
+	bytes := prefix.Addr().AsSlice()
+	for i := range bytes {
+		// network bits of this byte are kept, the rest is set
+		if network := prefix.Bits() - i*8; network < 8 {
+			bytes[i] |= 0xff >> max(network, 0)
+		}
+	}
+
+	addr, _ := netip.AddrFromSlice(bytes)
+	return addr
+}
+
+// RangePrefix returns the largest prefix containing addr that lies within the range from-to
+func RangePrefix(addr, from, to netip.Addr) (netip.Prefix, bool) {
+	if addr.BitLen() != from.BitLen() || addr.BitLen() != to.BitLen() || addr.Less(from) || to.Less(addr) {
+		return netip.Prefix{}, false
+	}
+
+	for bits := 0; bits <= addr.BitLen(); bits++ {
+		prefix, _ := addr.Prefix(bits)
+		if !prefix.Addr().Less(from) && !to.Less(LastAddr(prefix)) {
+			return prefix, true
+		}
+	}
+	return netip.Prefix{}, false
+}
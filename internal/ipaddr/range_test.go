@@ -0,0 +1,51 @@
+package ipaddr_test
+
+import (
+	"github.com/Fyefhqdishka/LocFinder/internal/ipaddr"
+	"github.com/stretchr/testify/assert"
+	"net/netip"
+	"testing"
+)
+
+func TestLastAddr(t *testing.T) {
+	tests := []struct {
+		prefix string
+		last   string
+	}{
+		{"1.2.3.0/24", "1.2.3.255"},
+		{"1.2.3.4/32", "1.2.3.4"},
+		{"10.0.0.0/9", "10.127.255.255"},
+		{"0.0.0.0/0", "255.255.255.255"},
+		{"2001:db8::/32", "2001:db8:ffff:ffff:ffff:ffff:ffff:ffff"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			assert.Equal(t, tt.last, ipaddr.LastAddr(netip.MustParsePrefix(tt.prefix)).String())
+		})
+	}
+}
+
+func TestRangePrefix(t *testing.T) {
+	tests := []struct {
+		addr, from, to string
+		prefix         string
+	}{
+		{"1.2.3.4", "1.2.3.0", "1.2.3.255", "1.2.3.0/24"},
+		{"1.2.3.4", "1.2.3.0", "1.2.4.127", "1.2.3.0/24"},
+		{"1.2.4.5", "1.2.3.0", "1.2.4.127", "1.2.4.0/25"},
+		{"1.2.3.4", "1.2.3.4", "1.2.3.4", "1.2.3.4/32"},
+		{"2001:db8::1", "2001:db8::", "2001:db8::ffff", "2001:db8::/112"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.addr+" in "+tt.from+"-"+tt.to, func(t *testing.T) {
+			prefix, ok := ipaddr.RangePrefix(netip.MustParseAddr(tt.addr), netip.MustParseAddr(tt.from), netip.MustParseAddr(tt.to))
+			assert.True(t, ok)
+			assert.Equal(t, tt.prefix, prefix.String())
+		})
+	}
+
+	_, ok := ipaddr.RangePrefix(netip.MustParseAddr("1.2.5.1"), netip.MustParseAddr("1.2.3.0"), netip.MustParseAddr("1.2.4.255"))
+	assert.False(t, ok)
+}
@@ -0,0 +1,120 @@
+package ipaddr
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// FromRequest returns the address of the client that sent r. Forwarding headers
+// (Forwarded, X-Forwarded-For, X-Real-IP) are only honoured when the request came
+// from one of the trusted proxies, and the chain is walked from the right so that
+// a client can't spoof its address by sending the headers itself
+func FromRequest(r *http.Request, trusted []netip.Prefix) (netip.Addr, error) {
+	remote, err := parseHost(r.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("%w: remote address %q", ErrInvalid, r.RemoteAddr)
+	}
+
+	if !isTrusted(remote, trusted) {
+		return remote, nil
+	}
+
+	hops := forwardedHops(r.Header)
+	if len(hops) == 0 {
+		return remote, nil
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		if !isTrusted(hops[i], trusted) || i == 0 {
+			return hops[i], nil
+		}
+	}
+	return remote, nil
+}
+
+// forwardedHops returns the addresses listed by the proxies, the client first
+func forwardedHops(h http.Header) []netip.Addr {
+	if values := h.Values("Forwarded"); len(values) > 0 {
+		return parseForwarded(values)
+	}
+
+	if values := h.Values("X-Forwarded-For"); len(values) > 0 {
+		var hops []netip.Addr
+		for _, value := range values {
+			for _, item := range strings.Split(value, ",") {
+				if addr, err := parseHost(strings.TrimSpace(item)); err == nil {
+					hops = append(hops, addr)
+				}
+			}
+		}
+		return hops
+	}
+
+	if addr, err := parseHost(h.Get("X-Real-IP")); err == nil {
+		return []netip.Addr{addr}
+	}
+	return nil
+}
+
+// parseForwarded extracts the "for" parameters of RFC 7239 Forwarded headers.
+// Obfuscated identifiers and "unknown" are skipped
+func parseForwarded(values []string) []netip.Addr {
+	var hops []netip.Addr
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok || !strings.EqualFold(key, "for") {
+					continue
+				}
+
+				if addr, err := parseHost(strings.Trim(val, `"`)); err == nil {
+					hops = append(hops, addr)
+				}
+			}
+		}
+	}
+	return hops
+}
+
+// parseHost parses an address optionally followed by a port, e.g. "1.2.3.4:80" or "[::1]:80"
+func parseHost(s string) (netip.Addr, error) {
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	return Parse(strings.Trim(s, "[]"))
+}
+
+func isTrusted(addr netip.Addr, trusted []netip.Prefix) bool {
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParsePrefixes parses a list of CIDRs or single addresses
+func ParsePrefixes(items []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(items))
+	for _, item := range items {
+		if !strings.Contains(item, "/") {
+			addr, err := Parse(item)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %v", item, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
@@ -0,0 +1,45 @@
+package ipaddr_test
+
+import (
+	"github.com/Fyefhqdishka/LocFinder/internal/ipaddr"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/netip"
+	"testing"
+)
+
+func TestFromRequest(t *testing.T) {
+	trusted := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("2001:db8:cafe::/48"),
+	}
+
+	tests := []struct {
+		name    string
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{"no headers", "37.99.42.212:4711", nil, "37.99.42.212"},
+		{"untrusted remote ignores headers", "37.99.42.212:4711", map[string]string{"X-Forwarded-For": "1.1.1.1"}, "37.99.42.212"},
+		{"x-forwarded-for", "10.0.0.1:80", map[string]string{"X-Forwarded-For": "1.1.1.1, 8.8.8.8, 10.0.0.2"}, "8.8.8.8"},
+		{"x-forwarded-for all trusted", "10.0.0.1:80", map[string]string{"X-Forwarded-For": "10.1.1.1, 10.0.0.2"}, "10.1.1.1"},
+		{"x-real-ip", "10.0.0.1:80", map[string]string{"X-Real-IP": "8.8.4.4"}, "8.8.4.4"},
+		{"forwarded", "[2001:db8:cafe::1]:80", map[string]string{"Forwarded": `for="[2001:db8::17]:4711";proto=https, for=10.0.0.3`}, "2001:db8::17"},
+		{"forwarded wins over x-forwarded-for", "10.0.0.1:80", map[string]string{"Forwarded": "for=9.9.9.9", "X-Forwarded-For": "1.1.1.1"}, "9.9.9.9"},
+		{"forwarded unknown", "10.0.0.1:80", map[string]string{"Forwarded": "for=unknown"}, "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &http.Request{RemoteAddr: tt.remote, Header: http.Header{}}
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+
+			addr, err := ipaddr.FromRequest(r, trusted)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, addr.String())
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package ipaddr
+
+import "net/netip"
+
+// Types of special-purpose addresses returned by Classify
+const (
+	TypePrivate       = "private"
+	TypeLoopback      = "loopback"
+	TypeLinkLocal     = "link-local"
+	TypeCGNAT         = "cgnat"
+	TypeMulticast     = "multicast"
+	TypeDocumentation = "documentation"
+	TypeBenchmarking  = "benchmarking"
+	TypeUnspecified   = "unspecified"
+	TypeBroadcast     = "broadcast"
+	TypeReserved      = "reserved"
+)
+
+type specialRange struct {
+	prefix netip.Prefix
+	kind   string
+}
+
+// specialRanges lists IANA special-purpose IPv4 and IPv6 blocks that have no geographic location.
+// The first containing block is reported, so nested blocks precede the ones containing them
+var specialRanges = []specialRange{
+	{netip.MustParsePrefix("0.0.0.0/8"), TypeReserved},
+	{netip.MustParsePrefix("10.0.0.0/8"), TypePrivate},
+	{netip.MustParsePrefix("100.64.0.0/10"), TypeCGNAT},
+	{netip.MustParsePrefix("127.0.0.0/8"), TypeLoopback},
+	{netip.MustParsePrefix("169.254.0.0/16"), TypeLinkLocal},
+	{netip.MustParsePrefix("172.16.0.0/12"), TypePrivate},
+	{netip.MustParsePrefix("192.0.0.0/24"), TypeReserved},
+	{netip.MustParsePrefix("192.0.2.0/24"), TypeDocumentation},
+	{netip.MustParsePrefix("192.88.99.0/24"), TypeReserved},
+	{netip.MustParsePrefix("192.168.0.0/16"), TypePrivate},
+	{netip.MustParsePrefix("198.18.0.0/15"), TypeBenchmarking},
+	{netip.MustParsePrefix("198.51.100.0/24"), TypeDocumentation},
+	{netip.MustParsePrefix("203.0.113.0/24"), TypeDocumentation},
+	{netip.MustParsePrefix("224.0.0.0/4"), TypeMulticast},
+	{netip.MustParsePrefix("255.255.255.255/32"), TypeBroadcast},
+	{netip.MustParsePrefix("240.0.0.0/4"), TypeReserved},
+
+	{netip.MustParsePrefix("::/128"), TypeUnspecified},
+	{netip.MustParsePrefix("::1/128"), TypeLoopback},
+	{netip.MustParsePrefix("64:ff9b:1::/48"), TypeReserved},
+	{netip.MustParsePrefix("100::/64"), TypeReserved},
+	{netip.MustParsePrefix("2001:2::/48"), TypeBenchmarking},
+	{netip.MustParsePrefix("2001::/23"), TypeReserved},
+	{netip.MustParsePrefix("2001:db8::/32"), TypeDocumentation},
+	{netip.MustParsePrefix("2002::/16"), TypeReserved},
+	{netip.MustParsePrefix("3fff::/20"), TypeDocumentation},
+	{netip.MustParsePrefix("5f00::/16"), TypeReserved},
+	{netip.MustParsePrefix("fc00::/7"), TypePrivate},
+	{netip.MustParsePrefix("fe80::/10"), TypeLinkLocal},
+	{netip.MustParsePrefix("ff00::/8"), TypeMulticast},
+}
+
+// Classify returns the kind of special-purpose range addr belongs to,
+// or an empty string for a globally routable address
+func Classify(addr netip.Addr) string {
+	addr = addr.Unmap()
+	if addr.Is4() && addr == netip.IPv4Unspecified() {
+		return TypeUnspecified
+	}
+
+	for _, r := range specialRanges {
+		if r.prefix.Contains(addr) {
+			return r.kind
+		}
+	}
+	return ""
+}
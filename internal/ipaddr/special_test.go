@@ -0,0 +1,47 @@
+package ipaddr_test
+
+import (
+	"github.com/Fyefhqdishka/LocFinder/internal/ipaddr"
+	"github.com/stretchr/testify/assert"
+	"net/netip"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		ip   string
+		kind string
+	}{
+		{"10.1.2.3", ipaddr.TypePrivate},
+		{"172.31.255.255", ipaddr.TypePrivate},
+		{"192.168.0.1", ipaddr.TypePrivate},
+		{"127.0.0.1", ipaddr.TypeLoopback},
+		{"169.254.10.10", ipaddr.TypeLinkLocal},
+		{"100.64.0.1", ipaddr.TypeCGNAT},
+		{"224.0.0.251", ipaddr.TypeMulticast},
+		{"203.0.113.7", ipaddr.TypeDocumentation},
+		{"0.0.0.0", ipaddr.TypeUnspecified},
+		{"255.255.255.255", ipaddr.TypeBroadcast},
+		{"::ffff:192.168.1.1", ipaddr.TypePrivate},
+		{"::1", ipaddr.TypeLoopback},
+		{"fe80::1", ipaddr.TypeLinkLocal},
+		{"fd12:3456::1", ipaddr.TypePrivate},
+		{"2001:db8::1", ipaddr.TypeDocumentation},
+		{"ff02::1", ipaddr.TypeMulticast},
+		{"2001::1", ipaddr.TypeReserved},
+		{"2001:1ff:ffff::1", ipaddr.TypeReserved},
+		{"2001:2::1", ipaddr.TypeBenchmarking},
+		{"2002:c000:204::1", ipaddr.TypeReserved},
+		{"64:ff9b:1::c000:201", ipaddr.TypeReserved},
+		{"5f00:1234::1", ipaddr.TypeReserved},
+		{"2001:200::1", ""},
+		{"64:ff9b::808:808", ""},
+		{"37.99.42.212", ""},
+		{"172.32.0.1", ""},
+		{"2a00:1450:4001::1", ""},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.kind, ipaddr.Classify(netip.MustParseAddr(tt.ip)), tt.ip)
+	}
+}
@@ -1,7 +1,175 @@
 package models
 
+import (
+	"net/netip"
+	"time"
+)
+
 type IPLocation struct {
-	IP      string `json:"query"`
-	Country string `json:"country"`
-	City    string `json:"city"`
+	IP       string  `json:"query"`
+	Country  string  `json:"country"`
+	Region   string  `json:"regionName"`
+	City     string  `json:"city"`
+	Zip      string  `json:"zip"`
+	Lat      float64 `json:"lat"`
+	Lon      float64 `json:"lon"`
+	Timezone string  `json:"timezone"`
+	ISP      string  `json:"isp"`
+	Org      string  `json:"org"`
+	AS       string  `json:"as"`
+	Provider string  `json:"provider,omitempty"`
+	// Network is the stored range (CIDR) the location applies to
+	Network string `json:"network,omitempty"`
+	// CreatedAt is the time the location was stored first
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+	// FetchedAt is the time the location was received from a provider, manual locations don't have it
+	FetchedAt *time.Time `json:"fetchedAt,omitempty"`
+	// Type is set for special-purpose addresses (private, loopback, etc.) that have no location
+	Type string `json:"type,omitempty"`
+}
+
+// LocationPatch holds the fields of a partial location update, nil fields are left as is
+type LocationPatch struct {
+	Country  *string  `json:"country"`
+	Region   *string  `json:"regionName"`
+	City     *string  `json:"city"`
+	Zip      *string  `json:"zip"`
+	Lat      *float64 `json:"lat"`
+	Lon      *float64 `json:"lon"`
+	Timezone *string  `json:"timezone"`
+	ISP      *string  `json:"isp"`
+	Org      *string  `json:"org"`
+	AS       *string  `json:"as"`
+}
+
+// LookupResult is the outcome of a single IP in a batch lookup. A failed IP has no location,
+// Code tells why it failed and Error describes it
+type LookupResult struct {
+	IP       string      `json:"ip"`
+	Location *IPLocation `json:"location,omitempty"`
+	Code     string      `json:"code,omitempty"`
+	Error    string      `json:"error,omitempty"`
+}
+
+// ProviderHealth is the circuit breaker state of a geolocation provider
+type ProviderHealth struct {
+	Name     string `json:"name"`
+	State    string `json:"state"`
+	Failures int    `json:"failures"`
+}
+
+// CacheStats describes the effectiveness of the in-memory location cache
+type CacheStats struct {
+	Size   int    `json:"size"`
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+}
+
+// Health is reported by GET /health, Cache is set when the location cache is enabled
+type Health struct {
+	Status    string           `json:"status"`
+	Providers []ProviderHealth `json:"providers"`
+	Cache     *CacheStats      `json:"cache,omitempty"`
+}
+
+// IPRange is a row of an imported range database, From and To are its first and last addresses
+type IPRange struct {
+	From     netip.Addr
+	To       netip.Addr
+	Country  string
+	Region   string
+	City     string
+	Zip      string
+	Lat      float64
+	Lon      float64
+	Timezone string
+}
+
+// ImportResult reports a finished import of a range database
+type ImportResult struct {
+	Format   string `json:"format"`
+	Rows     int64  `json:"rows"`
+	Skipped  int64  `json:"skipped"`
+	Duration string `json:"duration"`
+}
+
+// States of an import job
+const (
+	ImportRunning = "running"
+	ImportDone    = "done"
+	ImportFailed  = "failed"
+)
+
+// ImportJob reports an import running in the background, Result is set once it is done
+type ImportJob struct {
+	ID         string        `json:"id"`
+	Status     string        `json:"status"`
+	Format     string        `json:"format"`
+	Result     *ImportResult `json:"result,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	StartedAt  time.Time     `json:"startedAt"`
+	FinishedAt *time.Time    `json:"finishedAt,omitempty"`
+}
+
+// Sort fields of stored locations
+const (
+	SortCreatedAt = "created_at"
+	SortCountry   = "country"
+	SortCity      = "city"
+	SortNetwork   = "network"
+)
+
+// LocationQuery selects a page of stored locations. Empty fields don't filter, Network keeps
+// networks within the given CIDR. Cursor continues the listing after the previous page.
+// SkipTotal leaves LocationPage.Total zero, which saves counting the matches on every page of an export
+type LocationQuery struct {
+	Country     string
+	City        string
+	Provider    string
+	Network     string
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	Sort        string
+	Desc        bool
+	Limit       int
+	Cursor      string
+	SkipTotal   bool
+}
+
+// LocationSummary counts the stored locations by address family, provider and country.
+// Stale counts locations fetched before the time given to the storage
+type LocationSummary struct {
+	Total     int64       `json:"total"`
+	IPv4      int64       `json:"ipv4"`
+	IPv6      int64       `json:"ipv6"`
+	Stale     int64       `json:"stale"`
+	Providers []NameCount `json:"providers"`
+	Countries []NameCount `json:"countries"`
+	Oldest    *time.Time  `json:"oldestCreatedAt,omitempty"`
+	Newest    *time.Time  `json:"newestCreatedAt,omitempty"`
+}
+
+// NameCount is the number of stored locations sharing a provider or country
+type NameCount struct {
+	Name  string `json:"name"`
+	Count int64  `json:"count"`
+}
+
+// ParseCreatedTime parses the CreatedFrom and CreatedTo bounds of a LocationQuery given as
+// RFC 3339 timestamps or dates, an empty value gives zero time
+func ParseCreatedTime(val string) (time.Time, error) {
+	if val == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.DateOnly, val); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, val)
+}
+
+// LocationPage is a page of stored locations, NextCursor is empty on the last page
+type LocationPage struct {
+	Locations  []IPLocation
+	Total      int64
+	NextCursor string
 }
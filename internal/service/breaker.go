@@ -0,0 +1,156 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+)
+
+// Circuit breaker states
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// CircuitBreaker stops calling a provider after threshold consecutive transient failures.
+// While open every call fails fast with ErrCircuitOpen, after openTimeout a single probe call
+// is let through: its success closes the breaker, its failure opens it again.
+// A nil CircuitBreaker never opens
+type CircuitBreaker struct {
+	threshold   int
+	openTimeout time.Duration
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// NewCircuitBreaker returns nil when threshold is not positive
+func NewCircuitBreaker(threshold int, openTimeout time.Duration) *CircuitBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+
+	return &CircuitBreaker{threshold: threshold, openTimeout: openTimeout, state: BreakerClosed}
+}
+
+// Allow reports whether a call may be made, every allowed call must be followed by Record
+func (b *CircuitBreaker) Allow() error {
+	if b == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.openTimeout {
+			return ErrCircuitOpen
+		}
+		b.state = BreakerHalfOpen
+	case BreakerHalfOpen:
+		if b.probing {
+			return ErrCircuitOpen
+		}
+	default:
+		return nil
+	}
+
+	b.probing = true
+	return nil
+}
+
+// Record updates the breaker with the outcome of an allowed call. Only transient errors count
+// as failures, an exhausted quota or a call cancelled by the caller says nothing about provider
+// health and leaves the state as is, a cancelled probe only lets the next caller probe
+func (b *CircuitBreaker) Record(err error) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	switch {
+	case errors.Is(err, ErrQuotaExceeded), errors.Is(err, context.Canceled):
+	case isTransient(err):
+		b.failures++
+		if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+			b.state = BreakerOpen
+			b.openedAt = time.Now()
+		}
+	default:
+		b.state = BreakerClosed
+		b.failures = 0
+	}
+}
+
+// State returns the current state and the number of consecutive failures
+func (b *CircuitBreaker) State() (string, int) {
+	if b == nil {
+		return BreakerClosed, 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.openTimeout {
+		return BreakerHalfOpen, b.failures
+	}
+	return b.state, b.failures
+}
+
+// RetryPolicy retries transient provider errors with exponential backoff and full jitter
+type RetryPolicy struct {
+	// Attempts is the total number of calls, values below 2 disable retries
+	Attempts  int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// backoff returns a random delay before the retry following the given attempt, counted from zero
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay << min(attempt, 30)
+	if p.MaxDelay > 0 && (delay > p.MaxDelay || delay <= 0) {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return rand.N(delay + 1)
+}
+
+// retry calls fn until it succeeds, fails with a non-transient error, attempts run out or ctx is done
+func (p RetryPolicy) retry(ctx context.Context, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || !isTransient(err) || attempt+1 >= p.Attempts || ctx.Err() != nil {
+			return err
+		}
+		if sleep(ctx, p.backoff(attempt)) != nil {
+			return err
+		}
+	}
+}
+
+// isTransient reports whether err is likely to go away on its own: the provider is down,
+// answered with a server error or didn't answer in time
+func isTransient(err error) bool {
+	if err == nil || errors.Is(err, ErrCircuitOpen) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, ErrUnavailable) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
@@ -0,0 +1,168 @@
+package service_test
+
+import (
+	"context"
+	"github.com/Fyefhqdishka/LocFinder/internal/config"
+	"github.com/Fyefhqdishka/LocFinder/internal/service"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestChainProviderRetriesTransientErrors(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`{"status":"success","country":"Kazakhstan","city":"Almaty","query":"37.99.42.212"}`))
+	}))
+	defer srv.Close()
+
+	chain, err := service.NewChainProvider(config.Geo{
+		Providers: []string{service.ProviderIPAPI},
+		Retry:     config.Retry{Attempts: 3, BaseDelay: time.Millisecond},
+		IPAPI:     config.IPAPI{BaseURL: srv.URL},
+	}, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	assert.NoError(t, err)
+
+	location, err := chain.Lookup(context.Background(), "37.99.42.212")
+	assert.NoError(t, err)
+	assert.Equal(t, "Almaty", location.City)
+	assert.Equal(t, int32(3), calls.Load())
+}
+
+func TestChainProviderDoesNotRetryFinalErrors(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Write([]byte(`{"status":"fail","message":"invalid query","query":"37.99.42.212"}`))
+	}))
+	defer srv.Close()
+
+	chain, err := service.NewChainProvider(config.Geo{
+		Providers: []string{service.ProviderIPAPI},
+		Retry:     config.Retry{Attempts: 3, BaseDelay: time.Millisecond},
+		IPAPI:     config.IPAPI{BaseURL: srv.URL},
+	}, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	assert.NoError(t, err)
+
+	_, err = chain.Lookup(context.Background(), "37.99.42.212")
+	assert.ErrorIs(t, err, service.ErrInvalidQuery)
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestChainProviderCircuitBreakerOpens(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	chain, err := service.NewChainProvider(config.Geo{
+		Providers: []string{service.ProviderIPAPI},
+		Breaker:   config.Breaker{Threshold: 2, OpenTimeout: time.Hour},
+		IPAPI:     config.IPAPI{BaseURL: srv.URL},
+	}, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	assert.NoError(t, err)
+
+	for range 2 {
+		_, err = chain.Lookup(context.Background(), "37.99.42.212")
+		assert.ErrorIs(t, err, service.ErrUnavailable)
+	}
+
+	_, err = chain.Lookup(context.Background(), "37.99.42.212")
+	assert.ErrorIs(t, err, service.ErrCircuitOpen)
+	assert.Equal(t, int32(2), calls.Load())
+
+	health := chain.Health()
+	assert.Len(t, health, 1)
+	assert.Equal(t, service.BreakerOpen, health[0].State)
+	assert.Equal(t, 2, health[0].Failures)
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	breaker := service.NewCircuitBreaker(1, 20*time.Millisecond)
+
+	assert.NoError(t, breaker.Allow())
+	breaker.Record(service.ErrUnavailable)
+	assert.ErrorIs(t, breaker.Allow(), service.ErrCircuitOpen)
+
+	time.Sleep(30 * time.Millisecond)
+
+	// only a single probe is let through
+	assert.NoError(t, breaker.Allow())
+	assert.ErrorIs(t, breaker.Allow(), service.ErrCircuitOpen)
+
+	breaker.Record(nil)
+	state, failures := breaker.State()
+	assert.Equal(t, service.BreakerClosed, state)
+	assert.Equal(t, 0, failures)
+}
+
+func TestCircuitBreakerIgnoresQuota(t *testing.T) {
+	breaker := service.NewCircuitBreaker(1, time.Hour)
+
+	assert.NoError(t, breaker.Allow())
+	breaker.Record(&service.QuotaError{Provider: "ipapi", RetryAfter: time.Minute})
+
+	state, _ := breaker.State()
+	assert.Equal(t, service.BreakerClosed, state)
+}
+
+func TestCircuitBreakerIgnoresCancelledCalls(t *testing.T) {
+	breaker := service.NewCircuitBreaker(2, time.Hour)
+
+	// a client going away between failures doesn't reset the count
+	assert.NoError(t, breaker.Allow())
+	breaker.Record(service.ErrUnavailable)
+	assert.NoError(t, breaker.Allow())
+	breaker.Record(context.Canceled)
+	assert.NoError(t, breaker.Allow())
+	breaker.Record(service.ErrUnavailable)
+
+	state, failures := breaker.State()
+	assert.Equal(t, service.BreakerOpen, state)
+	assert.Equal(t, 2, failures)
+}
+
+func TestCircuitBreakerCancelledProbe(t *testing.T) {
+	breaker := service.NewCircuitBreaker(1, 20*time.Millisecond)
+
+	assert.NoError(t, breaker.Allow())
+	breaker.Record(service.ErrUnavailable)
+	time.Sleep(30 * time.Millisecond)
+
+	assert.NoError(t, breaker.Allow())
+	breaker.Record(context.Canceled)
+
+	state, failures := breaker.State()
+	assert.Equal(t, service.BreakerHalfOpen, state)
+	assert.Equal(t, 1, failures)
+
+	// the next caller probes instead
+	assert.NoError(t, breaker.Allow())
+	assert.ErrorIs(t, breaker.Allow(), service.ErrCircuitOpen)
+}
+
+func TestChainProviderRefusedConnectionIsUnavailable(t *testing.T) {
+	for _, provider := range []string{service.ProviderIPAPI, service.ProviderIPWhois} {
+		chain, err := service.NewChainProvider(config.Geo{
+			Providers: []string{provider},
+			IPAPI:     config.IPAPI{BaseURL: "http://127.0.0.1:1"},
+			IPWhois:   config.IPWhois{BaseURL: "http://127.0.0.1:1"},
+		}, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+		assert.NoError(t, err)
+
+		_, err = chain.Lookup(context.Background(), "37.99.42.212")
+		assert.ErrorIs(t, err, service.ErrUnavailable, provider)
+		assert.NotContains(t, err.Error(), "http://127.0.0.1:1", provider)
+	}
+}
@@ -0,0 +1,59 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Fyefhqdishka/LocFinder/internal/ipaddr"
+	"github.com/Fyefhqdishka/LocFinder/internal/models"
+	"github.com/Fyefhqdishka/LocFinder/internal/storage/repositoryInterfaces"
+	"net/netip"
+)
+
+const ProviderDataset = "dataset"
+
+// DatasetProvider resolves locations from the imported range database (GeoLite2 or IP2Location CSV).
+// Locations carry the largest network around the IP that lies within its range
+type DatasetProvider struct {
+	ranges repositoryInterfaces.RangeStorage
+}
+
+func NewDatasetProvider(ranges repositoryInterfaces.RangeStorage) *DatasetProvider {
+	return &DatasetProvider{ranges: ranges}
+}
+
+func (p *DatasetProvider) Name() string {
+	return ProviderDataset
+}
+
+func (p *DatasetProvider) Lookup(ctx context.Context, ip string) (models.IPLocation, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return models.IPLocation{}, fmt.Errorf("%w: %q", ErrInvalidQuery, ip)
+	}
+
+	rng, err := p.ranges.FindRange(ctx, addr.String())
+	if errors.Is(err, ErrNotFound) {
+		return models.IPLocation{}, ErrEmptyLocation
+	}
+	if err != nil {
+		return models.IPLocation{}, err
+	}
+
+	location := models.IPLocation{
+		IP:       ip,
+		Country:  rng.Country,
+		Region:   rng.Region,
+		City:     rng.City,
+		Zip:      rng.Zip,
+		Lat:      rng.Lat,
+		Lon:      rng.Lon,
+		Timezone: rng.Timezone,
+		Provider: p.Name(),
+	}
+	if network, ok := ipaddr.RangePrefix(addr, rng.From, rng.To); ok {
+		location.Network = network.String()
+	}
+
+	return location, nil
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Fyefhqdishka/LocFinder/internal/ipaddr"
+	"github.com/Fyefhqdishka/LocFinder/internal/storage/repositoryInterfaces"
+	"net/url"
+)
+
+// Errors reported by providers about the queried IP
+var (
+	ErrInvalidQuery  = ipaddr.ErrInvalid
+	ErrReservedRange = errors.New("IP address belongs to a private or reserved range")
+	ErrQuotaExceeded = errors.New("provider quota exceeded")
+)
+
+// Errors reported when a provider can't be reached
+var (
+	ErrUnavailable = errors.New("provider unavailable")
+	ErrCircuitOpen = errors.New("provider circuit breaker is open")
+)
+
+var ErrBatchTooLarge = errors.New("too many IP addresses in batch")
+
+// Errors of stored locations
+var (
+	ErrNotFound        = repositoryInterfaces.ErrNotFound
+	ErrConflict        = repositoryInterfaces.ErrConflict
+	ErrInvalidLocation = errors.New("invalid location")
+)
+
+// Errors of listing stored locations
+var (
+	ErrInvalidFilter = errors.New("invalid filter")
+	ErrInvalidCursor = repositoryInterfaces.ErrInvalidCursor
+)
+
+// Codes of errors returned by the service, the API sends them to clients
+const (
+	CodeInvalidIP           = "invalid_ip"
+	CodeInvalidRequest      = "invalid_request"
+	CodeReservedRange       = "reserved_range"
+	CodeNotFound            = "not_found"
+	CodeConflict            = "conflict"
+	CodeQuotaExceeded       = "quota_exceeded"
+	CodeUpstreamUnavailable = "upstream_unavailable"
+	CodeInternal            = "internal"
+)
+
+// ErrorCode describes an error returned by the service by its code and a message for the client.
+// Errors of the request keep their text, the rest are reduced to the matching sentinel error, so
+// responses of providers and storage details don't leak. A shortage of providers is reported
+// before a miss, as other providers could have known the IP
+func ErrorCode(err error) (code, message string) {
+	switch {
+	case errors.Is(err, ErrInvalidQuery):
+		return CodeInvalidIP, ErrInvalidQuery.Error()
+	case errors.Is(err, ErrBatchTooLarge), errors.Is(err, ErrInvalidFilter),
+		errors.Is(err, ErrInvalidCursor), errors.Is(err, ErrInvalidLocation):
+		return CodeInvalidRequest, err.Error()
+	case errors.Is(err, ErrReservedRange):
+		return CodeReservedRange, ErrReservedRange.Error()
+	case errors.Is(err, ErrQuotaExceeded):
+		return CodeQuotaExceeded, ErrQuotaExceeded.Error()
+	case errors.Is(err, ErrUnavailable), errors.Is(err, ErrCircuitOpen):
+		return CodeUpstreamUnavailable, ErrUnavailable.Error()
+	case errors.Is(err, ErrNotFound), errors.Is(err, ErrEmptyLocation):
+		return CodeNotFound, ErrNotFound.Error()
+	case errors.Is(err, ErrConflict):
+		return CodeConflict, err.Error()
+	default:
+		return CodeInternal, "location lookup failed"
+	}
+}
+
+// isFinal reports whether err describes the IP itself, so asking other providers makes no sense
+func isFinal(err error) bool {
+	return errors.Is(err, ErrInvalidQuery) || errors.Is(err, ErrReservedRange)
+}
+
+// requestError marks a provider request that got no response as ErrUnavailable. The request URL is
+// dropped from the message, a request cancelled by the caller is returned as is
+func requestError(err error) error {
+	if errors.Is(err, context.Canceled) {
+		return err
+	}
+
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	return fmt.Errorf("%w: %v", ErrUnavailable, err)
+}
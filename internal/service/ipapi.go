@@ -0,0 +1,200 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Fyefhqdishka/LocFinder/internal/models"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const ProviderIPAPI = "ipapi"
+
+// ipAPIBatchSize is the maximum number of queries ip-api.com accepts in one /batch request
+const ipAPIBatchSize = 100
+
+// IPAPIProvider looks up locations via the ip-api.com JSON API. Single and /batch requests
+// have separate quotas, so each of them has its own limiter
+type IPAPIProvider struct {
+	baseURL      string
+	client       *http.Client
+	limiter      *RateLimiter
+	batchLimiter *RateLimiter
+}
+
+type ipAPIResponse struct {
+	Status     string  `json:"status"`
+	Message    string  `json:"message"`
+	Query      string  `json:"query"`
+	Country    string  `json:"country"`
+	RegionName string  `json:"regionName"`
+	City       string  `json:"city"`
+	Zip        string  `json:"zip"`
+	Lat        float64 `json:"lat"`
+	Lon        float64 `json:"lon"`
+	Timezone   string  `json:"timezone"`
+	ISP        string  `json:"isp"`
+	Org        string  `json:"org"`
+	AS         string  `json:"as"`
+}
+
+// NewIPAPIProvider creates the provider, timeout limits every single HTTP request.
+// limiter limits single lookups and batchLimiter /batch requests, a nil one doesn't limit them
+func NewIPAPIProvider(baseURL string, timeout time.Duration, limiter, batchLimiter *RateLimiter) *IPAPIProvider {
+	return &IPAPIProvider{
+		baseURL:      strings.TrimRight(baseURL, "/"),
+		client:       &http.Client{Timeout: timeout},
+		limiter:      limiter,
+		batchLimiter: batchLimiter,
+	}
+}
+
+func (p *IPAPIProvider) Name() string {
+	return ProviderIPAPI
+}
+
+func (p *IPAPIProvider) Lookup(ctx context.Context, ip string) (models.IPLocation, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.baseURL+"/json/"+ip, nil)
+	if err != nil {
+		return models.IPLocation{}, err
+	}
+
+	var data ipAPIResponse
+	if err := p.do(req, p.limiter, &data); err != nil {
+		return models.IPLocation{}, err
+	}
+
+	return p.toLocation(data)
+}
+
+// LookupBatch resolves ips through the /batch endpoint, up to 100 addresses per request
+func (p *IPAPIProvider) LookupBatch(ctx context.Context, ips []string) ([]models.IPLocation, []error) {
+	locations := make([]models.IPLocation, len(ips))
+	errs := make([]error, len(ips))
+
+	for start := 0; start < len(ips); start += ipAPIBatchSize {
+		end := min(start+ipAPIBatchSize, len(ips))
+
+		data, err := p.batch(ctx, ips[start:end])
+		for i := start; i < end; i++ {
+			if err != nil {
+				errs[i] = err
+				continue
+			}
+			locations[i], errs[i] = p.toLocation(data[i-start])
+		}
+	}
+
+	return locations, errs
+}
+
+func (p *IPAPIProvider) batch(ctx context.Context, ips []string) ([]ipAPIResponse, error) {
+	payload, err := json.Marshal(ips)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+"/batch", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	var data []ipAPIResponse
+	if err := p.do(req, p.batchLimiter, &data); err != nil {
+		return nil, err
+	}
+
+	if len(data) != len(ips) {
+		return nil, fmt.Errorf("ip-api.com: got %d results for %d queries", len(data), len(ips))
+	}
+	return data, nil
+}
+
+// do sends the request once limiter allows it, quota headers of the response apply to limiter
+func (p *IPAPIProvider) do(req *http.Request, limiter *RateLimiter, v any) error {
+	if err := limiter.Wait(req.Context()); err != nil {
+		return err
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return requestError(err)
+	}
+	defer resp.Body.Close()
+
+	// X-Rl is the number of requests left in the current window of the endpoint,
+	// X-Ttl is seconds until the window resets
+	ttl := ipAPIQuotaReset(resp.Header.Get("X-Ttl"))
+	if resp.Header.Get("X-Rl") == "0" {
+		limiter.Block(time.Now().Add(ttl))
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("не удалось прочитать тело ответа от API: %w", requestError(err))
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		limiter.Block(time.Now().Add(ttl))
+		return &QuotaError{Provider: p.Name(), RetryAfter: ttl}
+	}
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("%w: %s", ErrUnavailable, resp.Status)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("некорректный ответ от API: " + resp.Status)
+	}
+
+	return json.Unmarshal(body, v)
+}
+
+func (p *IPAPIProvider) toLocation(data ipAPIResponse) (models.IPLocation, error) {
+	if data.Status == "fail" {
+		return models.IPLocation{}, ipAPIError(data.Message)
+	}
+
+	return models.IPLocation{
+		IP:       data.Query,
+		Country:  data.Country,
+		Region:   data.RegionName,
+		City:     data.City,
+		Zip:      data.Zip,
+		Lat:      data.Lat,
+		Lon:      data.Lon,
+		Timezone: data.Timezone,
+		ISP:      data.ISP,
+		Org:      data.Org,
+		AS:       data.AS,
+		Provider: p.Name(),
+	}, nil
+}
+
+// ipAPIQuotaReset parses the X-Ttl header, falling back to the length of the ip-api.com rate limit window
+func ipAPIQuotaReset(header string) time.Duration {
+	seconds, err := strconv.Atoi(header)
+	if err != nil || seconds < 0 {
+		return time.Minute
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// ipAPIError converts the message of a "fail" response into a typed error
+func ipAPIError(message string) error {
+	switch message {
+	case "private range", "reserved range":
+		return fmt.Errorf("%w: %s", ErrReservedRange, message)
+	case "invalid query":
+		return fmt.Errorf("%w: %s", ErrInvalidQuery, message)
+	default:
+		return fmt.Errorf("ip-api.com: %s", message)
+	}
+}
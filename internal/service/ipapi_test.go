@@ -0,0 +1,65 @@
+package service_test
+
+import (
+	"context"
+	"github.com/Fyefhqdishka/LocFinder/internal/service"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestIPAPIProviderLookup(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/json/37.99.42.212", r.URL.Path)
+		w.Write([]byte(`{"status":"success","query":"37.99.42.212","country":"Kazakhstan","regionName":"Almaty","city":"Almaty",` +
+			`"zip":"050000","lat":43.25,"lon":76.9167,"timezone":"Asia/Almaty","isp":"JSC Kazakhtelecom","org":"","as":"AS9198 JSC Kazakhtelecom"}`))
+	}))
+	defer srv.Close()
+
+	provider := service.NewIPAPIProvider(srv.URL, time.Second, nil, nil)
+
+	location, err := provider.Lookup(context.Background(), "37.99.42.212")
+	assert.NoError(t, err)
+	assert.Equal(t, "37.99.42.212", location.IP)
+	assert.Equal(t, "Kazakhstan", location.Country)
+	assert.Equal(t, "Almaty", location.City)
+	assert.Equal(t, 43.25, location.Lat)
+	assert.Equal(t, 76.9167, location.Lon)
+	assert.Equal(t, "Asia/Almaty", location.Timezone)
+	assert.Equal(t, "AS9198 JSC Kazakhtelecom", location.AS)
+	assert.Equal(t, service.ProviderIPAPI, location.Provider)
+}
+
+func TestIPAPIProviderFailStatus(t *testing.T) {
+	tests := []struct {
+		body string
+		err  error
+	}{
+		{`{"status":"fail","message":"private range","query":"10.0.0.1"}`, service.ErrReservedRange},
+		{`{"status":"fail","message":"reserved range","query":"0.0.0.0"}`, service.ErrReservedRange},
+		{`{"status":"fail","message":"invalid query","query":"foo"}`, service.ErrInvalidQuery},
+	}
+
+	for _, tt := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(tt.body))
+		}))
+
+		_, err := service.NewIPAPIProvider(srv.URL, time.Second, nil, nil).Lookup(context.Background(), "10.0.0.1")
+		assert.ErrorIs(t, err, tt.err)
+
+		srv.Close()
+	}
+}
+
+func TestIPAPIProviderQuotaExceeded(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	_, err := service.NewIPAPIProvider(srv.URL, time.Second, nil, nil).Lookup(context.Background(), "37.99.42.212")
+	assert.ErrorIs(t, err, service.ErrQuotaExceeded)
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Fyefhqdishka/LocFinder/internal/models"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const ProviderIPWhois = "ipwhois"
+
+// IPWhoisProvider looks up locations via the ipwho.is JSON API
+type IPWhoisProvider struct {
+	baseURL string
+	client  *http.Client
+	limiter *RateLimiter
+}
+
+type ipWhoisResponse struct {
+	IP        string  `json:"ip"`
+	Success   bool    `json:"success"`
+	Message   string  `json:"message"`
+	Country   string  `json:"country"`
+	Region    string  `json:"region"`
+	City      string  `json:"city"`
+	Postal    string  `json:"postal"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Timezone  struct {
+		ID string `json:"id"`
+	} `json:"timezone"`
+	Connection struct {
+		ASN int    `json:"asn"`
+		Org string `json:"org"`
+		ISP string `json:"isp"`
+	} `json:"connection"`
+}
+
+// NewIPWhoisProvider creates the provider, timeout limits every single HTTP request.
+// limiter may be nil, then requests are not rate limited
+func NewIPWhoisProvider(baseURL string, timeout time.Duration, limiter *RateLimiter) *IPWhoisProvider {
+	return &IPWhoisProvider{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		client:  &http.Client{Timeout: timeout},
+		limiter: limiter,
+	}
+}
+
+func (p *IPWhoisProvider) Name() string {
+	return ProviderIPWhois
+}
+
+func (p *IPWhoisProvider) Lookup(ctx context.Context, ip string) (models.IPLocation, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.baseURL+"/"+ip, nil)
+	if err != nil {
+		return models.IPLocation{}, err
+	}
+
+	if err := p.limiter.Wait(ctx); err != nil {
+		return models.IPLocation{}, err
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return models.IPLocation{}, requestError(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return models.IPLocation{}, fmt.Errorf("не удалось прочитать тело ответа от API: %w", requestError(err))
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		retryAfter := time.Minute
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+			retryAfter = time.Duration(seconds) * time.Second
+		}
+		p.limiter.Block(time.Now().Add(retryAfter))
+		return models.IPLocation{}, &QuotaError{Provider: p.Name(), RetryAfter: retryAfter}
+	}
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return models.IPLocation{}, fmt.Errorf("%w: %s", ErrUnavailable, resp.Status)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return models.IPLocation{}, errors.New("некорректный ответ от API: " + resp.Status)
+	}
+
+	var data ipWhoisResponse
+	if err := json.Unmarshal(body, &data); err != nil {
+		return models.IPLocation{}, err
+	}
+
+	if !data.Success {
+		return models.IPLocation{}, ipWhoisError(data.Message)
+	}
+
+	location := models.IPLocation{
+		IP:       data.IP,
+		Country:  data.Country,
+		Region:   data.Region,
+		City:     data.City,
+		Zip:      data.Postal,
+		Lat:      data.Latitude,
+		Lon:      data.Longitude,
+		Timezone: data.Timezone.ID,
+		ISP:      data.Connection.ISP,
+		Org:      data.Connection.Org,
+		Provider: p.Name(),
+	}
+	if data.Connection.ASN != 0 {
+		location.AS = fmt.Sprintf("AS%d %s", data.Connection.ASN, data.Connection.Org)
+	}
+
+	return location, nil
+}
+
+// ipWhoisError converts the message of an unsuccessful response into a typed error
+func ipWhoisError(message string) error {
+	switch strings.ToLower(message) {
+	case "reserved range":
+		return fmt.Errorf("%w: %s", ErrReservedRange, message)
+	case "invalid ip address":
+		return fmt.Errorf("%w: %s", ErrInvalidQuery, message)
+	case "you've hit the monthly limit":
+		return fmt.Errorf("%w: %s", ErrQuotaExceeded, message)
+	default:
+		return fmt.Errorf("ipwho.is: %s", message)
+	}
+}
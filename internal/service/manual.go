@@ -0,0 +1,161 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Fyefhqdishka/LocFinder/internal/ipaddr"
+	"github.com/Fyefhqdishka/LocFinder/internal/models"
+	"net/netip"
+)
+
+// ProviderManual marks locations entered through the API, they are never refreshed from providers
+const ProviderManual = "manual"
+
+// CreateLocation stores a manual location for location.Network, or for the single IP when
+// the network is not set. ErrConflict is returned when the network is already stored
+func (s *LocService) CreateLocation(ctx context.Context, location models.IPLocation) (models.IPLocation, error) {
+	location, err := manualLocation(location.IP, location)
+	if err != nil {
+		return models.IPLocation{}, err
+	}
+
+	s.log.Debug("Создание локации", "network", location.Network, "country", location.Country, "city", location.City)
+	err = s.repo.Create(ctx, location)
+	if errors.Is(err, ErrConflict) {
+		s.log.Debug("Локация уже существует", "network", location.Network)
+		return models.IPLocation{}, err
+	}
+	if err != nil {
+		s.log.Error("Ошибка при создании локации", "error", err)
+		return models.IPLocation{}, err
+	}
+	s.log.Debug("Локация создана в базе данных", "network", location.Network)
+	return location, nil
+}
+
+// ReplaceLocation stores a manual location for ip, or for location.Network when it contains ip,
+// replacing the data of the same stored network. It reports whether the network was created
+func (s *LocService) ReplaceLocation(ctx context.Context, ip string, location models.IPLocation) (models.IPLocation, bool, error) {
+	if ip == "" {
+		return models.IPLocation{}, false, fmt.Errorf("%w: IP address is required", ErrInvalidQuery)
+	}
+
+	location, err := manualLocation(ip, location)
+	if err != nil {
+		return models.IPLocation{}, false, err
+	}
+
+	s.log.Debug("Замена локации", "network", location.Network, "country", location.Country, "city", location.City)
+	created, err := s.repo.Replace(ctx, location)
+	if err != nil {
+		s.log.Error("Ошибка при замене локации", "error", err)
+		return models.IPLocation{}, false, err
+	}
+	s.log.Debug("Локация сохранена в базе данных", "network", location.Network, "created", created)
+	return location, created, nil
+}
+
+// PatchLocation changes the given fields of the most specific stored network containing ip,
+// the location becomes manual. ErrNotFound is returned when there is none
+func (s *LocService) PatchLocation(ctx context.Context, ip string, patch models.LocationPatch) (models.IPLocation, error) {
+	ip, err := ipaddr.Normalize(ip)
+	if err != nil {
+		return models.IPLocation{}, err
+	}
+
+	location, err := s.repo.GetByIP(ctx, ip)
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			s.log.Error("Ошибка при поиске локации по IP в базе данных", "ip", ip, "error", err)
+		}
+		return models.IPLocation{}, err
+	}
+
+	applyPatch(&location, patch)
+	location.Provider = ProviderManual
+	location.FetchedAt = nil
+
+	s.log.Debug("Обновление локации", "ip", ip, "network", location.Network)
+	err = s.repo.Update(ctx, location)
+	if errors.Is(err, ErrNotFound) {
+		s.log.Debug("Локация для обновления не найдена", "ip", ip)
+		return models.IPLocation{}, err
+	}
+	if err != nil {
+		s.log.Error("Ошибка при обновлении локации", "error", err)
+		return models.IPLocation{}, err
+	}
+	s.log.Debug("Локация обновлена в базе данных", "ip", ip)
+	return location, nil
+}
+
+// manualLocation validates a location entered through the API for ip. Its network defaults
+// to the single IP, ip defaults to the first address of the network
+func manualLocation(ip string, location models.IPLocation) (models.IPLocation, error) {
+	var network netip.Prefix
+	if location.Network != "" {
+		prefix, err := netip.ParsePrefix(location.Network)
+		if err != nil {
+			return models.IPLocation{}, fmt.Errorf("%w: invalid network %q", ErrInvalidLocation, location.Network)
+		}
+		network = prefix.Masked()
+	}
+
+	var addr netip.Addr
+	switch {
+	case ip != "":
+		var err error
+		if addr, err = ipaddr.Parse(ip); err != nil {
+			return models.IPLocation{}, err
+		}
+	case network.IsValid():
+		addr = network.Addr()
+	default:
+		return models.IPLocation{}, fmt.Errorf("%w: IP address or network is required", ErrInvalidLocation)
+	}
+
+	if !network.IsValid() {
+		network = netip.PrefixFrom(addr, addr.BitLen())
+	} else if !network.Contains(addr) {
+		return models.IPLocation{}, fmt.Errorf("%w: network %s doesn't contain %s", ErrInvalidLocation, network, addr)
+	}
+
+	if kind := ipaddr.Classify(addr); kind != "" {
+		return models.IPLocation{}, fmt.Errorf("%w: %s is %s", ErrReservedRange, addr, kind)
+	}
+
+	location.IP = addr.String()
+	location.Network = network.String()
+	location.Provider = ProviderManual
+	location.CreatedAt = nil
+	location.FetchedAt = nil
+	location.Type = ""
+	return location, nil
+}
+
+func applyPatch(location *models.IPLocation, patch models.LocationPatch) {
+	for _, f := range []struct {
+		dst *string
+		src *string
+	}{
+		{&location.Country, patch.Country},
+		{&location.Region, patch.Region},
+		{&location.City, patch.City},
+		{&location.Zip, patch.Zip},
+		{&location.Timezone, patch.Timezone},
+		{&location.ISP, patch.ISP},
+		{&location.Org, patch.Org},
+		{&location.AS, patch.AS},
+	} {
+		if f.src != nil {
+			*f.dst = *f.src
+		}
+	}
+	if patch.Lat != nil {
+		location.Lat = *patch.Lat
+	}
+	if patch.Lon != nil {
+		location.Lon = *patch.Lon
+	}
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/Fyefhqdishka/LocFinder/internal/models"
+	"github.com/oschwald/geoip2-golang"
+	"github.com/oschwald/maxminddb-golang"
+	"net"
+)
+
+const ProviderMMDB = "mmdb"
+
+// MMDBProvider resolves locations offline from a MaxMind GeoLite2/GeoIP2 City database.
+// Locations carry the database network the IP belongs to
+type MMDBProvider struct {
+	db *maxminddb.Reader
+}
+
+func NewMMDBProvider(path string) (*MMDBProvider, error) {
+	if path == "" {
+		return nil, fmt.Errorf("path to mmdb database is not set")
+	}
+
+	db, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't open mmdb database %s: %v", path, err)
+	}
+
+	return &MMDBProvider{db: db}, nil
+}
+
+func (p *MMDBProvider) Name() string {
+	return ProviderMMDB
+}
+
+func (p *MMDBProvider) Lookup(ctx context.Context, ip string) (models.IPLocation, error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return models.IPLocation{}, fmt.Errorf("%w: %q", ErrInvalidQuery, ip)
+	}
+
+	var record geoip2.City
+	network, found, err := p.db.LookupNetwork(parsed, &record)
+	if err != nil {
+		return models.IPLocation{}, err
+	}
+	if !found {
+		return models.IPLocation{}, ErrEmptyLocation
+	}
+
+	location := models.IPLocation{
+		IP:       ip,
+		Country:  record.Country.Names["en"],
+		City:     record.City.Names["en"],
+		Zip:      record.Postal.Code,
+		Lat:      record.Location.Latitude,
+		Lon:      record.Location.Longitude,
+		Timezone: record.Location.TimeZone,
+		Provider: p.Name(),
+		Network:  network.String(),
+	}
+	if len(record.Subdivisions) > 0 {
+		location.Region = record.Subdivisions[0].Names["en"]
+	}
+
+	return location, nil
+}
+
+func (p *MMDBProvider) Close() error {
+	return p.db.Close()
+}
@@ -0,0 +1,59 @@
+package service_test
+
+import (
+	"context"
+	"github.com/Fyefhqdishka/LocFinder/internal/service"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// testdata/GeoIP2-City-Test.mmdb is an IPv4 City database of two networks: 81.2.69.0/24 with
+// a full London record and 89.160.20.0/24 known only down to the country
+func newTestMMDBProvider(t *testing.T) *service.MMDBProvider {
+	provider, err := service.NewMMDBProvider("testdata/GeoIP2-City-Test.mmdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { provider.Close() })
+	return provider
+}
+
+func TestMMDBProviderLookup(t *testing.T) {
+	provider := newTestMMDBProvider(t)
+
+	location, err := provider.Lookup(context.Background(), "81.2.69.160")
+	assert.NoError(t, err)
+	assert.Equal(t, "81.2.69.160", location.IP)
+	assert.Equal(t, "81.2.69.0/24", location.Network)
+	assert.Equal(t, "United Kingdom", location.Country)
+	assert.Equal(t, "England", location.Region)
+	assert.Equal(t, "London", location.City)
+	assert.Equal(t, "EC2V", location.Zip)
+	assert.Equal(t, 51.5142, location.Lat)
+	assert.Equal(t, -0.0931, location.Lon)
+	assert.Equal(t, "Europe/London", location.Timezone)
+	assert.Equal(t, service.ProviderMMDB, location.Provider)
+}
+
+func TestMMDBProviderWithoutCity(t *testing.T) {
+	provider := newTestMMDBProvider(t)
+
+	location, err := provider.Lookup(context.Background(), "89.160.20.112")
+	assert.NoError(t, err)
+	assert.Equal(t, "89.160.20.0/24", location.Network)
+	assert.Equal(t, "Sweden", location.Country)
+	assert.Empty(t, location.Region)
+	assert.Empty(t, location.City)
+	assert.Empty(t, location.Zip)
+	assert.Equal(t, "Europe/Stockholm", location.Timezone)
+}
+
+func TestMMDBProviderNotFound(t *testing.T) {
+	provider := newTestMMDBProvider(t)
+
+	_, err := provider.Lookup(context.Background(), "8.8.8.8")
+	assert.ErrorIs(t, err, service.ErrEmptyLocation)
+
+	_, err = provider.Lookup(context.Background(), "foo")
+	assert.ErrorIs(t, err, service.ErrInvalidQuery)
+}
@@ -0,0 +1,326 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Fyefhqdishka/LocFinder/internal/config"
+	"github.com/Fyefhqdishka/LocFinder/internal/models"
+	"github.com/Fyefhqdishka/LocFinder/internal/storage/repositoryInterfaces"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Provider resolves an IP address into a location using some external or local source
+type Provider interface {
+	// Name returns a short identifier of the provider, e.g. "ipapi"
+	Name() string
+	// Lookup returns a normalized location for ip
+	Lookup(ctx context.Context, ip string) (models.IPLocation, error)
+}
+
+// BatchProvider is implemented by providers able to resolve many addresses in one call.
+// Returned locations and errors are aligned with ips
+type BatchProvider interface {
+	Provider
+	LookupBatch(ctx context.Context, ips []string) ([]models.IPLocation, []error)
+}
+
+// ErrEmptyLocation is returned when a provider answered but had no data for the IP
+var ErrEmptyLocation = errors.New("provider returned empty location")
+
+// NewProvider builds a single provider by its name. ranges is only used by the dataset
+// provider and may be nil when it's not configured
+func NewProvider(name string, cfg config.Geo, ranges repositoryInterfaces.RangeStorage) (Provider, error) {
+	switch name {
+	case ProviderIPAPI:
+		limiter := NewRateLimiter(name, cfg.RateLimits[name], cfg.RateLimitMaxWait)
+		batchLimiter := NewRateLimiter(name, cfg.IPAPI.BatchRateLimit, cfg.RateLimitMaxWait)
+		return NewIPAPIProvider(cfg.IPAPI.BaseURL, cfg.ProviderTimeout(name), limiter, batchLimiter), nil
+	case ProviderIPWhois:
+		limiter := NewRateLimiter(name, cfg.RateLimits[name], cfg.RateLimitMaxWait)
+		return NewIPWhoisProvider(cfg.IPWhois.BaseURL, cfg.ProviderTimeout(name), limiter), nil
+	case ProviderMMDB:
+		return NewMMDBProvider(cfg.MMDB.Path)
+	case ProviderDataset:
+		if ranges == nil {
+			return nil, fmt.Errorf("geolocation provider %q requires range storage", name)
+		}
+		return NewDatasetProvider(ranges), nil
+	default:
+		return nil, fmt.Errorf("unknown geolocation provider %q", name)
+	}
+}
+
+// ChainProvider asks providers one by one until one of them returns a location
+type ChainProvider struct {
+	providers []Provider
+	// timeouts limit a single request of the provider with the same index
+	timeouts []time.Duration
+	// breakers guard the provider with the same index
+	breakers []*CircuitBreaker
+	retry    RetryPolicy
+	workers  int
+	log      *slog.Logger
+}
+
+// NewChainProvider builds the ordered list of providers from the configuration
+func NewChainProvider(cfg config.Geo, ranges repositoryInterfaces.RangeStorage, log *slog.Logger) (*ChainProvider, error) {
+	chain := &ChainProvider{
+		retry: RetryPolicy{
+			Attempts:  cfg.Retry.Attempts,
+			BaseDelay: cfg.Retry.BaseDelay,
+			MaxDelay:  cfg.Retry.MaxDelay,
+		},
+		workers: max(cfg.BatchWorkers, 1),
+		log:     log,
+	}
+	for _, name := range cfg.Providers {
+		provider, err := NewProvider(name, cfg, ranges)
+		if err != nil {
+			chain.Close()
+			return nil, err
+		}
+		chain.providers = append(chain.providers, provider)
+		chain.timeouts = append(chain.timeouts, cfg.ProviderTimeout(name))
+		chain.breakers = append(chain.breakers, NewCircuitBreaker(cfg.Breaker.Threshold, cfg.Breaker.OpenTimeout))
+	}
+
+	return chain, nil
+}
+
+func (c *ChainProvider) Name() string {
+	return "chain"
+}
+
+func (c *ChainProvider) Lookup(ctx context.Context, ip string) (models.IPLocation, error) {
+	var errs []error
+	for i, provider := range c.providers {
+		location, err := c.lookup(ctx, i, ip)
+		if err == nil {
+			return location, nil
+		}
+
+		if isFinal(err) {
+			return models.IPLocation{}, err
+		}
+
+		c.log.Warn("Провайдер не смог определить локацию", "provider", provider.Name(), "ip", ip, "error", err)
+		errs = append(errs, fmt.Errorf("%s: %w", provider.Name(), err))
+
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	return models.IPLocation{}, fmt.Errorf("all providers failed: %w", errors.Join(errs...))
+}
+
+// lookup asks the provider with index p through its circuit breaker, retrying transient errors
+func (c *ChainProvider) lookup(ctx context.Context, p int, ip string) (models.IPLocation, error) {
+	breaker := c.breakers[p]
+	if err := breaker.Allow(); err != nil {
+		return models.IPLocation{}, err
+	}
+
+	var location models.IPLocation
+	err := c.retry.retry(ctx, func() error {
+		var err error
+		location, err = c.lookupOnce(ctx, c.providers[p], c.timeouts[p], ip)
+		return err
+	})
+	breaker.Record(err)
+
+	return location, err
+}
+
+func (c *ChainProvider) lookupOnce(ctx context.Context, provider Provider, timeout time.Duration, ip string) (models.IPLocation, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	location, err := provider.Lookup(ctx, ip)
+	return checkLocation(provider, ip, location, err)
+}
+
+// checkLocation rejects empty answers and marks the location with the provider name
+func checkLocation(provider Provider, ip string, location models.IPLocation, err error) (models.IPLocation, error) {
+	if err != nil {
+		return models.IPLocation{}, err
+	}
+
+	if location.Country == "" && location.City == "" {
+		return models.IPLocation{}, ErrEmptyLocation
+	}
+
+	if location.IP == "" {
+		location.IP = ip
+	}
+	location.Provider = provider.Name()
+
+	return location, nil
+}
+
+// LookupBatch resolves ips asking providers in order, addresses a provider failed on are passed
+// to the next one. Providers without batch support are queried concurrently by a bounded pool of workers
+func (c *ChainProvider) LookupBatch(ctx context.Context, ips []string) ([]models.IPLocation, []error) {
+	locations := make([]models.IPLocation, len(ips))
+	errs := make([]error, len(ips))
+	failures := make([][]error, len(ips))
+
+	pending := make([]int, len(ips))
+	for i := range ips {
+		pending[i] = i
+	}
+
+	for p, provider := range c.providers {
+		if len(pending) == 0 || ctx.Err() != nil {
+			break
+		}
+
+		query := make([]string, len(pending))
+		for j, i := range pending {
+			query[j] = ips[i]
+		}
+
+		found, failed := c.lookupMany(ctx, p, query)
+
+		var next []int
+		for j, i := range pending {
+			switch err := failed[j]; {
+			case err == nil:
+				locations[i] = found[j]
+			case isFinal(err):
+				errs[i] = err
+			default:
+				failures[i] = append(failures[i], fmt.Errorf("%s: %w", provider.Name(), err))
+				next = append(next, i)
+			}
+		}
+
+		if len(next) > 0 {
+			c.log.Warn("Провайдер не смог определить часть локаций", "provider", provider.Name(), "failed", len(next), "total", len(pending))
+		}
+		pending = next
+	}
+
+	for _, i := range pending {
+		if len(failures[i]) == 0 {
+			failures[i] = append(failures[i], ctx.Err())
+		}
+		errs[i] = fmt.Errorf("all providers failed: %w", errors.Join(failures[i]...))
+	}
+
+	return locations, errs
+}
+
+// lookupMany asks the provider with index p about ips. The timeout applies to every single request,
+// batch providers are expected to enforce it themselves per request of a batch
+func (c *ChainProvider) lookupMany(ctx context.Context, p int, ips []string) ([]models.IPLocation, []error) {
+	if batcher, ok := c.providers[p].(BatchProvider); ok {
+		return c.lookupBatch(ctx, p, batcher, ips)
+	}
+
+	locations := make([]models.IPLocation, len(ips))
+	errs := make([]error, len(ips))
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range min(c.workers, len(ips)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				locations[i], errs[i] = c.lookup(ctx, p, ips[i])
+			}
+		}()
+	}
+
+	for i := range ips {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return locations, errs
+}
+
+// lookupBatch asks a batch provider through its circuit breaker. Addresses failed with
+// a transient error are asked again, the breaker counts a failure only if none was answered
+func (c *ChainProvider) lookupBatch(ctx context.Context, p int, batcher BatchProvider, ips []string) ([]models.IPLocation, []error) {
+	locations := make([]models.IPLocation, len(ips))
+	errs := make([]error, len(ips))
+
+	breaker := c.breakers[p]
+	if err := breaker.Allow(); err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return locations, errs
+	}
+
+	pending := make([]int, len(ips))
+	for i := range ips {
+		pending[i] = i
+	}
+
+	c.retry.retry(ctx, func() error {
+		query := make([]string, len(pending))
+		for j, i := range pending {
+			query[j] = ips[i]
+		}
+
+		found, failed := batcher.LookupBatch(ctx, query)
+
+		var next []int
+		var transient error
+		for j, i := range pending {
+			locations[i], errs[i] = checkLocation(batcher, ips[i], found[j], failed[j])
+			if isTransient(errs[i]) {
+				next = append(next, i)
+				transient = errs[i]
+			}
+		}
+		pending = next
+		return transient
+	})
+
+	// a single answered address proves the provider is up
+	outcome := errs[0]
+	for _, err := range errs {
+		if !isTransient(err) {
+			outcome = err
+			break
+		}
+	}
+	breaker.Record(outcome)
+
+	return locations, errs
+}
+
+// Health reports the circuit breaker state of every provider in the chain
+func (c *ChainProvider) Health() []models.ProviderHealth {
+	health := make([]models.ProviderHealth, len(c.providers))
+	for i, provider := range c.providers {
+		state, failures := c.breakers[i].State()
+		health[i] = models.ProviderHealth{Name: provider.Name(), State: state, Failures: failures}
+	}
+	return health
+}
+
+// Close closes every provider holding resources, e.g. an open mmdb file
+func (c *ChainProvider) Close() error {
+	var errs []error
+	for _, provider := range c.providers {
+		if closer, ok := provider.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
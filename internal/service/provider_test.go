@@ -0,0 +1,86 @@
+package service_test
+
+import (
+	"context"
+	"github.com/Fyefhqdishka/LocFinder/internal/config"
+	"github.com/Fyefhqdishka/LocFinder/internal/service"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestChainProviderFallsBack(t *testing.T) {
+	ipAPI := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"fail","message":"quota exceeded","query":"37.99.42.212"}`))
+	}))
+	defer ipAPI.Close()
+
+	ipWhois := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ip":"37.99.42.212","success":true,"country":"Kazakhstan","city":"Almaty"}`))
+	}))
+	defer ipWhois.Close()
+
+	chain, err := service.NewChainProvider(config.Geo{
+		Providers: []string{service.ProviderIPAPI, service.ProviderIPWhois},
+		Timeout:   time.Second,
+		IPAPI:     config.IPAPI{BaseURL: ipAPI.URL},
+		IPWhois:   config.IPWhois{BaseURL: ipWhois.URL},
+	}, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	assert.NoError(t, err)
+
+	location, err := chain.Lookup(context.Background(), "37.99.42.212")
+	assert.NoError(t, err)
+	assert.Equal(t, "Kazakhstan", location.Country)
+	assert.Equal(t, service.ProviderIPWhois, location.Provider)
+}
+
+func TestChainProviderAllFailed(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	chain, err := service.NewChainProvider(config.Geo{
+		Providers: []string{service.ProviderIPAPI, service.ProviderIPWhois},
+		IPAPI:     config.IPAPI{BaseURL: srv.URL},
+		IPWhois:   config.IPWhois{BaseURL: srv.URL},
+	}, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	assert.NoError(t, err)
+
+	_, err = chain.Lookup(context.Background(), "37.99.42.212")
+	assert.Error(t, err)
+}
+
+func TestChainProviderTimeout(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer slow.Close()
+
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ip":"37.99.42.212","success":true,"country":"Kazakhstan","city":"Almaty"}`))
+	}))
+	defer fast.Close()
+
+	chain, err := service.NewChainProvider(config.Geo{
+		Providers: []string{service.ProviderIPAPI, service.ProviderIPWhois},
+		Timeout:   time.Second,
+		Timeouts:  map[string]time.Duration{service.ProviderIPAPI: 50 * time.Millisecond},
+		IPAPI:     config.IPAPI{BaseURL: slow.URL},
+		IPWhois:   config.IPWhois{BaseURL: fast.URL},
+	}, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	assert.NoError(t, err)
+
+	start := time.Now()
+	location, err := chain.Lookup(context.Background(), "37.99.42.212")
+	assert.NoError(t, err)
+	assert.Equal(t, service.ProviderIPWhois, location.Provider)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"golang.org/x/time/rate"
+	"sync"
+	"time"
+)
+
+// QuotaError is returned when a provider's request quota is exhausted
+// and the caller can't wait until it is replenished
+type QuotaError struct {
+	Provider   string
+	RetryAfter time.Duration
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("%s: %v, retry after %s", e.Provider, ErrQuotaExceeded, e.RetryAfter.Round(time.Second))
+}
+
+func (e *QuotaError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
+
+// RateLimiter is a token bucket limiting outgoing requests to a provider. Besides its own
+// rate it honours quota reported by the provider and holds all requests until the quota resets.
+// Callers wait for a token at most maxWait (or until their context deadline), otherwise they get QuotaError.
+// A nil RateLimiter doesn't limit anything
+type RateLimiter struct {
+	provider string
+	limiter  *rate.Limiter
+	maxWait  time.Duration
+
+	mu           sync.Mutex
+	blockedUntil time.Time
+}
+
+// NewRateLimiter allows perMinute requests per minute with bursts of the same size,
+// it returns nil when perMinute is not positive
+func NewRateLimiter(provider string, perMinute int, maxWait time.Duration) *RateLimiter {
+	if perMinute <= 0 {
+		return nil
+	}
+
+	return &RateLimiter{
+		provider: provider,
+		limiter:  rate.NewLimiter(rate.Limit(float64(perMinute)/60), perMinute),
+		maxWait:  maxWait,
+	}
+}
+
+// Wait blocks until a request may be sent
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	now := time.Now()
+	deadline := now.Add(l.maxWait)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+
+	l.mu.Lock()
+	blockedUntil := l.blockedUntil
+	l.mu.Unlock()
+
+	if blockedUntil.After(now) {
+		if blockedUntil.After(deadline) {
+			return &QuotaError{Provider: l.provider, RetryAfter: blockedUntil.Sub(now)}
+		}
+		if err := sleep(ctx, blockedUntil.Sub(now)); err != nil {
+			return err
+		}
+		now = time.Now()
+	}
+
+	reservation := l.limiter.ReserveN(now, 1)
+	delay := reservation.DelayFrom(now)
+	if now.Add(delay).After(deadline) {
+		reservation.CancelAt(now)
+		return &QuotaError{Provider: l.provider, RetryAfter: delay}
+	}
+
+	if err := sleep(ctx, delay); err != nil {
+		reservation.Cancel()
+		return err
+	}
+	return nil
+}
+
+// Block holds all requests until the given time, e.g. when the provider reports an exhausted quota
+func (l *RateLimiter) Block(until time.Time) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	if until.After(l.blockedUntil) {
+		l.blockedUntil = until
+	}
+	l.mu.Unlock()
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
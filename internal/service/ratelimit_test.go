@@ -0,0 +1,87 @@
+package service_test
+
+import (
+	"context"
+	"github.com/Fyefhqdishka/LocFinder/internal/service"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterQuotaExhausted(t *testing.T) {
+	limiter := service.NewRateLimiter("ipapi", 2, 0)
+
+	assert.NoError(t, limiter.Wait(context.Background()))
+	assert.NoError(t, limiter.Wait(context.Background()))
+
+	err := limiter.Wait(context.Background())
+	assert.ErrorIs(t, err, service.ErrQuotaExceeded)
+
+	var quotaErr *service.QuotaError
+	assert.ErrorAs(t, err, &quotaErr)
+	assert.Greater(t, quotaErr.RetryAfter, time.Duration(0))
+}
+
+func TestRateLimiterWaitsUpToMaxWait(t *testing.T) {
+	limiter := service.NewRateLimiter("ipapi", 600, time.Second)
+	for range 600 {
+		assert.NoError(t, limiter.Wait(context.Background()))
+	}
+
+	start := time.Now()
+	assert.NoError(t, limiter.Wait(context.Background()))
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+}
+
+func TestIPAPIProviderHonoursRateLimitHeaders(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("X-Rl", "0")
+		w.Header().Set("X-Ttl", "30")
+		w.Write([]byte(`{"status":"success","query":"37.99.42.212","country":"Kazakhstan","city":"Almaty"}`))
+	}))
+	defer srv.Close()
+
+	provider := service.NewIPAPIProvider(srv.URL, time.Second, service.NewRateLimiter("ipapi", 45, 0), nil)
+
+	_, err := provider.Lookup(context.Background(), "37.99.42.212")
+	assert.NoError(t, err)
+
+	_, err = provider.Lookup(context.Background(), "37.99.42.212")
+	var quotaErr *service.QuotaError
+	assert.ErrorAs(t, err, &quotaErr)
+	assert.InDelta(t, 30*time.Second, quotaErr.RetryAfter, float64(time.Second))
+	assert.Equal(t, 1, calls)
+}
+
+func TestIPAPIProviderBatchQuota(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/batch" {
+			w.Header().Set("X-Rl", "0")
+			w.Header().Set("X-Ttl", "20")
+			w.Write([]byte(`[{"status":"success","query":"37.99.42.212","country":"Kazakhstan","city":"Almaty"}]`))
+			return
+		}
+		w.Header().Set("X-Rl", "44")
+		w.Write([]byte(`{"status":"success","query":"37.99.42.212","country":"Kazakhstan","city":"Almaty"}`))
+	}))
+	defer srv.Close()
+
+	provider := service.NewIPAPIProvider(srv.URL, time.Second,
+		service.NewRateLimiter("ipapi", 45, 0), service.NewRateLimiter("ipapi", 15, 0))
+
+	_, errs := provider.LookupBatch(context.Background(), []string{"37.99.42.212"})
+	assert.NoError(t, errs[0])
+
+	// the exhausted /batch quota holds batches only
+	_, errs = provider.LookupBatch(context.Background(), []string{"37.99.42.212"})
+	var quotaErr *service.QuotaError
+	assert.ErrorAs(t, errs[0], &quotaErr)
+	assert.InDelta(t, 20*time.Second, quotaErr.RetryAfter, float64(time.Second))
+
+	_, err := provider.Lookup(context.Background(), "37.99.42.212")
+	assert.NoError(t, err)
+}
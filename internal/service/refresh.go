@@ -0,0 +1,94 @@
+package service
+
+import (
+	"context"
+	"github.com/Fyefhqdishka/LocFinder/internal/models"
+	"time"
+)
+
+// expired reports whether the stored location is older than the configured TTL
+func (s *LocService) expired(location models.IPLocation) bool {
+	return s.ttl > 0 && location.FetchedAt != nil && time.Since(*location.FetchedAt) > s.ttl
+}
+
+// refresh fetches the location of an already stored IP again and replaces the data of the stored
+// network it belongs to. Concurrent refreshes of the same IP are coalesced into one provider request
+func (s *LocService) refresh(ctx context.Context, ip, network string) (models.IPLocation, error) {
+	location, _, err := s.coalesce(ctx, ip, func(ctx context.Context) (models.IPLocation, error) {
+		location, err := s.FetchFromAPI(ctx, ip)
+		if err != nil {
+			return models.IPLocation{}, err
+		}
+		location.IP = ip
+		location.Network = network
+
+		if err := s.repo.Refresh(ctx, location); err != nil {
+			return models.IPLocation{}, err
+		}
+
+		now := time.Now()
+		location.FetchedAt = &now
+		return location, nil
+	})
+	return location, err
+}
+
+// refreshAsync refreshes a stale location in background, the caller is served the stale copy
+func (s *LocService) refreshAsync(ip, network string) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), s.fetchTimeout)
+		defer cancel()
+
+		if _, err := s.refresh(ctx, ip, network); err != nil {
+			s.log.Warn("Не удалось обновить устаревшую локацию в фоне", "ip", ip, "error", err)
+			return
+		}
+		s.log.Debug("Устаревшая локация обновлена в фоне", "ip", ip)
+	}()
+}
+
+// RefreshStale re-fetches up to limit expired locations, the oldest first,
+// and returns the number of refreshed ones. A location is stored for its whole network, so
+// it's fetched by the first address of the network rather than by the IP once looked up
+func (s *LocService) RefreshStale(ctx context.Context, limit int) (int, error) {
+	if s.ttl <= 0 {
+		return 0, nil
+	}
+
+	stale, err := s.repo.GetStale(ctx, time.Now().Add(-s.ttl), limit)
+	if err != nil {
+		s.log.Error("Ошибка при получении устаревших локаций", "error", err)
+		return 0, err
+	}
+
+	if len(stale) == 0 {
+		return 0, nil
+	}
+
+	ips := make([]string, len(stale))
+	for i, location := range stale {
+		ips[i] = location.IP
+	}
+
+	locations, errs := s.provider.LookupBatch(ctx, ips)
+
+	refreshed := 0
+	for i, ip := range ips {
+		if errs[i] != nil {
+			s.log.Warn("Не удалось обновить устаревшую локацию", "ip", ip, "error", errs[i])
+			continue
+		}
+
+		location := locations[i]
+		location.IP = ip
+		location.Network = stale[i].Network
+		if err := s.repo.Refresh(ctx, location); err != nil {
+			s.log.Error("Не удалось сохранить обновлённую локацию", "ip", ip, "error", err)
+			continue
+		}
+		refreshed++
+	}
+
+	s.log.Debug("Обновление устаревших локаций завершено", "stale", len(stale), "refreshed", refreshed)
+	return refreshed, nil
+}
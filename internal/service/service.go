@@ -1,99 +1,421 @@
 package service
 
 import (
-	"database/sql"
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
+	"github.com/Fyefhqdishka/LocFinder/internal/config"
+	"github.com/Fyefhqdishka/LocFinder/internal/ipaddr"
 	"github.com/Fyefhqdishka/LocFinder/internal/models"
 	"github.com/Fyefhqdishka/LocFinder/internal/storage/repositoryInterfaces"
-	"io/ioutil"
+	"golang.org/x/sync/singleflight"
+	"io"
 	"log/slog"
 	"net/http"
+	"net/netip"
+	"strings"
+	"time"
 )
 
 type ServiceInterface interface {
-	GetLocationByIP(ip string) (*models.IPLocation, error)
-	UpdateLocation(ip, country, city string) error
-	DeleteLocation(ip string) error
-	GetAllLocations() ([]models.IPLocation, error)
-	GetExternalIP() (string, error)
-	FetchFromAPI(ip string) (models.IPLocation, error)
+	GetLocationByIP(ctx context.Context, ip string) (*models.IPLocation, error)
+	LookupLocations(ctx context.Context, ips []string) ([]models.LookupResult, error)
+	CreateLocation(ctx context.Context, location models.IPLocation) (models.IPLocation, error)
+	ReplaceLocation(ctx context.Context, ip string, location models.IPLocation) (models.IPLocation, bool, error)
+	PatchLocation(ctx context.Context, ip string, patch models.LocationPatch) (models.IPLocation, error)
+	DeleteLocation(ctx context.Context, ip string) error
+	GetAllLocations(ctx context.Context, query models.LocationQuery) (models.LocationPage, error)
+	ExportLocations(ctx context.Context, query models.LocationQuery, fn func(models.IPLocation) error) error
+	GetExternalIP(ctx context.Context) (string, error)
+	FetchFromAPI(ctx context.Context, ip string) (models.IPLocation, error)
+	Health(ctx context.Context) models.Health
 }
 
+// Health statuses, the service is degraded while some providers are short-circuited
+// and unavailable when all of them are
+const (
+	HealthOK          = "ok"
+	HealthDegraded    = "degraded"
+	HealthUnavailable = "unavailable"
+)
+
+// page size limits of GetAllLocations
+const (
+	DefaultPageSize = 100
+	MaxPageSize     = 1000
+)
+
 type LocService struct {
-	repo repositoryInterfaces.Storage
-	log  *slog.Logger
+	repo         repositoryInterfaces.Storage
+	provider     BatchProvider
+	batchMaxSize int
+	ttl          time.Duration
+	serveStale   bool
+	network      config.Network
+	inflight     singleflight.Group
+	fetchTimeout time.Duration
+	client       *http.Client
+	log          *slog.Logger
+}
+
+// NewLocService creates the service with the chain of geolocation providers selected in cfg.
+// ranges backs the dataset provider and may be nil when it's not configured
+func NewLocService(repo repositoryInterfaces.Storage, ranges repositoryInterfaces.RangeStorage, cfg *config.Config, log *slog.Logger) (*LocService, error) {
+	provider, err := NewChainProvider(cfg.Geo, ranges, log)
+	if err != nil {
+		return nil, err
+	}
+
+	// provider requests shared by several callers must not outlive the HTTP server timeout
+	fetchTimeout := cfg.Server.Timeout
+	if fetchTimeout <= 0 {
+		fetchTimeout = config.DefaultTimeout
+	}
+
+	return &LocService{
+		repo:         repo,
+		provider:     provider,
+		batchMaxSize: cfg.Geo.BatchMaxSize,
+		ttl:          cfg.Refresh.TTL,
+		serveStale:   cfg.Refresh.ServeStale,
+		network:      cfg.Network,
+		fetchTimeout: fetchTimeout,
+		client:       &http.Client{Timeout: fetchTimeout},
+		log:          log,
+	}, nil
+}
+
+// Close releases resources held by the providers, e.g. an open mmdb file
+func (s *LocService) Close() error {
+	if closer, ok := s.provider.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
 }
 
-func NewLocService(repo repositoryInterfaces.Storage, log *slog.Logger) *LocService {
-	return &LocService{repo: repo, log: log}
+// Health reports the circuit breaker state of the providers and the statistics of the location cache
+func (s *LocService) Health(ctx context.Context) models.Health {
+	health := models.Health{Status: HealthOK}
+	if reporter, ok := s.provider.(interface {
+		Health() []models.ProviderHealth
+	}); ok {
+		health.Providers = reporter.Health()
+	}
+	if cache, ok := s.repo.(interface {
+		Stats() models.CacheStats
+	}); ok {
+		stats := cache.Stats()
+		health.Cache = &stats
+	}
+
+	open := 0
+	for _, provider := range health.Providers {
+		if provider.State == BreakerOpen {
+			open++
+		}
+	}
+	switch {
+	case open > 0 && open == len(health.Providers):
+		health.Status = HealthUnavailable
+	case open > 0:
+		health.Status = HealthDegraded
+	}
+
+	return health
 }
 
-func (s *LocService) GetExternalIP() (string, error) {
+func (s *LocService) GetExternalIP(ctx context.Context) (string, error) {
 	s.log.Debug("Попытка получить внешний IP через API", "url", "https://api.ipify.org")
-	resp, err := http.Get("https://api.ipify.org")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://api.ipify.org", nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := s.client.Do(req)
 	if err != nil {
 		s.log.Error("Не удалось получить внешний IP", "error", err)
-		return "", fmt.Errorf("не удалось получить внешний IP: %v", err)
+		return "", fmt.Errorf("%w: не удалось получить внешний IP: %v", ErrUnavailable, err)
 	}
 	defer resp.Body.Close()
 
-	body, err := ioutil.ReadAll(resp.Body)
+	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		s.log.Error("Не удалось прочитать тело ответа", "error", err)
-		return "", fmt.Errorf("не удалось прочитать тело ответа: %v", err)
+		return "", fmt.Errorf("%w: не удалось прочитать тело ответа: %v", ErrUnavailable, err)
 	}
 
-	s.log.Debug("Успешно получен внешний IP", "ip", string(body))
-	return string(body), nil
+	ip := strings.TrimSpace(string(body))
+	s.log.Debug("Успешно получен внешний IP", "ip", ip)
+	return ip, nil
 }
 
-func (s *LocService) GetLocationByIP(ip string) (*models.IPLocation, error) {
+func (s *LocService) GetLocationByIP(ctx context.Context, ip string) (*models.IPLocation, error) {
 	s.log.Debug("Поиск локации для IP", "ip", ip)
 
-	country, city, err := s.repo.GetByIP(ip)
+	addr, err := ipaddr.Parse(ip)
+	if err != nil {
+		s.log.Debug("Некорректный IP", "ip", ip, "error", err)
+		return nil, err
+	}
+	ip = addr.String()
+
+	if kind := ipaddr.Classify(addr); kind != "" {
+		s.log.Debug("IP относится к специальному диапазону", "ip", ip, "type", kind)
+		return &models.IPLocation{IP: ip, Type: kind}, nil
+	}
+
+	stored, err := s.repo.GetByIP(ctx, ip)
 	if err == nil {
-		s.log.Debug("Локация найдена в базе данных", "ip", ip, "country", country, "city", city)
-		return &models.IPLocation{IP: ip, Country: country, City: city}, nil
+		if !s.expired(stored) {
+			s.log.Debug("Локация найдена в базе данных", "ip", ip, "country", stored.Country, "city", stored.City)
+			return &stored, nil
+		}
+
+		if s.serveStale {
+			s.log.Debug("Локация устарела, обновляем в фоне", "ip", ip, "fetched_at", stored.FetchedAt)
+			s.refreshAsync(ip, stored.Network)
+			return &stored, nil
+		}
+
+		s.log.Debug("Локация устарела, запрашиваем заново", "ip", ip, "fetched_at", stored.FetchedAt)
+		location, err := s.refresh(ctx, ip, stored.Network)
+		if err != nil {
+			s.log.Warn("Не удалось обновить устаревшую локацию, отдаём сохранённую", "ip", ip, "error", err)
+			return &stored, nil
+		}
+		return &location, nil
 	}
 
-	if !errors.Is(err, sql.ErrNoRows) {
+	if !errors.Is(err, ErrNotFound) {
 		s.log.Error("Ошибка при поиске локации по IP в базе данных", "ip", ip, "error", err)
 		return nil, err
 	}
 
 	s.log.Debug("Локация не найдена в базе данных, пытаемся получить с внешнего API", "ip", ip)
-	location, err := s.FetchFromAPI(ip)
+	location, err := s.fetchAndSave(ctx, ip)
 	if err != nil {
-		s.log.Error("Не удалось получить локацию с API", "ip", ip, "error", err)
 		return nil, err
 	}
 
-	err = s.repo.Save(location.IP, location.Country, location.City)
+	return &location, nil
+}
+
+// fetchAndSave fetches the location from providers and stores it. Concurrent calls
+// for the same IP are coalesced, so only one provider request is made and all callers
+// receive its result
+func (s *LocService) fetchAndSave(ctx context.Context, ip string) (models.IPLocation, error) {
+	location, shared, err := s.coalesce(ctx, ip, func(ctx context.Context) (models.IPLocation, error) {
+		location, err := s.FetchFromAPI(ctx, ip)
+		if err != nil {
+			s.log.Error("Не удалось получить локацию с API", "ip", ip, "error", err)
+			return models.IPLocation{}, err
+		}
+		location.IP = ip
+		location.Network = s.networkOf(ip, location.Network)
+
+		if err := s.repo.Save(ctx, location); err != nil {
+			s.log.Error("Не удалось сохранить локацию в базе данных", "ip", ip, "error", err)
+			return models.IPLocation{}, err
+		}
+		now := time.Now()
+		location.FetchedAt = &now
+
+		s.log.Debug("Локация успешно сохранена в базе данных", "ip", ip, "country", location.Country, "city", location.City, "provider", location.Provider)
+		return location, nil
+	})
+	if err != nil {
+		return models.IPLocation{}, err
+	}
+
+	if shared {
+		s.log.Debug("Результат запроса локации разделён между одновременными запросами", "ip", ip)
+	}
+	return location, nil
+}
+
+// networkOf returns the range a provider answer for ip is stored for: the network reported
+// by the provider when it contains ip, otherwise ip masked to the configured prefix length
+func (s *LocService) networkOf(ip, reported string) string {
+	addr, err := netip.ParseAddr(ip)
 	if err != nil {
-		s.log.Error("Не удалось сохранить локацию в базе данных", "ip", ip, "error", err)
+		return reported
+	}
+
+	if prefix, err := netip.ParsePrefix(reported); err == nil && prefix.Masked().Contains(addr) {
+		return prefix.Masked().String()
+	}
+
+	bits := s.network.IPv4Prefix
+	if addr.Is6() {
+		bits = s.network.IPv6Prefix
+	}
+	if bits <= 0 || bits > addr.BitLen() {
+		bits = addr.BitLen()
+	}
+
+	prefix, _ := addr.Prefix(bits)
+	return prefix.String()
+}
+
+// coalesce runs fn once for all concurrent callers with the same key. fn gets a context
+// detached from the caller, so one client going away doesn't fail the others, bounded by
+// fetchTimeout instead. Every caller stops waiting as soon as its own ctx is done
+func (s *LocService) coalesce(ctx context.Context, key string, fn func(ctx context.Context) (models.IPLocation, error)) (models.IPLocation, bool, error) {
+	ch := s.inflight.DoChan(key, func() (any, error) {
+		fctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), s.fetchTimeout)
+		defer cancel()
+
+		location, err := fn(fctx)
+		if err != nil {
+			return nil, err
+		}
+		return location, nil
+	})
+
+	select {
+	case <-ctx.Done():
+		return models.IPLocation{}, false, ctx.Err()
+	case res := <-ch:
+		if res.Err != nil {
+			return models.IPLocation{}, res.Shared, res.Err
+		}
+		return res.Val.(models.IPLocation), res.Shared, nil
+	}
+}
+
+// LookupLocations resolves many IPs at once. Stored locations are read in a single query,
+// the rest is fetched from providers and saved. Errors of individual IPs are reported in results
+func (s *LocService) LookupLocations(ctx context.Context, ips []string) ([]models.LookupResult, error) {
+	if s.batchMaxSize > 0 && len(ips) > s.batchMaxSize {
+		return nil, fmt.Errorf("%w: got %d, max %d", ErrBatchTooLarge, len(ips), s.batchMaxSize)
+	}
+
+	s.log.Debug("Пакетный поиск локаций", "count", len(ips))
+
+	results := make([]models.LookupResult, len(ips))
+	byIP := make(map[string][]int)
+	var lookup []string
+	for i, raw := range ips {
+		results[i].IP = raw
+
+		addr, err := ipaddr.Parse(raw)
+		if err != nil {
+			results[i].Code, results[i].Error = CodeInvalidIP, err.Error()
+			continue
+		}
+		ip := addr.String()
+		results[i].IP = ip
+
+		if kind := ipaddr.Classify(addr); kind != "" {
+			results[i].Location = &models.IPLocation{IP: ip, Type: kind}
+			continue
+		}
+
+		if _, ok := byIP[ip]; !ok {
+			lookup = append(lookup, ip)
+		}
+		byIP[ip] = append(byIP[ip], i)
+	}
+
+	if len(lookup) == 0 {
+		return results, nil
+	}
+
+	stored, err := s.repo.GetByIPs(ctx, lookup)
+	if err != nil {
+		s.log.Error("Ошибка при пакетном поиске локаций в базе данных", "error", err)
 		return nil, err
 	}
 
-	s.log.Debug("Локация успешно сохранена в базе данных", "ip", ip, "country", location.Country, "city", location.City)
-	return &location, nil
+	found := make(map[string]models.IPLocation, len(lookup))
+	stale := make(map[string]models.IPLocation)
+	queued := make(map[string]bool)
+	for _, location := range stored {
+		switch {
+		case !s.expired(location):
+		case !s.serveStale:
+			stale[location.IP] = location
+			continue
+		case !queued[location.Network]:
+			// IPs of one network share the stored location, it is refreshed once
+			queued[location.Network] = true
+			s.refreshAsync(location.IP, location.Network)
+		}
+		found[location.IP] = location
+	}
+
+	var missing []string
+	for _, ip := range lookup {
+		if _, ok := found[ip]; !ok {
+			missing = append(missing, ip)
+		}
+	}
+	s.log.Debug("Локации найдены в базе данных", "found", len(stored), "stale", len(stale), "refreshing", len(queued), "missing", len(missing))
+
+	failed := make(map[string]error)
+	if len(missing) > 0 {
+		locations, errs := s.provider.LookupBatch(ctx, missing)
+		now := time.Now()
+		for i, ip := range missing {
+			old, isStale := stale[ip]
+			if errs[i] != nil {
+				if isStale {
+					found[ip] = old
+				} else {
+					s.log.Debug("Не удалось определить локацию", "ip", ip, "error", errs[i])
+					failed[ip] = errs[i]
+				}
+				continue
+			}
+
+			location := locations[i]
+			location.IP = ip
+			location.FetchedAt = &now
+
+			save := s.repo.Save
+			if isStale {
+				location.Network = old.Network
+				save = s.repo.Refresh
+			} else {
+				location.Network = s.networkOf(ip, location.Network)
+			}
+			if err := save(ctx, location); err != nil {
+				s.log.Error("Не удалось сохранить локацию в базе данных", "ip", ip, "error", err)
+			}
+			found[ip] = location
+		}
+	}
+
+	for ip, indexes := range byIP {
+		location, ok := found[ip]
+		var code, message string
+		if !ok {
+			code, message = ErrorCode(failed[ip])
+		}
+		for _, i := range indexes {
+			if ok {
+				results[i].Location = &location
+			} else {
+				results[i].Code, results[i].Error = code, message
+			}
+		}
+	}
+
+	return results, nil
 }
 
-func (s *LocService) UpdateLocation(ip, country, city string) error {
-	s.log.Debug("Обновление локации", "ip", ip, "country", country, "city", city)
-	err := s.repo.Update(ip, country, city)
+func (s *LocService) DeleteLocation(ctx context.Context, ip string) error {
+	ip, err := ipaddr.Normalize(ip)
 	if err != nil {
-		s.log.Error("Ошибка при обновлении локации", "error", err)
 		return err
 	}
-	s.log.Debug("Локация обновлена в базе данных", "ip", ip)
-	return nil
-}
 
-func (s *LocService) DeleteLocation(ip string) error {
 	s.log.Debug("Удаление локации", "ip", ip)
-	err := s.repo.Delete(ip)
+	err = s.repo.Delete(ctx, ip)
+	if errors.Is(err, ErrNotFound) {
+		s.log.Debug("Локация для удаления не найдена", "ip", ip)
+		return err
+	}
 	if err != nil {
 		s.log.Error("Ошибка при удалении локации", "error", err)
 		return err
@@ -102,46 +424,90 @@ func (s *LocService) DeleteLocation(ip string) error {
 	return nil
 }
 
-func (s *LocService) GetAllLocations() ([]models.IPLocation, error) {
-	s.log.Debug("Получение всех локаций из базы данных")
-	locations, err := s.repo.GetAll()
+// GetAllLocations returns a page of stored locations. The newest ones come first unless
+// another sort is requested, the page size defaults to DefaultPageSize and is capped by MaxPageSize
+func (s *LocService) GetAllLocations(ctx context.Context, query models.LocationQuery) (models.LocationPage, error) {
+	query, err := normalizeQuery(query)
 	if err != nil {
-		s.log.Error("Ошибка при получении всех локаций", "error", err)
-		return nil, err
+		return models.LocationPage{}, err
 	}
-	s.log.Debug("Получены все локации из базы данных", "count", len(locations))
-	return locations, nil
-}
 
-func (s *LocService) FetchFromAPI(ip string) (models.IPLocation, error) {
-	s.log.Debug("Запрос локации с внешнего API", "ip", ip)
-	apiURL := "http://ip-api.com/json/" + ip
-	resp, err := http.Get(apiURL)
+	s.log.Debug("Получение локаций из базы данных", "query", query)
+	page, err := s.repo.GetAll(ctx, query)
 	if err != nil {
-		s.log.Error("Ошибка при запросе к API", "error", err)
-		return models.IPLocation{}, err
+		s.log.Error("Ошибка при получении локаций", "error", err)
+		return models.LocationPage{}, err
 	}
-	defer resp.Body.Close()
+	s.log.Debug("Получены локации из базы данных", "count", len(page.Locations), "total", page.Total)
+	return page, nil
+}
 
-	body, err := ioutil.ReadAll(resp.Body)
+// ExportLocations calls fn for every stored location matching query, in the order of GetAllLocations.
+// Locations are read page by page following the repository cursor, so only one page is held in memory
+func (s *LocService) ExportLocations(ctx context.Context, query models.LocationQuery, fn func(models.IPLocation) error) error {
+	query.Limit, query.SkipTotal = MaxPageSize, true
+	query, err := normalizeQuery(query)
 	if err != nil {
-		s.log.Error("Не удалось прочитать тело ответа от API", "error", err)
-		return models.IPLocation{}, err
+		return err
+	}
+
+	s.log.Debug("Выгрузка локаций из базы данных", "query", query)
+	exported := 0
+	for {
+		page, err := s.repo.GetAll(ctx, query)
+		if err != nil {
+			s.log.Error("Ошибка при выгрузке локаций", "exported", exported, "error", err)
+			return err
+		}
+		for _, location := range page.Locations {
+			if err := fn(location); err != nil {
+				return err
+			}
+			exported++
+		}
+
+		if page.NextCursor == "" {
+			s.log.Debug("Выгрузка локаций завершена", "count", exported)
+			return nil
+		}
+		query.Cursor = page.NextCursor
+	}
+}
+
+// normalizeQuery validates the filters of a location listing and fills in the defaults
+func normalizeQuery(query models.LocationQuery) (models.LocationQuery, error) {
+	if query.Sort == "" {
+		query.Sort, query.Desc = models.SortCreatedAt, true
+	}
+	switch query.Sort {
+	case models.SortCreatedAt, models.SortCountry, models.SortCity, models.SortNetwork:
+	default:
+		return models.LocationQuery{}, fmt.Errorf("%w: unknown sort field %q", ErrInvalidFilter, query.Sort)
 	}
 
-	s.log.Debug("Ответ от API получен", "body", string(body))
+	if query.Network != "" {
+		prefix, err := netip.ParsePrefix(query.Network)
+		if err != nil {
+			return models.LocationQuery{}, fmt.Errorf("%w: %v", ErrInvalidFilter, err)
+		}
+		query.Network = prefix.Masked().String()
+	}
 
-	if resp.StatusCode != http.StatusOK {
-		s.log.Error("Некорректный ответ от API", "status", resp.Status)
-		return models.IPLocation{}, errors.New("некорректный ответ от API: " + resp.Status)
+	if query.Limit <= 0 {
+		query.Limit = DefaultPageSize
 	}
+	query.Limit = min(query.Limit, MaxPageSize)
+	return query, nil
+}
 
-	var location models.IPLocation
-	if err := json.Unmarshal(body, &location); err != nil {
-		s.log.Error("Ошибка при разборе ответа API", "error", err)
+func (s *LocService) FetchFromAPI(ctx context.Context, ip string) (models.IPLocation, error) {
+	s.log.Debug("Запрос локации у провайдеров", "ip", ip)
+	location, err := s.provider.Lookup(ctx, ip)
+	if err != nil {
+		s.log.Error("Ошибка при запросе к провайдерам", "ip", ip, "error", err)
 		return models.IPLocation{}, err
 	}
 
-	s.log.Debug("Локация получена с API", "ip", location.IP, "country", location.Country, "city", location.City)
+	s.log.Debug("Локация получена от провайдера", "ip", location.IP, "country", location.Country, "city", location.City, "provider", location.Provider)
 	return location, nil
 }
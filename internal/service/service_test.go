@@ -0,0 +1,425 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Fyefhqdishka/LocFinder/internal/config"
+	"github.com/Fyefhqdishka/LocFinder/internal/ipaddr"
+	"github.com/Fyefhqdishka/LocFinder/internal/models"
+	"github.com/Fyefhqdishka/LocFinder/internal/service"
+	"github.com/Fyefhqdishka/LocFinder/internal/storage/repositories"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Мок хранилища
+type MockStorage struct {
+	mock.Mock
+}
+
+func (m *MockStorage) GetByIP(ctx context.Context, ip string) (models.IPLocation, error) {
+	args := m.Called(ip)
+	return args.Get(0).(models.IPLocation), args.Error(1)
+}
+
+func (m *MockStorage) GetByIPs(ctx context.Context, ips []string) ([]models.IPLocation, error) {
+	args := m.Called(ips)
+	return args.Get(0).([]models.IPLocation), args.Error(1)
+}
+
+func (m *MockStorage) Save(ctx context.Context, location models.IPLocation) error {
+	args := m.Called(location)
+	return args.Error(0)
+}
+
+func (m *MockStorage) Create(ctx context.Context, location models.IPLocation) error {
+	args := m.Called(location)
+	return args.Error(0)
+}
+
+func (m *MockStorage) Replace(ctx context.Context, location models.IPLocation) (bool, error) {
+	args := m.Called(location)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockStorage) Update(ctx context.Context, location models.IPLocation) error {
+	args := m.Called(location)
+	return args.Error(0)
+}
+
+func (m *MockStorage) Refresh(ctx context.Context, location models.IPLocation) error {
+	args := m.Called(location)
+	return args.Error(0)
+}
+
+func (m *MockStorage) GetStale(ctx context.Context, before time.Time, limit int) ([]models.IPLocation, error) {
+	args := m.Called(before, limit)
+	return args.Get(0).([]models.IPLocation), args.Error(1)
+}
+
+func (m *MockStorage) Delete(ctx context.Context, ip string) error {
+	args := m.Called(ip)
+	return args.Error(0)
+}
+
+func (m *MockStorage) DeleteNetwork(ctx context.Context, network string) error {
+	args := m.Called(network)
+	return args.Error(0)
+}
+
+func (m *MockStorage) GetAll(ctx context.Context, query models.LocationQuery) (models.LocationPage, error) {
+	args := m.Called(query)
+	return args.Get(0).(models.LocationPage), args.Error(1)
+}
+
+func (m *MockStorage) Summarize(ctx context.Context, staleBefore time.Time, topCountries int) (models.LocationSummary, error) {
+	args := m.Called(staleBefore, topCountries)
+	return args.Get(0).(models.LocationSummary), args.Error(1)
+}
+
+func newTestService(t *testing.T, repo *MockStorage, handler http.HandlerFunc) *service.LocService {
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	s, err := service.NewLocService(repo, nil, &config.Config{
+		Geo: config.Geo{
+			Providers:    []string{service.ProviderIPAPI},
+			BatchWorkers: 2,
+			IPAPI:        config.IPAPI{BaseURL: srv.URL},
+		},
+		Refresh: config.Refresh{TTL: time.Hour},
+		Network: config.Network{IPv4Prefix: 24, IPv6Prefix: 48},
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestGetLocationByIPSpecialRange(t *testing.T) {
+	repo := new(MockStorage)
+	s := newTestService(t, repo, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("provider must not be called, got %s", r.URL)
+	})
+
+	location, err := s.GetLocationByIP(context.Background(), "192.168.1.10")
+	assert.NoError(t, err)
+	assert.Equal(t, ipaddr.TypePrivate, location.Type)
+
+	repo.AssertNotCalled(t, "GetByIP", mock.Anything)
+	repo.AssertNotCalled(t, "Save", mock.Anything)
+}
+
+func TestLookupLocations(t *testing.T) {
+	repo := new(MockStorage)
+	s := newTestService(t, repo, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/batch", r.URL.Path)
+
+		var ips []string
+		if err := json.NewDecoder(r.Body).Decode(&ips); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, []string{"8.8.8.8", "1.2.3.4", "9.9.9.9"}, ips)
+
+		w.Write([]byte(`[{"status":"success","query":"8.8.8.8","country":"United States","city":"Ashburn"},` +
+			`{"status":"fail","message":"reserved range","query":"1.2.3.4"},` +
+			`{"status":"fail","message":"upstream key 42 rejected","query":"9.9.9.9"}]`))
+	})
+
+	repo.On("GetByIPs", []string{"37.99.42.212", "8.8.8.8", "1.2.3.4", "9.9.9.9"}).Return([]models.IPLocation{
+		{IP: "37.99.42.212", Country: "Kazakhstan", City: "Almaty"},
+	}, nil)
+	repo.On("Save", mock.MatchedBy(func(l models.IPLocation) bool { return l.IP == "8.8.8.8" && l.Network == "8.8.8.0/24" })).Return(nil)
+
+	results, err := s.LookupLocations(context.Background(), []string{"37.99.42.212", "8.8.8.8", "10.0.0.1", "foo", "1.2.3.4", "::ffff:8.8.8.8", "9.9.9.9"})
+	assert.NoError(t, err)
+	assert.Len(t, results, 7)
+
+	assert.Equal(t, "Almaty", results[0].Location.City)
+	assert.Equal(t, "Ashburn", results[1].Location.City)
+	assert.Equal(t, service.ProviderIPAPI, results[1].Location.Provider)
+	assert.Equal(t, ipaddr.TypePrivate, results[2].Location.Type)
+	assert.Equal(t, service.CodeInvalidIP, results[3].Code)
+	assert.NotEmpty(t, results[3].Error)
+	assert.Nil(t, results[4].Location)
+	assert.Equal(t, service.CodeReservedRange, results[4].Code)
+	assert.Equal(t, service.ErrReservedRange.Error(), results[4].Error)
+	assert.Equal(t, "8.8.8.8", results[5].IP)
+	assert.Equal(t, "Ashburn", results[5].Location.City)
+	assert.Empty(t, results[5].Code)
+	assert.Nil(t, results[6].Location)
+	assert.Equal(t, service.CodeInternal, results[6].Code)
+	assert.NotContains(t, results[6].Error, "upstream key")
+
+	repo.AssertExpectations(t)
+}
+
+func TestGetLocationByIPRefreshesExpired(t *testing.T) {
+	repo := new(MockStorage)
+	s := newTestService(t, repo, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"success","query":"37.99.42.212","country":"Kazakhstan","city":"Astana"}`))
+	})
+
+	fetchedAt := time.Now().Add(-2 * time.Hour)
+	repo.On("GetByIP", "37.99.42.212").Return(models.IPLocation{
+		IP: "37.99.42.212", Country: "Kazakhstan", City: "Almaty", FetchedAt: &fetchedAt,
+	}, nil)
+	repo.On("Refresh", mock.MatchedBy(func(l models.IPLocation) bool { return l.City == "Astana" })).Return(nil)
+
+	location, err := s.GetLocationByIP(context.Background(), "37.99.42.212")
+	assert.NoError(t, err)
+	assert.Equal(t, "Astana", location.City)
+
+	repo.AssertExpectations(t)
+}
+
+func TestLookupLocationsRefreshesStaleInBackground(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Write([]byte(`{"status":"success","query":"37.99.42.212","country":"Kazakhstan","city":"Astana"}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	repo := new(MockStorage)
+	s, err := service.NewLocService(repo, nil, &config.Config{
+		Geo: config.Geo{
+			Providers:    []string{service.ProviderIPAPI},
+			BatchWorkers: 2,
+			IPAPI:        config.IPAPI{BaseURL: srv.URL},
+		},
+		Refresh: config.Refresh{TTL: time.Hour, ServeStale: true},
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fetchedAt := time.Now().Add(-2 * time.Hour)
+	repo.On("GetByIPs", []string{"37.99.42.212", "37.99.42.1"}).Return([]models.IPLocation{
+		{IP: "37.99.42.212", Network: "37.99.42.0/24", City: "Almaty", FetchedAt: &fetchedAt},
+		{IP: "37.99.42.1", Network: "37.99.42.0/24", City: "Almaty", FetchedAt: &fetchedAt},
+	}, nil)
+	refreshed := make(chan models.IPLocation, 2)
+	repo.On("Refresh", mock.Anything).Run(func(args mock.Arguments) {
+		refreshed <- args.Get(0).(models.IPLocation)
+	}).Return(nil)
+
+	results, err := s.LookupLocations(context.Background(), []string{"37.99.42.212", "37.99.42.1"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Almaty", results[0].Location.City)
+	assert.Equal(t, "Almaty", results[1].Location.City)
+
+	select {
+	case location := <-refreshed:
+		assert.Equal(t, "Astana", location.City)
+		assert.Equal(t, "37.99.42.0/24", location.Network)
+	case <-time.After(time.Second):
+		t.Fatal("the stale location was not refreshed")
+	}
+
+	// the network is refreshed once for both IPs
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, refreshed)
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestRefreshStale(t *testing.T) {
+	repo := new(MockStorage)
+	s := newTestService(t, repo, func(w http.ResponseWriter, r *http.Request) {
+		var ips []string
+		if err := json.NewDecoder(r.Body).Decode(&ips); err != nil {
+			t.Fatal(err)
+		}
+		// the network stands for the IPs looked up in it
+		assert.Equal(t, []string{"37.99.42.0"}, ips)
+
+		w.Write([]byte(`[{"status":"success","query":"37.99.42.0","country":"Kazakhstan","city":"Astana"}]`))
+	})
+
+	repo.On("GetStale", mock.Anything, 10).Return([]models.IPLocation{{IP: "37.99.42.0", Network: "37.99.42.0/24", City: "Almaty"}}, nil)
+	repo.On("Refresh", mock.MatchedBy(func(l models.IPLocation) bool {
+		return l.City == "Astana" && l.IP == "37.99.42.0" && l.Network == "37.99.42.0/24"
+	})).Return(nil)
+
+	refreshed, err := s.RefreshStale(context.Background(), 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, refreshed)
+
+	repo.AssertExpectations(t)
+}
+
+func TestGetLocationByIPCoalescesConcurrentLookups(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+
+	repo := new(MockStorage)
+	s := newTestService(t, repo, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+		w.Write([]byte(`{"status":"success","query":"37.99.42.212","country":"Kazakhstan","city":"Almaty"}`))
+	})
+
+	repo.On("GetByIP", "37.99.42.212").Return(models.IPLocation{}, service.ErrNotFound)
+	repo.On("Save", mock.Anything).Return(nil).Once()
+
+	const requests = 50
+	var started, done sync.WaitGroup
+	started.Add(requests)
+	done.Add(requests)
+	for range requests {
+		go func() {
+			defer done.Done()
+			started.Done()
+			location, err := s.GetLocationByIP(context.Background(), "37.99.42.212")
+			assert.NoError(t, err)
+			assert.Equal(t, "Almaty", location.City)
+		}()
+	}
+
+	started.Wait()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	done.Wait()
+
+	assert.Equal(t, int32(1), calls.Load())
+	repo.AssertExpectations(t)
+}
+
+func TestGetAllLocationsDefaults(t *testing.T) {
+	repo := new(MockStorage)
+	s := newTestService(t, repo, func(w http.ResponseWriter, r *http.Request) {})
+
+	repo.On("GetAll", models.LocationQuery{
+		Network: "37.99.0.0/16",
+		Sort:    models.SortCreatedAt,
+		Desc:    true,
+		Limit:   service.DefaultPageSize,
+	}).Return(models.LocationPage{Total: 0}, nil)
+
+	_, err := s.GetAllLocations(context.Background(), models.LocationQuery{Network: "37.99.42.1/16"})
+	assert.NoError(t, err)
+
+	_, err = s.GetAllLocations(context.Background(), models.LocationQuery{Network: "37.99.42.1"})
+	assert.ErrorIs(t, err, service.ErrInvalidFilter)
+
+	repo.AssertExpectations(t)
+}
+
+func TestExportLocationsFollowsCursor(t *testing.T) {
+	repo := new(MockStorage)
+	s := newTestService(t, repo, func(w http.ResponseWriter, r *http.Request) {})
+
+	query := models.LocationQuery{Country: "Kazakhstan", Sort: models.SortNetwork, Limit: service.MaxPageSize, SkipTotal: true}
+	repo.On("GetAll", query).Return(models.LocationPage{
+		Locations:  []models.IPLocation{{Network: "37.99.0.0/16"}, {Network: "37.99.42.0/24"}},
+		NextCursor: "next",
+	}, nil).Once()
+	query.Cursor = "next"
+	repo.On("GetAll", query).Return(models.LocationPage{
+		Locations: []models.IPLocation{{Network: "95.56.0.0/16"}},
+	}, nil).Once()
+
+	var exported []string
+	err := s.ExportLocations(context.Background(), models.LocationQuery{Country: "Kazakhstan", Sort: models.SortNetwork, Limit: 5}, func(l models.IPLocation) error {
+		exported = append(exported, l.Network)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"37.99.0.0/16", "37.99.42.0/24", "95.56.0.0/16"}, exported)
+
+	err = s.ExportLocations(context.Background(), models.LocationQuery{Sort: "zip"}, func(models.IPLocation) error { return nil })
+	assert.ErrorIs(t, err, service.ErrInvalidFilter)
+
+	repo.AssertExpectations(t)
+}
+
+func TestCreateLocation(t *testing.T) {
+	repo := new(MockStorage)
+	s := newTestService(t, repo, func(w http.ResponseWriter, r *http.Request) {})
+
+	repo.On("Create", mock.MatchedBy(func(l models.IPLocation) bool {
+		return l.IP == "37.99.0.0" && l.Network == "37.99.0.0/16" && l.Provider == service.ProviderManual
+	})).Return(nil)
+
+	location, err := s.CreateLocation(context.Background(), models.IPLocation{Network: "37.99.42.1/16", City: "Almaty"})
+	assert.NoError(t, err)
+	assert.Equal(t, "37.99.0.0/16", location.Network)
+
+	_, err = s.CreateLocation(context.Background(), models.IPLocation{IP: "8.8.8.8", Network: "37.99.0.0/16"})
+	assert.ErrorIs(t, err, service.ErrInvalidLocation)
+
+	_, err = s.CreateLocation(context.Background(), models.IPLocation{IP: "10.0.0.1"})
+	assert.ErrorIs(t, err, service.ErrReservedRange)
+
+	repo.AssertExpectations(t)
+}
+
+func TestReplaceLocationUsesHostNetwork(t *testing.T) {
+	repo := new(MockStorage)
+	s := newTestService(t, repo, func(w http.ResponseWriter, r *http.Request) {})
+
+	repo.On("Replace", mock.MatchedBy(func(l models.IPLocation) bool {
+		return l.IP == "2a00:1450::1" && l.Network == "2a00:1450::1/128" && l.FetchedAt == nil
+	})).Return(false, nil)
+
+	now := time.Now()
+	_, created, err := s.ReplaceLocation(context.Background(), "2a00:1450::1", models.IPLocation{IP: "8.8.8.8", City: "Berlin", FetchedAt: &now})
+	assert.NoError(t, err)
+	assert.False(t, created)
+
+	repo.AssertExpectations(t)
+}
+
+func TestPatchLocation(t *testing.T) {
+	repo := new(MockStorage)
+	s := newTestService(t, repo, func(w http.ResponseWriter, r *http.Request) {})
+
+	fetchedAt := time.Now()
+	repo.On("GetByIP", "37.99.42.212").Return(models.IPLocation{
+		IP: "37.99.42.212", Network: "37.99.42.0/24", Country: "Kazakhstan", City: "Almaty", Provider: service.ProviderIPAPI, FetchedAt: &fetchedAt,
+	}, nil).Once()
+	repo.On("Update", models.IPLocation{
+		IP: "37.99.42.212", Network: "37.99.42.0/24", Country: "Kazakhstan", City: "Astana", Provider: service.ProviderManual,
+	}).Return(nil)
+	repo.On("GetByIP", "8.8.8.8").Return(models.IPLocation{}, service.ErrNotFound)
+
+	city := "Astana"
+	location, err := s.PatchLocation(context.Background(), "37.99.42.212", models.LocationPatch{City: &city})
+	assert.NoError(t, err)
+	assert.Equal(t, "Astana", location.City)
+
+	_, err = s.PatchLocation(context.Background(), "8.8.8.8", models.LocationPatch{City: &city})
+	assert.ErrorIs(t, err, service.ErrNotFound)
+
+	repo.AssertExpectations(t)
+}
+
+func TestHealthReportsCacheStats(t *testing.T) {
+	repo := new(MockStorage)
+	s, err := service.NewLocService(repositories.NewCachedRepository(repo, 10, time.Minute), nil, &config.Config{
+		Geo: config.Geo{Providers: []string{service.ProviderIPAPI}, BatchWorkers: 2},
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo.On("GetByIP", "37.99.42.212").Return(models.IPLocation{IP: "37.99.42.212", City: "Almaty"}, nil).Once()
+	for range 2 {
+		_, err := s.GetLocationByIP(context.Background(), "37.99.42.212")
+		assert.NoError(t, err)
+	}
+
+	health := s.Health(context.Background())
+	assert.Equal(t, &models.CacheStats{Size: 1, Hits: 1, Misses: 1}, health.Cache)
+
+	uncached := newTestService(t, new(MockStorage), nil)
+	assert.Nil(t, uncached.Health(context.Background()).Cache)
+}
@@ -0,0 +1,261 @@
+package repositories
+
+import (
+	"context"
+	"github.com/Fyefhqdishka/LocFinder/internal/models"
+	"github.com/Fyefhqdishka/LocFinder/internal/storage/repositoryInterfaces"
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CachedRepository keeps recently read locations in memory in front of another storage.
+// Entries are evicted by size (least recently used first) and by TTL, and invalidated
+// after every write, so the next read goes to the underlying storage. A stored network
+// answers for many IPs, so a write invalidates every cached IP it may affect
+type CachedRepository struct {
+	repositoryInterfaces.Storage
+	cache  *expirable.LRU[string, models.IPLocation]
+	hits   atomic.Uint64
+	misses atomic.Uint64
+
+	// mu orders fills against invalidations, a read that started before an invalidation
+	// may return the old data and is not cached
+	mu         sync.Mutex
+	generation uint64
+
+	// byNetwork indexes cached IPs by the network serving them and networks holds the network
+	// of each indexed IP. They are kept in sync by the eviction callback and guarded by indexMu,
+	// which is never held while calling the cache
+	indexMu   sync.Mutex
+	byNetwork map[netip.Prefix]map[string]struct{}
+	networks  map[string]netip.Prefix
+}
+
+func NewCachedRepository(next repositoryInterfaces.Storage, size int, ttl time.Duration) *CachedRepository {
+	r := &CachedRepository{
+		Storage:   next,
+		byNetwork: make(map[netip.Prefix]map[string]struct{}),
+		networks:  make(map[string]netip.Prefix),
+	}
+	r.cache = expirable.NewLRU[string, models.IPLocation](size, r.unindex, ttl)
+	return r
+}
+
+func (r *CachedRepository) GetByIP(ctx context.Context, ip string) (models.IPLocation, error) {
+	if location, ok := r.cache.Get(ip); ok {
+		r.hits.Add(1)
+		return location, nil
+	}
+	r.misses.Add(1)
+
+	generation := r.currentGeneration()
+	location, err := r.Storage.GetByIP(ctx, ip)
+	if err != nil {
+		return models.IPLocation{}, err
+	}
+
+	r.fill(generation, location)
+	return location, nil
+}
+
+func (r *CachedRepository) GetByIPs(ctx context.Context, ips []string) ([]models.IPLocation, error) {
+	var locations []models.IPLocation
+	var missing []string
+	for _, ip := range ips {
+		if location, ok := r.cache.Get(ip); ok {
+			locations = append(locations, location)
+			continue
+		}
+		missing = append(missing, ip)
+	}
+	r.hits.Add(uint64(len(locations)))
+	r.misses.Add(uint64(len(missing)))
+
+	if len(missing) == 0 {
+		return locations, nil
+	}
+
+	generation := r.currentGeneration()
+	stored, err := r.Storage.GetByIPs(ctx, missing)
+	if err != nil {
+		return nil, err
+	}
+
+	r.fill(generation, stored...)
+	return append(locations, stored...), nil
+}
+
+func (r *CachedRepository) currentGeneration() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.generation
+}
+
+// fill caches locations read at the given generation unless an invalidation happened since
+func (r *CachedRepository) fill(generation uint64, locations ...models.IPLocation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.generation != generation {
+		return
+	}
+	for _, location := range locations {
+		r.cache.Add(location.IP, location)
+		r.index(location)
+	}
+}
+
+// index records the network serving a cached IP, replacing a cached value doesn't call
+// the eviction callback so the IP is moved from the network of the old value
+func (r *CachedRepository) index(location models.IPLocation) {
+	r.indexMu.Lock()
+	defer r.indexMu.Unlock()
+
+	r.removeIndexed(location.IP)
+	network, err := netip.ParsePrefix(location.Network)
+	if err != nil {
+		return
+	}
+
+	r.networks[location.IP] = network
+	ips, ok := r.byNetwork[network]
+	if !ok {
+		ips = make(map[string]struct{})
+		r.byNetwork[network] = ips
+	}
+	ips[location.IP] = struct{}{}
+}
+
+// unindex is the eviction callback of the cache
+func (r *CachedRepository) unindex(ip string, _ models.IPLocation) {
+	r.indexMu.Lock()
+	defer r.indexMu.Unlock()
+	r.removeIndexed(ip)
+}
+
+func (r *CachedRepository) removeIndexed(ip string) {
+	network, ok := r.networks[ip]
+	if !ok {
+		return
+	}
+
+	delete(r.networks, ip)
+	delete(r.byNetwork[network], ip)
+	if len(r.byNetwork[network]) == 0 {
+		delete(r.byNetwork, network)
+	}
+}
+
+func (r *CachedRepository) Save(ctx context.Context, location models.IPLocation) error {
+	err := r.Storage.Save(ctx, location)
+	r.invalidateNetwork(location)
+	return err
+}
+
+func (r *CachedRepository) Create(ctx context.Context, location models.IPLocation) error {
+	err := r.Storage.Create(ctx, location)
+	r.invalidateNetwork(location)
+	return err
+}
+
+func (r *CachedRepository) Replace(ctx context.Context, location models.IPLocation) (bool, error) {
+	created, err := r.Storage.Replace(ctx, location)
+	r.invalidateNetwork(location)
+	return created, err
+}
+
+func (r *CachedRepository) Update(ctx context.Context, location models.IPLocation) error {
+	err := r.Storage.Update(ctx, location)
+	r.invalidateIP(location.IP)
+	return err
+}
+
+func (r *CachedRepository) Refresh(ctx context.Context, location models.IPLocation) error {
+	err := r.Storage.Refresh(ctx, location)
+	r.invalidateIP(location.IP)
+	return err
+}
+
+func (r *CachedRepository) Delete(ctx context.Context, ip string) error {
+	err := r.Storage.Delete(ctx, ip)
+	r.invalidateIP(ip)
+	return err
+}
+
+func (r *CachedRepository) DeleteNetwork(ctx context.Context, network string) error {
+	err := r.Storage.DeleteNetwork(ctx, network)
+	r.invalidateNetwork(models.IPLocation{Network: network})
+	return err
+}
+
+// invalidateNetwork removes cached IPs within the network a location is stored for. Only IPs
+// served by the network or one containing it may now be served differently, IPs served by a
+// more specific network keep it
+func (r *CachedRepository) invalidateNetwork(location models.IPLocation) {
+	network, err := netip.ParsePrefix(location.Network)
+	if err != nil {
+		r.invalidate(func() []string { return []string{location.IP} })
+		return
+	}
+	network = network.Masked()
+
+	r.invalidate(func() []string {
+		var stale []string
+		for bits := network.Bits(); bits >= 0; bits-- {
+			serving, _ := network.Addr().Prefix(bits)
+			for ip := range r.byNetwork[serving] {
+				if addr, err := netip.ParseAddr(ip); err == nil && network.Contains(addr) {
+					stale = append(stale, ip)
+				}
+			}
+		}
+		return stale
+	})
+}
+
+// invalidateIP removes cached IPs served by a network containing ip,
+// the network changed by a write keyed by ip is one of them
+func (r *CachedRepository) invalidateIP(ip string) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		r.invalidate(func() []string { return []string{ip} })
+		return
+	}
+
+	r.invalidate(func() []string {
+		stale := []string{ip}
+		for bits := addr.BitLen(); bits >= 0; bits-- {
+			serving, _ := addr.Prefix(bits)
+			for cached := range r.byNetwork[serving] {
+				stale = append(stale, cached)
+			}
+		}
+		return stale
+	})
+}
+
+// invalidate starts a new generation and removes the IPs collected from the index
+func (r *CachedRepository) invalidate(collect func() []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.generation++
+
+	r.indexMu.Lock()
+	stale := collect()
+	r.indexMu.Unlock()
+
+	for _, ip := range stale {
+		r.cache.Remove(ip)
+	}
+}
+
+func (r *CachedRepository) Stats() models.CacheStats {
+	return models.CacheStats{
+		Size:   r.cache.Len(),
+		Hits:   r.hits.Load(),
+		Misses: r.misses.Load(),
+	}
+}
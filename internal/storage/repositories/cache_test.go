@@ -0,0 +1,212 @@
+package repositories_test
+
+import (
+	"context"
+	"github.com/Fyefhqdishka/LocFinder/internal/models"
+	"github.com/Fyefhqdishka/LocFinder/internal/storage/repositories"
+	"github.com/Fyefhqdishka/LocFinder/internal/storage/repositoryInterfaces"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"testing"
+	"time"
+)
+
+// Мок хранилища
+type MockStorage struct {
+	mock.Mock
+}
+
+func (m *MockStorage) GetByIP(ctx context.Context, ip string) (models.IPLocation, error) {
+	args := m.Called(ip)
+	return args.Get(0).(models.IPLocation), args.Error(1)
+}
+
+func (m *MockStorage) GetByIPs(ctx context.Context, ips []string) ([]models.IPLocation, error) {
+	args := m.Called(ips)
+	return args.Get(0).([]models.IPLocation), args.Error(1)
+}
+
+func (m *MockStorage) Save(ctx context.Context, location models.IPLocation) error {
+	return m.Called(location).Error(0)
+}
+
+func (m *MockStorage) Create(ctx context.Context, location models.IPLocation) error {
+	args := m.Called(location)
+	return args.Error(0)
+}
+
+func (m *MockStorage) Replace(ctx context.Context, location models.IPLocation) (bool, error) {
+	args := m.Called(location)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockStorage) Update(ctx context.Context, location models.IPLocation) error {
+	return m.Called(location).Error(0)
+}
+
+func (m *MockStorage) Refresh(ctx context.Context, location models.IPLocation) error {
+	return m.Called(location).Error(0)
+}
+
+func (m *MockStorage) GetStale(ctx context.Context, before time.Time, limit int) ([]models.IPLocation, error) {
+	args := m.Called(before, limit)
+	return args.Get(0).([]models.IPLocation), args.Error(1)
+}
+
+func (m *MockStorage) Delete(ctx context.Context, ip string) error {
+	return m.Called(ip).Error(0)
+}
+
+func (m *MockStorage) DeleteNetwork(ctx context.Context, network string) error {
+	return m.Called(network).Error(0)
+}
+
+func (m *MockStorage) GetAll(ctx context.Context, query models.LocationQuery) (models.LocationPage, error) {
+	args := m.Called(query)
+	return args.Get(0).(models.LocationPage), args.Error(1)
+}
+
+func (m *MockStorage) Summarize(ctx context.Context, staleBefore time.Time, topCountries int) (models.LocationSummary, error) {
+	args := m.Called(staleBefore, topCountries)
+	return args.Get(0).(models.LocationSummary), args.Error(1)
+}
+
+func TestCachedRepositoryGetByIP(t *testing.T) {
+	next := new(MockStorage)
+	repo := repositories.NewCachedRepository(next, 10, time.Minute)
+
+	location := models.IPLocation{IP: "37.99.42.212", Country: "Kazakhstan", City: "Almaty"}
+	next.On("GetByIP", "37.99.42.212").Return(location, nil).Once()
+	next.On("GetByIP", "8.8.8.8").Return(models.IPLocation{}, repositoryInterfaces.ErrNotFound).Twice()
+
+	for range 3 {
+		got, err := repo.GetByIP(context.Background(), "37.99.42.212")
+		assert.NoError(t, err)
+		assert.Equal(t, location, got)
+	}
+
+	for range 2 {
+		_, err := repo.GetByIP(context.Background(), "8.8.8.8")
+		assert.ErrorIs(t, err, repositoryInterfaces.ErrNotFound)
+	}
+
+	assert.Equal(t, models.CacheStats{Size: 1, Hits: 2, Misses: 3}, repo.Stats())
+	next.AssertExpectations(t)
+}
+
+func TestCachedRepositoryInvalidation(t *testing.T) {
+	next := new(MockStorage)
+	repo := repositories.NewCachedRepository(next, 10, time.Minute)
+
+	location := models.IPLocation{IP: "37.99.42.212", Country: "Kazakhstan", City: "Almaty"}
+	updated := models.IPLocation{IP: "37.99.42.212", Country: "Kazakhstan", City: "Astana"}
+
+	next.On("GetByIP", "37.99.42.212").Return(location, nil).Once()
+	next.On("Update", updated).Return(nil)
+	next.On("GetByIP", "37.99.42.212").Return(updated, nil).Once()
+	next.On("Delete", "37.99.42.212").Return(nil)
+	next.On("GetByIP", "37.99.42.212").Return(models.IPLocation{}, repositoryInterfaces.ErrNotFound).Once()
+
+	got, _ := repo.GetByIP(context.Background(), "37.99.42.212")
+	assert.Equal(t, "Almaty", got.City)
+
+	assert.NoError(t, repo.Update(context.Background(), updated))
+	got, _ = repo.GetByIP(context.Background(), "37.99.42.212")
+	assert.Equal(t, "Astana", got.City)
+
+	assert.NoError(t, repo.Delete(context.Background(), "37.99.42.212"))
+	_, err := repo.GetByIP(context.Background(), "37.99.42.212")
+	assert.ErrorIs(t, err, repositoryInterfaces.ErrNotFound)
+
+	next.AssertExpectations(t)
+}
+
+func TestCachedRepositoryGetByIPs(t *testing.T) {
+	next := new(MockStorage)
+	repo := repositories.NewCachedRepository(next, 10, time.Minute)
+
+	cached := models.IPLocation{IP: "37.99.42.212", City: "Almaty"}
+	stored := models.IPLocation{IP: "8.8.8.8", City: "Ashburn"}
+
+	next.On("GetByIP", "37.99.42.212").Return(cached, nil).Once()
+	next.On("GetByIPs", []string{"8.8.8.8", "1.1.1.1"}).Return([]models.IPLocation{stored}, nil).Once()
+
+	_, err := repo.GetByIP(context.Background(), "37.99.42.212")
+	assert.NoError(t, err)
+
+	locations, err := repo.GetByIPs(context.Background(), []string{"37.99.42.212", "8.8.8.8", "1.1.1.1"})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []models.IPLocation{cached, stored}, locations)
+
+	next.AssertExpectations(t)
+}
+
+func TestCachedRepositoryNetworkInvalidation(t *testing.T) {
+	next := new(MockStorage)
+	repo := repositories.NewCachedRepository(next, 10, time.Minute)
+
+	networks := map[string]string{"37.99.42.1": "37.99.42.0/24", "37.99.42.2": "37.99.42.0/24", "8.8.8.8": "8.8.0.0/16"}
+	for ip, network := range networks {
+		next.On("GetByIP", ip).Return(models.IPLocation{IP: ip, Network: network}, nil).Once()
+		_, err := repo.GetByIP(context.Background(), ip)
+		assert.NoError(t, err)
+	}
+
+	// a write by one IP affects every IP served by the same network
+	next.On("Delete", "37.99.42.1").Return(nil)
+	assert.NoError(t, repo.Delete(context.Background(), "37.99.42.1"))
+	assert.Equal(t, 1, repo.Stats().Size)
+
+	saved := models.IPLocation{IP: "8.8.8.8", Network: "8.8.8.0/24", City: "Ashburn"}
+	next.On("Save", saved).Return(nil)
+	assert.NoError(t, repo.Save(context.Background(), saved))
+	assert.Equal(t, 0, repo.Stats().Size)
+
+	next.AssertExpectations(t)
+}
+
+func TestCachedRepositoryKeepsMoreSpecificNetworks(t *testing.T) {
+	next := new(MockStorage)
+	repo := repositories.NewCachedRepository(next, 10, time.Minute)
+
+	networks := map[string]string{"37.99.42.1": "37.99.42.0/24", "37.99.43.1": "37.99.0.0/16", "37.99.44.1": "37.99.0.0/16"}
+	for ip, network := range networks {
+		next.On("GetByIP", ip).Return(models.IPLocation{IP: ip, Network: network}, nil).Once()
+		_, err := repo.GetByIP(context.Background(), ip)
+		assert.NoError(t, err)
+	}
+
+	// the new network takes over IPs of the enclosing one, the more specific network keeps its IPs
+	saved := models.IPLocation{Network: "37.99.40.0/22", City: "Almaty"}
+	next.On("Save", saved).Return(nil)
+	assert.NoError(t, repo.Save(context.Background(), saved))
+	assert.Equal(t, 2, repo.Stats().Size)
+
+	_, err := repo.GetByIP(context.Background(), "37.99.42.1")
+	assert.NoError(t, err)
+	_, err = repo.GetByIP(context.Background(), "37.99.44.1")
+	assert.NoError(t, err)
+
+	next.AssertExpectations(t)
+}
+
+func TestCachedRepositoryDropsReadsRacingInvalidation(t *testing.T) {
+	next := new(MockStorage)
+	repo := repositories.NewCachedRepository(next, 10, time.Minute)
+
+	// the location is deleted while its old copy is being read
+	next.On("Delete", "8.8.8.8").Return(nil)
+	next.On("GetByIP", "8.8.8.8").Run(func(mock.Arguments) {
+		assert.NoError(t, repo.Delete(context.Background(), "8.8.8.8"))
+	}).Return(models.IPLocation{IP: "8.8.8.8", Network: "8.8.8.0/24"}, nil).Once()
+
+	_, err := repo.GetByIP(context.Background(), "8.8.8.8")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, repo.Stats().Size)
+
+	next.On("GetByIP", "8.8.8.8").Return(models.IPLocation{}, repositoryInterfaces.ErrNotFound).Once()
+	_, err = repo.GetByIP(context.Background(), "8.8.8.8")
+	assert.ErrorIs(t, err, repositoryInterfaces.ErrNotFound)
+
+	next.AssertExpectations(t)
+}
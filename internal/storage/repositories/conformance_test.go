@@ -0,0 +1,59 @@
+package repositories_test
+
+import (
+	"context"
+	"database/sql"
+	"github.com/Fyefhqdishka/LocFinder/internal/config"
+	"github.com/Fyefhqdishka/LocFinder/internal/storage"
+	"github.com/Fyefhqdishka/LocFinder/internal/storage/repositories"
+	"github.com/Fyefhqdishka/LocFinder/internal/storage/repositoryInterfaces"
+	"github.com/Fyefhqdishka/LocFinder/internal/storage/storagetest"
+	_ "github.com/lib/pq"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMemoryRepositoryConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) repositoryInterfaces.Storage {
+		return repositories.NewMemoryRepository()
+	})
+}
+
+func TestSQLiteRepositoryConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) repositoryInterfaces.Storage {
+		return repositories.NewSQLiteRepository(openSQLite(t))
+	})
+}
+
+func TestCachedRepositoryConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) repositoryInterfaces.Storage {
+		return repositories.NewCachedRepository(repositories.NewMemoryRepository(), 100, time.Minute)
+	})
+}
+
+// TestLocRepositoryConformance runs against the Postgres database of LOCFINDER_TEST_POSTGRES_DSN,
+// every test truncates the locations table
+func TestLocRepositoryConformance(t *testing.T) {
+	dsn := os.Getenv("LOCFINDER_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("LOCFINDER_TEST_POSTGRES_DSN is not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := storage.Migrate(context.Background(), db, config.DBDriverPostgres); err != nil {
+		t.Fatal(err)
+	}
+
+	storagetest.Run(t, func(t *testing.T) repositoryInterfaces.Storage {
+		if _, err := db.Exec(`TRUNCATE locations`); err != nil {
+			t.Fatal(err)
+		}
+		return repositories.NewLocRepository(db, nil)
+	})
+}
@@ -0,0 +1,62 @@
+package repositories
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/Fyefhqdishka/LocFinder/internal/models"
+	"time"
+)
+
+// sortColumns maps sort fields to columns of the locations table
+var sortColumns = map[string]string{
+	models.SortCreatedAt: "created_at",
+	models.SortCountry:   "country",
+	models.SortCity:      "city",
+	models.SortNetwork:   "network",
+}
+
+// sortCasts convert the text value of a cursor to the column type
+var sortCasts = map[string]string{
+	models.SortCreatedAt: "::timestamptz",
+	models.SortNetwork:   "::cidr",
+}
+
+// cursor points at the last location of a page. It's only valid for the same sort
+type cursor struct {
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d,omitempty"`
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+func encodeCursor(c cursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(data, &c)
+	return c, err
+}
+
+// sortValue returns the value of the sort field of the location as text
+func sortValue(sort string, l models.IPLocation) string {
+	switch sort {
+	case models.SortCountry:
+		return l.Country
+	case models.SortCity:
+		return l.City
+	case models.SortNetwork:
+		return l.Network
+	default:
+		if l.CreatedAt == nil {
+			return ""
+		}
+		return l.CreatedAt.UTC().Format(time.RFC3339Nano)
+	}
+}
@@ -0,0 +1,459 @@
+package repositories
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"github.com/Fyefhqdishka/LocFinder/internal/ipaddr"
+	"github.com/Fyefhqdishka/LocFinder/internal/models"
+	"github.com/Fyefhqdishka/LocFinder/internal/storage/repositoryInterfaces"
+	"io"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryRepository keeps locations in process memory, they are lost on restart.
+// It's meant for local runs and tests without a database
+type MemoryRepository struct {
+	mu        sync.RWMutex
+	locations map[netip.Prefix]memoryLocation
+	lastID    int64
+}
+
+type memoryLocation struct {
+	id       int64
+	location models.IPLocation
+}
+
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{locations: make(map[netip.Prefix]memoryLocation)}
+}
+
+// containing returns the most specific stored network containing ip
+func (r *MemoryRepository) containing(ip string) (netip.Prefix, bool) {
+	addr, err := ipaddr.Parse(ip)
+	if err != nil {
+		return netip.Prefix{}, false
+	}
+
+	for bits := addr.BitLen(); bits >= 0; bits-- {
+		prefix, _ := addr.Prefix(bits)
+		if _, ok := r.locations[prefix]; ok {
+			return prefix, true
+		}
+	}
+	return netip.Prefix{}, false
+}
+
+func (r *MemoryRepository) GetByIP(ctx context.Context, ip string) (models.IPLocation, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	network, ok := r.containing(ip)
+	if !ok {
+		return models.IPLocation{}, repositoryInterfaces.ErrNotFound
+	}
+
+	location := r.locations[network].location
+	location.IP = ip
+	return location, nil
+}
+
+func (r *MemoryRepository) GetByIPs(ctx context.Context, ips []string) ([]models.IPLocation, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var locations []models.IPLocation
+	for _, ip := range ips {
+		if network, ok := r.containing(ip); ok {
+			location := r.locations[network].location
+			location.IP = ip
+			locations = append(locations, location)
+		}
+	}
+	return locations, nil
+}
+
+// put stores the location for network keeping the creation time and id of a stored one,
+// it reports whether the network was created
+func (r *MemoryRepository) put(network netip.Prefix, l models.IPLocation, fetched bool) bool {
+	now := time.Now().UTC()
+
+	stored, ok := r.locations[network]
+	if !ok {
+		r.lastID++
+		stored = memoryLocation{id: r.lastID}
+		stored.location.CreatedAt = &now
+	}
+
+	stored.location = locationData(stored.location, l)
+	stored.location.IP = network.Addr().String()
+	stored.location.Network = network.String()
+	stored.location.FetchedAt = nil
+	if fetched {
+		stored.location.FetchedAt = &now
+	}
+
+	r.locations[network] = stored
+	return !ok
+}
+
+// locationData returns stored with the data columns of l
+func locationData(stored, l models.IPLocation) models.IPLocation {
+	stored.Country, stored.Region, stored.City, stored.Zip = l.Country, l.Region, l.City, l.Zip
+	stored.Lat, stored.Lon, stored.Timezone = l.Lat, l.Lon, l.Timezone
+	stored.ISP, stored.Org, stored.AS, stored.Provider = l.ISP, l.Org, l.AS, l.Provider
+	return stored
+}
+
+func (r *MemoryRepository) Save(ctx context.Context, l models.IPLocation) error {
+	network, err := parseNetwork(l)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.put(network, l, true)
+	return nil
+}
+
+func (r *MemoryRepository) Create(ctx context.Context, l models.IPLocation) error {
+	network, err := parseNetwork(l)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.locations[network]; ok {
+		return fmt.Errorf("%w: %s", repositoryInterfaces.ErrConflict, network)
+	}
+	r.put(network, l, false)
+	return nil
+}
+
+func (r *MemoryRepository) Replace(ctx context.Context, l models.IPLocation) (bool, error) {
+	network, err := parseNetwork(l)
+	if err != nil {
+		return false, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.put(network, l, false), nil
+}
+
+func (r *MemoryRepository) Update(ctx context.Context, l models.IPLocation) error {
+	return r.change(l.IP, func(stored *models.IPLocation) {
+		*stored = locationData(*stored, l)
+		stored.FetchedAt = nil
+	})
+}
+
+func (r *MemoryRepository) Refresh(ctx context.Context, l models.IPLocation) error {
+	return r.change(l.IP, func(stored *models.IPLocation) {
+		now := time.Now().UTC()
+		*stored = locationData(*stored, l)
+		stored.FetchedAt = &now
+	})
+}
+
+// change applies fn to the most specific stored network containing ip
+func (r *MemoryRepository) change(ip string, fn func(stored *models.IPLocation)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	network, ok := r.containing(ip)
+	if !ok {
+		return repositoryInterfaces.ErrNotFound
+	}
+
+	stored := r.locations[network]
+	fn(&stored.location)
+	r.locations[network] = stored
+	return nil
+}
+
+func (r *MemoryRepository) GetStale(ctx context.Context, before time.Time, limit int) ([]models.IPLocation, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var stale []models.IPLocation
+	for _, stored := range r.locations {
+		if fetchedAt := stored.location.FetchedAt; fetchedAt != nil && fetchedAt.Before(before) {
+			stale = append(stale, stored.location)
+		}
+	}
+
+	slices.SortFunc(stale, func(a, b models.IPLocation) int {
+		return a.FetchedAt.Compare(*b.FetchedAt)
+	})
+	if len(stale) > limit {
+		stale = stale[:limit]
+	}
+	return stale, nil
+}
+
+func (r *MemoryRepository) Delete(ctx context.Context, ip string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	network, ok := r.containing(ip)
+	if !ok {
+		return repositoryInterfaces.ErrNotFound
+	}
+
+	delete(r.locations, network)
+	return nil
+}
+
+func (r *MemoryRepository) DeleteNetwork(ctx context.Context, network string) error {
+	prefix, err := parseNetwork(models.IPLocation{Network: network})
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.locations[prefix]; !ok {
+		return repositoryInterfaces.ErrNotFound
+	}
+	delete(r.locations, prefix)
+	return nil
+}
+
+func (r *MemoryRepository) Summarize(ctx context.Context, staleBefore time.Time, topCountries int) (models.LocationSummary, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var summary models.LocationSummary
+	providers := make(map[string]int64)
+	countries := make(map[string]int64)
+	for network, stored := range r.locations {
+		l := stored.location
+		summary.Total++
+		if network.Addr().Is4() {
+			summary.IPv4++
+		} else {
+			summary.IPv6++
+		}
+		if l.FetchedAt != nil && l.FetchedAt.Before(staleBefore) {
+			summary.Stale++
+		}
+		providers[l.Provider]++
+		countries[l.Country]++
+
+		if summary.Oldest == nil || l.CreatedAt.Before(*summary.Oldest) {
+			summary.Oldest = l.CreatedAt
+		}
+		if summary.Newest == nil || l.CreatedAt.After(*summary.Newest) {
+			summary.Newest = l.CreatedAt
+		}
+	}
+
+	summary.Providers = sortedCounts(providers, len(providers))
+	summary.Countries = sortedCounts(countries, topCountries)
+	return summary, nil
+}
+
+// sortedCounts returns at most limit counts ordered like the aggregate queries, the largest first
+func sortedCounts(counts map[string]int64, limit int) []models.NameCount {
+	sorted := make([]models.NameCount, 0, len(counts))
+	for name, count := range counts {
+		sorted = append(sorted, models.NameCount{Name: name, Count: count})
+	}
+	slices.SortFunc(sorted, func(a, b models.NameCount) int {
+		if c := cmp.Compare(b.Count, a.Count); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Name, b.Name)
+	})
+	return sorted[:min(len(sorted), max(limit, 0))]
+}
+
+// GetAll returns a page of locations matching the query. Pages follow the same keyset
+// order as LocRepository: by the sort field and then by id
+func (r *MemoryRepository) GetAll(ctx context.Context, q models.LocationQuery) (models.LocationPage, error) {
+	if _, ok := sortColumns[q.Sort]; !ok {
+		return models.LocationPage{}, fmt.Errorf("unknown sort field %q", q.Sort)
+	}
+
+	var within netip.Prefix
+	if q.Network != "" {
+		var err error
+		if within, err = parseNetwork(models.IPLocation{Network: q.Network}); err != nil {
+			return models.LocationPage{}, err
+		}
+	}
+
+	r.mu.RLock()
+	var matching []memoryLocation
+	for network, stored := range r.locations {
+		l := stored.location
+		switch {
+		case q.Country != "" && l.Country != q.Country,
+			q.City != "" && l.City != q.City,
+			q.Provider != "" && l.Provider != q.Provider,
+			within.IsValid() && (network.Bits() < within.Bits() || !within.Contains(network.Addr())),
+			!q.CreatedFrom.IsZero() && l.CreatedAt.Before(q.CreatedFrom),
+			!q.CreatedTo.IsZero() && !l.CreatedAt.Before(q.CreatedTo):
+			continue
+		}
+		matching = append(matching, stored)
+	}
+	r.mu.RUnlock()
+
+	compare := func(a, b memoryLocation) int {
+		c := compareSortField(q.Sort, a.location, b.location)
+		if c == 0 {
+			c = cmp.Compare(a.id, b.id)
+		}
+		if q.Desc {
+			return -c
+		}
+		return c
+	}
+	slices.SortFunc(matching, compare)
+
+	var page models.LocationPage
+	if !q.SkipTotal {
+		page.Total = int64(len(matching))
+	}
+
+	if q.Cursor != "" {
+		after, err := memoryCursor(q)
+		if err != nil {
+			return models.LocationPage{}, repositoryInterfaces.ErrInvalidCursor
+		}
+		i, _ := slices.BinarySearchFunc(matching, after, compare)
+		if i < len(matching) && compare(matching[i], after) == 0 {
+			i++
+		}
+		matching = matching[i:]
+	}
+
+	if len(matching) > q.Limit {
+		last := matching[q.Limit-1]
+		page.NextCursor = encodeCursor(cursor{Sort: q.Sort, Desc: q.Desc, Value: sortValue(q.Sort, last.location), ID: strconv.FormatInt(last.id, 10)})
+		matching = matching[:q.Limit]
+	}
+
+	for _, stored := range matching {
+		page.Locations = append(page.Locations, stored.location)
+	}
+	return page, nil
+}
+
+// memoryCursor decodes the cursor of the query into the location it points at
+func memoryCursor(q models.LocationQuery) (memoryLocation, error) {
+	c, err := decodeCursor(q.Cursor)
+	if err != nil || c.Sort != q.Sort || c.Desc != q.Desc {
+		return memoryLocation{}, repositoryInterfaces.ErrInvalidCursor
+	}
+
+	var after memoryLocation
+	if after.id, err = strconv.ParseInt(c.ID, 10, 64); err != nil {
+		return memoryLocation{}, err
+	}
+
+	switch q.Sort {
+	case models.SortCountry:
+		after.location.Country = c.Value
+	case models.SortCity:
+		after.location.City = c.Value
+	case models.SortNetwork:
+		after.location.Network = c.Value
+		if _, err := netip.ParsePrefix(c.Value); err != nil {
+			return memoryLocation{}, err
+		}
+	default:
+		createdAt, err := time.Parse(time.RFC3339Nano, c.Value)
+		if err != nil {
+			return memoryLocation{}, err
+		}
+		after.location.CreatedAt = &createdAt
+	}
+	return after, nil
+}
+
+// compareSortField orders locations by the value of the sort field
+func compareSortField(sort string, a, b models.IPLocation) int {
+	switch sort {
+	case models.SortCountry:
+		return strings.Compare(a.Country, b.Country)
+	case models.SortCity:
+		return strings.Compare(a.City, b.City)
+	case models.SortNetwork:
+		return compareNetworks(netip.MustParsePrefix(a.Network), netip.MustParsePrefix(b.Network))
+	default:
+		return a.CreatedAt.Compare(*b.CreatedAt)
+	}
+}
+
+// MemoryRangeRepository keeps an imported range database in process memory
+type MemoryRangeRepository struct {
+	mu     sync.RWMutex
+	ranges []models.IPRange
+}
+
+func NewMemoryRangeRepository() *MemoryRangeRepository {
+	return &MemoryRangeRepository{}
+}
+
+func (r *MemoryRangeRepository) FindRange(ctx context.Context, ip string) (models.IPRange, error) {
+	addr, err := ipaddr.Parse(ip)
+	if err != nil {
+		return models.IPRange{}, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	// ranges don't overlap, so it's the one with the closest start not after addr
+	i, found := slices.BinarySearchFunc(r.ranges, addr, func(rng models.IPRange, addr netip.Addr) int {
+		return rng.From.Compare(addr)
+	})
+	if !found {
+		i--
+	}
+	if i < 0 || r.ranges[i].To.BitLen() != addr.BitLen() || r.ranges[i].To.Less(addr) {
+		return models.IPRange{}, repositoryInterfaces.ErrNotFound
+	}
+	return r.ranges[i], nil
+}
+
+func (r *MemoryRangeRepository) ReplaceRanges(ctx context.Context, reader repositoryInterfaces.RangeReader) (int64, error) {
+	var ranges []models.IPRange
+	for {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+
+		rng, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		ranges = append(ranges, rng)
+	}
+
+	slices.SortFunc(ranges, func(a, b models.IPRange) int {
+		return a.From.Compare(b.From)
+	})
+
+	r.mu.Lock()
+	r.ranges = ranges
+	r.mu.Unlock()
+	return int64(len(ranges)), nil
+}
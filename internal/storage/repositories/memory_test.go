@@ -0,0 +1,70 @@
+package repositories_test
+
+import (
+	"context"
+	"github.com/Fyefhqdishka/LocFinder/internal/models"
+	"github.com/Fyefhqdishka/LocFinder/internal/storage/repositories"
+	"github.com/Fyefhqdishka/LocFinder/internal/storage/repositoryInterfaces"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/netip"
+	"testing"
+)
+
+func TestMemoryRepositoryMostSpecificNetwork(t *testing.T) {
+	ctx := context.Background()
+	repo := repositories.NewMemoryRepository()
+
+	assert.NoError(t, repo.Save(ctx, models.IPLocation{Network: "37.99.0.0/16", Country: "Kazakhstan"}))
+	assert.NoError(t, repo.Save(ctx, models.IPLocation{Network: "37.99.42.0/24", City: "Almaty"}))
+
+	location, err := repo.GetByIP(ctx, "37.99.42.212")
+	assert.NoError(t, err)
+	assert.Equal(t, "Almaty", location.City)
+	assert.Equal(t, "37.99.42.212", location.IP)
+	assert.NotNil(t, location.FetchedAt)
+
+	assert.NoError(t, repo.Delete(ctx, "37.99.42.212"))
+	location, err = repo.GetByIP(ctx, "37.99.42.212")
+	assert.NoError(t, err)
+	assert.Equal(t, "37.99.0.0/16", location.Network)
+
+	_, err = repo.GetByIP(ctx, "8.8.8.8")
+	assert.ErrorIs(t, err, repositoryInterfaces.ErrNotFound)
+	assert.ErrorIs(t, repo.Create(ctx, models.IPLocation{Network: "37.99.0.0/16"}), repositoryInterfaces.ErrConflict)
+}
+
+// rangeSlice reads ranges from a slice
+type rangeSlice []models.IPRange
+
+func (s *rangeSlice) Next() (models.IPRange, error) {
+	if len(*s) == 0 {
+		return models.IPRange{}, io.EOF
+	}
+	rng := (*s)[0]
+	*s = (*s)[1:]
+	return rng, nil
+}
+
+func TestMemoryRangeRepository(t *testing.T) {
+	ctx := context.Background()
+	repo := repositories.NewMemoryRangeRepository()
+
+	n, err := repo.ReplaceRanges(ctx, &rangeSlice{
+		{From: netip.MustParseAddr("2a00:1450::"), To: netip.MustParseAddr("2a00:1450:ffff:ffff:ffff:ffff:ffff:ffff"), City: "Dublin"},
+		{From: netip.MustParseAddr("1.0.0.0"), To: netip.MustParseAddr("1.0.0.255"), City: "Sydney"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+
+	rng, err := repo.FindRange(ctx, "1.0.0.7")
+	assert.NoError(t, err)
+	assert.Equal(t, "Sydney", rng.City)
+
+	rng, err = repo.FindRange(ctx, "2a00:1450::1")
+	assert.NoError(t, err)
+	assert.Equal(t, "Dublin", rng.City)
+
+	_, err = repo.FindRange(ctx, "1.0.1.0")
+	assert.ErrorIs(t, err, repositoryInterfaces.ErrNotFound)
+}
@@ -0,0 +1,34 @@
+package repositories
+
+import (
+	"fmt"
+	"github.com/Fyefhqdishka/LocFinder/internal/ipaddr"
+	"github.com/Fyefhqdishka/LocFinder/internal/models"
+	"net/netip"
+)
+
+// parseNetwork returns the network a location is stored for, or the single IP when the network
+// is not set. Like a Postgres cidr the network must not have bits set right of the mask
+func parseNetwork(l models.IPLocation) (netip.Prefix, error) {
+	if l.Network == "" {
+		addr, err := ipaddr.Parse(l.IP)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+
+	prefix, err := netip.ParsePrefix(l.Network)
+	if err != nil || prefix != prefix.Masked() {
+		return netip.Prefix{}, fmt.Errorf("invalid network %q", l.Network)
+	}
+	return prefix, nil
+}
+
+// compareNetworks orders networks by family, first address and prefix length
+func compareNetworks(a, b netip.Prefix) int {
+	if c := a.Addr().Compare(b.Addr()); c != 0 {
+		return c
+	}
+	return a.Bits() - b.Bits()
+}
@@ -0,0 +1,116 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/Fyefhqdishka/LocFinder/internal/models"
+	"github.com/Fyefhqdishka/LocFinder/internal/storage/repositoryInterfaces"
+	"github.com/lib/pq"
+	"io"
+	"net/netip"
+)
+
+// rangeImportLock is the advisory lock key serializing dataset imports
+const rangeImportLock = 7245001
+
+var rangeColumns = []string{"ip_from", "ip_to", "country", "region", "city", "zip", "lat", "lon", "timezone"}
+
+// RangeRepository keeps the imported range database in the ip_ranges table
+type RangeRepository struct {
+	db *sql.DB
+}
+
+func NewRangeRepository(db *sql.DB) *RangeRepository {
+	return &RangeRepository{db: db}
+}
+
+// FindRange returns the range containing ip. Ranges don't overlap, so it's the one
+// with the closest start not after ip, provided it doesn't end before ip
+func (r *RangeRepository) FindRange(ctx context.Context, ip string) (models.IPRange, error) {
+	query := `SELECT ip_from, ip_to, country, region, city, zip, lat, lon, timezone FROM (
+			SELECT * FROM ip_ranges WHERE ip_from <= $1::inet ORDER BY ip_from DESC LIMIT 1
+		) r WHERE ip_to >= $1::inet`
+
+	var rng models.IPRange
+	var from, to string
+	err := r.db.QueryRowContext(ctx, query, ip).Scan(&from, &to, &rng.Country, &rng.Region, &rng.City, &rng.Zip, &rng.Lat, &rng.Lon, &rng.Timezone)
+	if err != nil {
+		return models.IPRange{}, storageError(err)
+	}
+
+	if rng.From, err = netip.ParseAddr(from); err != nil {
+		return models.IPRange{}, err
+	}
+	if rng.To, err = netip.ParseAddr(to); err != nil {
+		return models.IPRange{}, err
+	}
+	return rng, nil
+}
+
+// ReplaceRanges copies the ranges into a new table and swaps it with the active one in the same
+// transaction, so readers see either the old or the new dataset and a failed import changes nothing
+func (r *RangeRepository) ReplaceRanges(ctx context.Context, ranges repositoryInterfaces.RangeReader) (int64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, rangeImportLock); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, `CREATE TABLE ip_ranges_import (LIKE ip_ranges INCLUDING DEFAULTS)`); err != nil {
+		return 0, err
+	}
+
+	rows, err := copyRanges(ctx, tx, ranges)
+	if err != nil {
+		return 0, err
+	}
+
+	swap := []string{
+		`CREATE INDEX idx_ip_ranges_import_from ON ip_ranges_import(ip_from)`,
+		`DROP TABLE ip_ranges`,
+		`ALTER TABLE ip_ranges_import RENAME TO ip_ranges`,
+		`ALTER INDEX idx_ip_ranges_import_from RENAME TO idx_ip_ranges_from`,
+	}
+	for _, query := range swap {
+		if _, err := tx.ExecContext(ctx, query); err != nil {
+			return 0, err
+		}
+	}
+
+	return rows, tx.Commit()
+}
+
+func copyRanges(ctx context.Context, tx *sql.Tx, ranges repositoryInterfaces.RangeReader) (int64, error) {
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("ip_ranges_import", rangeColumns...))
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	var rows int64
+	for {
+		rng, err := ranges.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		_, err = stmt.ExecContext(ctx, rng.From.String(), rng.To.String(), rng.Country, rng.Region, rng.City, rng.Zip, rng.Lat, rng.Lon, rng.Timezone)
+		if err != nil {
+			return 0, fmt.Errorf("copy range %s-%s: %w", rng.From, rng.To, err)
+		}
+		rows++
+	}
+
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		return 0, err
+	}
+	return rows, nil
+}
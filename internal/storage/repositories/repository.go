@@ -1,11 +1,30 @@
 package repositories
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"github.com/Fyefhqdishka/LocFinder/internal/models"
+	"github.com/Fyefhqdishka/LocFinder/internal/storage/repositoryInterfaces"
+	"github.com/lib/pq"
 	"log/slog"
+	"strconv"
+	"strings"
+	"time"
 )
 
+const locationColumns = `country, region, city, zip, lat, lon, timezone, isp, org, asn, provider`
+
+// selectColumns reads a stored network, its first address stands for the IP
+const selectColumns = `host(network), network, ` + locationColumns + `, fetched_at, created_at`
+
+// uniqueViolation is the SQLSTATE of a unique constraint violation
+const uniqueViolation = "23505"
+
+// containingNetwork selects the most specific stored network containing the IP given as $1
+const containingNetwork = `(SELECT network FROM locations WHERE network >>= $1::inet ORDER BY masklen(network) DESC LIMIT 1)`
+
 type LocRepository struct {
 	db *sql.DB
 }
@@ -16,36 +35,282 @@ func NewLocRepository(db *sql.DB, log *slog.Logger) *LocRepository {
 	}
 }
 
-func (r *LocRepository) GetByIP(ip string) (string, string, error) {
-	query := `SELECT country, city FROM locations WHERE ip_address = $1`
-	row := r.db.QueryRow(query, ip)
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// scanLocation reads selectColumns, extra destinations are filled by columns selected before them
+func scanLocation(row scanner, extra ...any) (models.IPLocation, error) {
+	var l models.IPLocation
+	dest := append(extra, &l.IP, &l.Network, &l.Country, &l.Region, &l.City, &l.Zip, &l.Lat, &l.Lon, &l.Timezone, &l.ISP, &l.Org, &l.AS, &l.Provider, &l.FetchedAt, &l.CreatedAt)
+	err := row.Scan(dest...)
+	return l, err
+}
+
+// GetByIP returns the location of the most specific stored network containing ip
+func (r *LocRepository) GetByIP(ctx context.Context, ip string) (models.IPLocation, error) {
+	query := `SELECT ` + selectColumns + ` FROM locations WHERE network >>= $1::inet ORDER BY masklen(network) DESC LIMIT 1`
+	location, err := scanLocation(r.db.QueryRowContext(ctx, query, ip))
+	if err != nil {
+		return models.IPLocation{}, storageError(err)
+	}
+	location.IP = ip
+	return location, nil
+}
+
+// GetByIPs returns stored locations for the given IPs in a single query, missing IPs are skipped
+func (r *LocRepository) GetByIPs(ctx context.Context, ips []string) ([]models.IPLocation, error) {
+	query := `SELECT DISTINCT ON (q.ip) q.ip, network, ` + locationColumns + `, fetched_at, created_at
+		FROM unnest($1::text[]) AS q(ip) JOIN locations ON network >>= q.ip::inet
+		ORDER BY q.ip, masklen(network) DESC`
+	return r.queryLocations(ctx, query, pq.Array(ips))
+}
+
+// Save stores the location received from a provider for its network, or for the single IP
+// when the network is not set. It's an upsert, so saving the same network concurrently
+// or repeatedly replaces the data instead of failing
+func (r *LocRepository) Save(ctx context.Context, l models.IPLocation) error {
+	query := `INSERT INTO locations (network, ` + locationColumns + `, created_at, fetched_at)
+		VALUES ($1::cidr, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, NOW(), NOW())
+		ON CONFLICT (network) DO UPDATE SET country = EXCLUDED.country, region = EXCLUDED.region,
+			city = EXCLUDED.city, zip = EXCLUDED.zip, lat = EXCLUDED.lat, lon = EXCLUDED.lon,
+			timezone = EXCLUDED.timezone, isp = EXCLUDED.isp, org = EXCLUDED.org, asn = EXCLUDED.asn,
+			provider = EXCLUDED.provider, fetched_at = EXCLUDED.fetched_at`
+	_, err := r.db.ExecContext(ctx, query, networkOf(l), l.Country, l.Region, l.City, l.Zip, l.Lat, l.Lon, l.Timezone, l.ISP, l.Org, l.AS, l.Provider)
+	return storageError(err)
+}
+
+// Create stores a manual location for its network, or for the single IP when the network is not set
+func (r *LocRepository) Create(ctx context.Context, l models.IPLocation) error {
+	query := `INSERT INTO locations (network, ` + locationColumns + `, created_at)
+		VALUES ($1::cidr, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, NOW())`
+	_, err := r.db.ExecContext(ctx, query, networkOf(l), l.Country, l.Region, l.City, l.Zip, l.Lat, l.Lon, l.Timezone, l.ISP, l.Org, l.AS, l.Provider)
+	return storageError(err)
+}
+
+// Replace stores a manual location like Create, replacing the data of an already stored network.
+// xmax of a freshly inserted row is zero, so it tells an insert from an update
+func (r *LocRepository) Replace(ctx context.Context, l models.IPLocation) (bool, error) {
+	query := `INSERT INTO locations (network, ` + locationColumns + `, created_at)
+		VALUES ($1::cidr, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, NOW())
+		ON CONFLICT (network) DO UPDATE SET country = EXCLUDED.country, region = EXCLUDED.region,
+			city = EXCLUDED.city, zip = EXCLUDED.zip, lat = EXCLUDED.lat, lon = EXCLUDED.lon,
+			timezone = EXCLUDED.timezone, isp = EXCLUDED.isp, org = EXCLUDED.org, asn = EXCLUDED.asn,
+			provider = EXCLUDED.provider, fetched_at = NULL
+		RETURNING xmax = 0`
+
+	var created bool
+	err := r.db.QueryRowContext(ctx, query, networkOf(l), l.Country, l.Region, l.City, l.Zip, l.Lat, l.Lon, l.Timezone, l.ISP, l.Org, l.AS, l.Provider).Scan(&created)
+	return created, storageError(err)
+}
+
+// Update replaces the data of the most specific stored network containing the IP with manual data,
+// ErrNotFound is returned when there is none
+func (r *LocRepository) Update(ctx context.Context, l models.IPLocation) error {
+	query := `UPDATE locations SET country = $2, region = $3, city = $4, zip = $5, lat = $6, lon = $7,
+		timezone = $8, isp = $9, org = $10, asn = $11, provider = $12, fetched_at = NULL WHERE network = ` + containingNetwork
+	return r.execOne(ctx, query, l.IP, l.Country, l.Region, l.City, l.Zip, l.Lat, l.Lon, l.Timezone, l.ISP, l.Org, l.AS, l.Provider)
+}
+
+// Refresh replaces the location data received from a provider and resets its fetched_at.
+// Like Update it changes the most specific stored network containing the IP
+func (r *LocRepository) Refresh(ctx context.Context, l models.IPLocation) error {
+	query := `UPDATE locations SET country = $2, region = $3, city = $4, zip = $5, lat = $6, lon = $7,
+		timezone = $8, isp = $9, org = $10, asn = $11, provider = $12, fetched_at = NOW() WHERE network = ` + containingNetwork
+	return r.execOne(ctx, query, l.IP, l.Country, l.Region, l.City, l.Zip, l.Lat, l.Lon, l.Timezone, l.ISP, l.Org, l.AS, l.Provider)
+}
+
+// GetStale returns up to limit locations fetched before the given time, the oldest first
+func (r *LocRepository) GetStale(ctx context.Context, before time.Time, limit int) ([]models.IPLocation, error) {
+	query := `SELECT ` + selectColumns + ` FROM locations WHERE fetched_at < $1 ORDER BY fetched_at LIMIT $2`
+	return r.queryLocations(ctx, query, before, limit)
+}
+
+// Delete removes the most specific stored network containing ip, ErrNotFound is returned when there is none
+func (r *LocRepository) Delete(ctx context.Context, ip string) error {
+	query := `DELETE FROM locations WHERE network = ` + containingNetwork
+	return r.execOne(ctx, query, ip)
+}
+
+// DeleteNetwork removes the network, ErrNotFound is returned when it's not stored
+func (r *LocRepository) DeleteNetwork(ctx context.Context, network string) error {
+	return r.execOne(ctx, `DELETE FROM locations WHERE network = $1::cidr`, network)
+}
+
+func (r *LocRepository) Summarize(ctx context.Context, staleBefore time.Time, topCountries int) (models.LocationSummary, error) {
+	var summary models.LocationSummary
+	var oldest, newest sql.NullTime
+	query := `SELECT count(*), count(*) FILTER (WHERE family(network) = 4), count(*) FILTER (WHERE fetched_at < $1),
+		min(created_at), max(created_at) FROM locations`
+	stale := sql.NullTime{Time: staleBefore, Valid: !staleBefore.IsZero()}
+	if err := r.db.QueryRowContext(ctx, query, stale).Scan(&summary.Total, &summary.IPv4, &summary.Stale, &oldest, &newest); err != nil {
+		return models.LocationSummary{}, err
+	}
+	summary.IPv6 = summary.Total - summary.IPv4
+	if oldest.Valid {
+		summary.Oldest, summary.Newest = &oldest.Time, &newest.Time
+	}
+
+	var err error
+	if summary.Providers, err = queryCounts(ctx, r.db, `SELECT provider, count(*) FROM locations
+		GROUP BY provider ORDER BY count(*) DESC, provider`); err != nil {
+		return models.LocationSummary{}, err
+	}
+	if summary.Countries, err = queryCounts(ctx, r.db, `SELECT country, count(*) FROM locations
+		GROUP BY country ORDER BY count(*) DESC, country LIMIT $1`, topCountries); err != nil {
+		return models.LocationSummary{}, err
+	}
+	return summary, nil
+}
+
+// queryCounts reads rows of a name and the number of locations having it
+func queryCounts(ctx context.Context, db *sql.DB, query string, args ...any) ([]models.NameCount, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []models.NameCount{}
+	for rows.Next() {
+		var count models.NameCount
+		if err := rows.Scan(&count.Name, &count.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+	return counts, rows.Err()
+}
+
+// GetAll returns a page of locations matching the query and the total number of matching ones.
+// Pages are keyset-paginated by the sort column and id, so listing stays fast deep into the table
+func (r *LocRepository) GetAll(ctx context.Context, q models.LocationQuery) (models.LocationPage, error) {
+	column, ok := sortColumns[q.Sort]
+	if !ok {
+		return models.LocationPage{}, fmt.Errorf("unknown sort field %q", q.Sort)
+	}
+
+	var where []string
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if q.Country != "" {
+		where = append(where, "country = "+arg(q.Country))
+	}
+	if q.City != "" {
+		where = append(where, "city = "+arg(q.City))
+	}
+	if q.Provider != "" {
+		where = append(where, "provider = "+arg(q.Provider))
+	}
+	if q.Network != "" {
+		where = append(where, "network <<= "+arg(q.Network)+"::cidr")
+	}
+	if !q.CreatedFrom.IsZero() {
+		where = append(where, "created_at >= "+arg(q.CreatedFrom))
+	}
+	if !q.CreatedTo.IsZero() {
+		where = append(where, "created_at < "+arg(q.CreatedTo))
+	}
+
+	filter := ""
+	if len(where) > 0 {
+		filter = " WHERE " + strings.Join(where, " AND ")
+	}
+
+	var page models.LocationPage
+	if !q.SkipTotal {
+		if err := r.db.QueryRowContext(ctx, `SELECT count(*) FROM locations`+filter, args...).Scan(&page.Total); err != nil {
+			return models.LocationPage{}, err
+		}
+	}
+
+	op, dir := ">", "ASC"
+	if q.Desc {
+		op, dir = "<", "DESC"
+	}
+
+	if q.Cursor != "" {
+		c, err := decodeCursor(q.Cursor)
+		if err != nil || c.Sort != q.Sort || c.Desc != q.Desc {
+			return models.LocationPage{}, repositoryInterfaces.ErrInvalidCursor
+		}
+		where = append(where, fmt.Sprintf("(%s, id) %s (%s%s, %s::uuid)", column, op, arg(c.Value), sortCasts[q.Sort], arg(c.ID)))
+		filter = " WHERE " + strings.Join(where, " AND ")
+	}
+
+	// one more row tells whether there is a next page
+	query := fmt.Sprintf(`SELECT id, %s FROM locations%s ORDER BY %s %s, id %s LIMIT %s`,
+		selectColumns, filter, column, dir, dir, arg(q.Limit+1))
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return models.LocationPage{}, err
+	}
+	defer rows.Close()
+
+	var last string
+	for rows.Next() {
+		if len(page.Locations) == q.Limit {
+			page.NextCursor = encodeCursor(cursor{Sort: q.Sort, Desc: q.Desc, Value: sortValue(q.Sort, page.Locations[q.Limit-1]), ID: last})
+			break
+		}
+
+		var id string
+		location, err := scanLocation(rows, &id)
+		if err != nil {
+			return models.LocationPage{}, err
+		}
+		page.Locations = append(page.Locations, location)
+		last = id
+	}
 
-	var country, city string
-	err := row.Scan(&country, &city)
-	return country, city, err
+	return page, rows.Err()
 }
 
-func (r *LocRepository) Save(ip, country, city string) error {
-	query := `INSERT INTO locations (ip_address, country, city, created_at) VALUES ($1, $2, $3, NOW())`
-	_, err := r.db.Exec(query, ip, country, city)
-	return err
+// networkOf returns the network a location is stored for
+func networkOf(l models.IPLocation) string {
+	if l.Network == "" {
+		return l.IP
+	}
+	return l.Network
 }
 
-func (r *LocRepository) Update(ip, country, city string) error {
-	query := `UPDATE locations SET country = $2, city = $3 WHERE ip_address = $1`
-	_, err := r.db.Exec(query, ip, country, city)
-	return err
+// execOne runs a statement changing a single location, ErrNotFound is returned when it matched no rows
+func (r *LocRepository) execOne(ctx context.Context, query string, args ...any) error {
+	res, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return storageError(err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return repositoryInterfaces.ErrNotFound
+	}
+	return nil
 }
 
-func (r *LocRepository) Delete(ip string) error {
-	query := `DELETE FROM locations WHERE ip_address = $1`
-	_, err := r.db.Exec(query, ip)
-	return err
+// storageError translates driver errors with a meaning for callers into repository errors
+func storageError(err error) error {
+	var pqErr *pq.Error
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return repositoryInterfaces.ErrNotFound
+	case errors.As(err, &pqErr) && pqErr.Code == uniqueViolation:
+		return fmt.Errorf("%w: %s", repositoryInterfaces.ErrConflict, pqErr.Constraint)
+	default:
+		return err
+	}
 }
 
-func (r *LocRepository) GetAll() ([]models.IPLocation, error) {
-	query := `SELECT ip_address, country, city FROM locations`
-	rows, err := r.db.Query(query)
+func (r *LocRepository) queryLocations(ctx context.Context, query string, args ...any) ([]models.IPLocation, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -53,11 +318,11 @@ func (r *LocRepository) GetAll() ([]models.IPLocation, error) {
 
 	var locations []models.IPLocation
 	for rows.Next() {
-		var location models.IPLocation
-		if err := rows.Scan(&location.IP, &location.Country, &location.City); err != nil {
+		location, err := scanLocation(rows)
+		if err != nil {
 			return nil, err
 		}
 		locations = append(locations, location)
 	}
-	return locations, nil
+	return locations, rows.Err()
 }
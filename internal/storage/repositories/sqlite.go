@@ -0,0 +1,619 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/Fyefhqdishka/LocFinder/internal/ipaddr"
+	"github.com/Fyefhqdishka/LocFinder/internal/models"
+	"github.com/Fyefhqdishka/LocFinder/internal/storage/repositoryInterfaces"
+	"io"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+)
+
+// sqliteSelectColumns reads a stored network, its first address stands for the IP
+const sqliteSelectColumns = `network, ` + locationColumns + `, fetched_at, created_at`
+
+// sqliteMaxArgs bounds the arguments of one statement below the SQLite limit of 32766
+const sqliteMaxArgs = 10000
+
+// sqliteImportBatch is the number of ranges inserted per transaction, the write lock is
+// released between batches so an import doesn't hold off other writers
+const sqliteImportBatch = 10000
+
+// sqliteSortColumns maps sort fields to columns of the SQLite locations table
+var sqliteSortColumns = map[string][]string{
+	models.SortCreatedAt: {"created_at"},
+	models.SortCountry:   {"country"},
+	models.SortCity:      {"city"},
+	models.SortNetwork:   {"ip_from", "bits"},
+}
+
+// SQLiteRepository keeps locations in an embedded SQLite database migrated by migrations/sqlite.
+// Networks are stored with keys of their first and last addresses, see addrKey
+type SQLiteRepository struct {
+	db *sql.DB
+}
+
+func NewSQLiteRepository(db *sql.DB) *SQLiteRepository {
+	return &SQLiteRepository{db: db}
+}
+
+// addrKey returns the address length in bits followed by the address bytes.
+// Keys compared byte by byte order IPv4 before IPv6 and addresses numerically
+func addrKey(addr netip.Addr) []byte {
+	return append([]byte{byte(addr.BitLen())}, addr.AsSlice()...)
+}
+
+func addrFromKey(key []byte) (netip.Addr, error) {
+	addr, ok := netip.AddrFromSlice(key[min(len(key), 1):])
+	if !ok {
+		return netip.Addr{}, fmt.Errorf("invalid address key %x", key)
+	}
+	return addr, nil
+}
+
+// containingNetworks lists the networks of every prefix length containing addr, most specific first.
+// Stored networks may nest, so a lookup seeks each of them on the unique network index
+func containingNetworks(addr netip.Addr) []any {
+	networks := make([]any, 0, addr.BitLen()+1)
+	for bits := addr.BitLen(); bits >= 0; bits-- {
+		prefix, _ := addr.Prefix(bits)
+		networks = append(networks, prefix.String())
+	}
+	return networks
+}
+
+func sqlitePlaceholders(n int) string {
+	return strings.Repeat("?, ", n-1) + "?"
+}
+
+// sqliteContaining selects the most specific stored network of the n networks given as the last arguments
+func sqliteContaining(n int) string {
+	return `(SELECT id FROM locations WHERE network IN (` + sqlitePlaceholders(n) + `) ORDER BY bits DESC LIMIT 1)`
+}
+
+func scanSQLiteLocation(row scanner, extra ...any) (models.IPLocation, error) {
+	var l models.IPLocation
+	var fetchedAt sql.NullInt64
+	var createdAt int64
+	dest := append(extra, &l.Network, &l.Country, &l.Region, &l.City, &l.Zip, &l.Lat, &l.Lon, &l.Timezone, &l.ISP, &l.Org, &l.AS, &l.Provider, &fetchedAt, &createdAt)
+	if err := row.Scan(dest...); err != nil {
+		return models.IPLocation{}, err
+	}
+
+	network, err := netip.ParsePrefix(l.Network)
+	if err != nil {
+		return models.IPLocation{}, err
+	}
+	l.IP = network.Addr().String()
+
+	created := time.Unix(0, createdAt).UTC()
+	l.CreatedAt = &created
+	if fetchedAt.Valid {
+		fetched := time.Unix(0, fetchedAt.Int64).UTC()
+		l.FetchedAt = &fetched
+	}
+	return l, nil
+}
+
+func (r *SQLiteRepository) GetByIP(ctx context.Context, ip string) (models.IPLocation, error) {
+	addr, err := ipaddr.Parse(ip)
+	if err != nil {
+		return models.IPLocation{}, err
+	}
+
+	networks := containingNetworks(addr)
+	query := `SELECT ` + sqliteSelectColumns + ` FROM locations WHERE network IN (` + sqlitePlaceholders(len(networks)) + `)
+		ORDER BY bits DESC LIMIT 1`
+	location, err := scanSQLiteLocation(r.db.QueryRowContext(ctx, query, networks...))
+	if err != nil {
+		return models.IPLocation{}, sqliteError(err)
+	}
+	location.IP = ip
+	return location, nil
+}
+
+// GetByIPs reads the stored networks containing any of the IPs in as few statements as the
+// argument limit allows and picks the most specific one for each IP
+func (r *SQLiteRepository) GetByIPs(ctx context.Context, ips []string) ([]models.IPLocation, error) {
+	candidates := make(map[string][]any, len(ips))
+	var networks []any
+	seen := make(map[any]bool)
+	for _, ip := range ips {
+		addr, err := ipaddr.Parse(ip)
+		if err != nil {
+			continue
+		}
+		candidates[ip] = containingNetworks(addr)
+		for _, network := range candidates[ip] {
+			if !seen[network] {
+				seen[network] = true
+				networks = append(networks, network)
+			}
+		}
+	}
+
+	stored := make(map[string]models.IPLocation)
+	for chunk := range slices.Chunk(networks, sqliteMaxArgs) {
+		query := `SELECT ` + sqliteSelectColumns + ` FROM locations WHERE network IN (` + sqlitePlaceholders(len(chunk)) + `)`
+		if err := r.scanNetworks(ctx, stored, query, chunk); err != nil {
+			return nil, err
+		}
+	}
+
+	var locations []models.IPLocation
+	for _, ip := range ips {
+		for _, network := range candidates[ip] {
+			if location, ok := stored[network.(string)]; ok {
+				location.IP = ip
+				locations = append(locations, location)
+				break
+			}
+		}
+	}
+	return locations, nil
+}
+
+func (r *SQLiteRepository) scanNetworks(ctx context.Context, stored map[string]models.IPLocation, query string, args []any) error {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		location, err := scanSQLiteLocation(rows)
+		if err != nil {
+			return err
+		}
+		stored[location.Network] = location
+	}
+	return rows.Err()
+}
+
+// upsert inserts the location for its network or replaces the data of the stored one,
+// fetchedAt is nil for manual locations
+func (r *SQLiteRepository) upsert(ctx context.Context, db interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}, l models.IPLocation, fetchedAt *time.Time, onConflict bool) error {
+	network, err := parseNetwork(l)
+	if err != nil {
+		return err
+	}
+
+	var fetched any
+	if fetchedAt != nil {
+		fetched = fetchedAt.UnixNano()
+	}
+
+	query := `INSERT INTO locations (network, ip_from, ip_to, bits, ` + locationColumns + `, created_at, fetched_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	if onConflict {
+		query += ` ON CONFLICT (network) DO UPDATE SET country = excluded.country, region = excluded.region,
+			city = excluded.city, zip = excluded.zip, lat = excluded.lat, lon = excluded.lon,
+			timezone = excluded.timezone, isp = excluded.isp, org = excluded.org, asn = excluded.asn,
+			provider = excluded.provider, fetched_at = excluded.fetched_at`
+	}
+
+	_, err = db.ExecContext(ctx, query, network.String(), addrKey(network.Addr()), addrKey(ipaddr.LastAddr(network)), network.Bits(),
+		l.Country, l.Region, l.City, l.Zip, l.Lat, l.Lon, l.Timezone, l.ISP, l.Org, l.AS, l.Provider, time.Now().UnixNano(), fetched)
+	return sqliteError(err)
+}
+
+func (r *SQLiteRepository) Save(ctx context.Context, l models.IPLocation) error {
+	now := time.Now()
+	return r.upsert(ctx, r.db, l, &now, true)
+}
+
+func (r *SQLiteRepository) Create(ctx context.Context, l models.IPLocation) error {
+	return r.upsert(ctx, r.db, l, nil, false)
+}
+
+func (r *SQLiteRepository) Replace(ctx context.Context, l models.IPLocation) (bool, error) {
+	network, err := parseNetwork(l)
+	if err != nil {
+		return false, err
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var stored int
+	if err := tx.QueryRowContext(ctx, `SELECT count(*) FROM locations WHERE network = ?`, network.String()).Scan(&stored); err != nil {
+		return false, err
+	}
+	if err := r.upsert(ctx, tx, l, nil, true); err != nil {
+		return false, err
+	}
+	return stored == 0, tx.Commit()
+}
+
+func (r *SQLiteRepository) Update(ctx context.Context, l models.IPLocation) error {
+	query := `UPDATE locations SET country = ?, region = ?, city = ?, zip = ?, lat = ?, lon = ?,
+		timezone = ?, isp = ?, org = ?, asn = ?, provider = ?, fetched_at = NULL WHERE id = `
+	return r.execOne(ctx, query, l.IP, l.Country, l.Region, l.City, l.Zip, l.Lat, l.Lon, l.Timezone, l.ISP, l.Org, l.AS, l.Provider)
+}
+
+func (r *SQLiteRepository) Refresh(ctx context.Context, l models.IPLocation) error {
+	query := `UPDATE locations SET country = ?, region = ?, city = ?, zip = ?, lat = ?, lon = ?,
+		timezone = ?, isp = ?, org = ?, asn = ?, provider = ?, fetched_at = ? WHERE id = `
+	return r.execOne(ctx, query, l.IP, l.Country, l.Region, l.City, l.Zip, l.Lat, l.Lon, l.Timezone, l.ISP, l.Org, l.AS, l.Provider, time.Now().UnixNano())
+}
+
+func (r *SQLiteRepository) GetStale(ctx context.Context, before time.Time, limit int) ([]models.IPLocation, error) {
+	query := `SELECT ` + sqliteSelectColumns + ` FROM locations WHERE fetched_at < ? ORDER BY fetched_at LIMIT ?`
+	rows, err := r.db.QueryContext(ctx, query, before.UnixNano(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var locations []models.IPLocation
+	for rows.Next() {
+		location, err := scanSQLiteLocation(rows)
+		if err != nil {
+			return nil, err
+		}
+		locations = append(locations, location)
+	}
+	return locations, rows.Err()
+}
+
+func (r *SQLiteRepository) Delete(ctx context.Context, ip string) error {
+	return r.execOne(ctx, `DELETE FROM locations WHERE id = `, ip)
+}
+
+func (r *SQLiteRepository) DeleteNetwork(ctx context.Context, network string) error {
+	prefix, err := parseNetwork(models.IPLocation{Network: network})
+	if err != nil {
+		return err
+	}
+
+	res, err := r.db.ExecContext(ctx, `DELETE FROM locations WHERE network = ?`, prefix.String())
+	if err != nil {
+		return sqliteError(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return repositoryInterfaces.ErrNotFound
+	}
+	return nil
+}
+
+// execOne completes the statement with the most specific stored network containing ip and runs it,
+// ErrNotFound is returned when there is none
+func (r *SQLiteRepository) execOne(ctx context.Context, query string, ip string, args ...any) error {
+	addr, err := ipaddr.Parse(ip)
+	if err != nil {
+		return err
+	}
+
+	networks := containingNetworks(addr)
+	res, err := r.db.ExecContext(ctx, query+sqliteContaining(len(networks)), append(args, networks...)...)
+	if err != nil {
+		return sqliteError(err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return repositoryInterfaces.ErrNotFound
+	}
+	return nil
+}
+
+// Summarize counts the stored locations, IPv4 keys are a length byte and 4 address bytes
+func (r *SQLiteRepository) Summarize(ctx context.Context, staleBefore time.Time, topCountries int) (models.LocationSummary, error) {
+	var stale any
+	if !staleBefore.IsZero() {
+		stale = staleBefore.UnixNano()
+	}
+
+	var summary models.LocationSummary
+	var oldest, newest sql.NullInt64
+	query := `SELECT count(*), count(CASE WHEN length(ip_from) = 5 THEN 1 END), count(CASE WHEN fetched_at < ? THEN 1 END),
+		min(created_at), max(created_at) FROM locations`
+	if err := r.db.QueryRowContext(ctx, query, stale).Scan(&summary.Total, &summary.IPv4, &summary.Stale, &oldest, &newest); err != nil {
+		return models.LocationSummary{}, err
+	}
+	summary.IPv6 = summary.Total - summary.IPv4
+	if oldest.Valid {
+		first, last := time.Unix(0, oldest.Int64).UTC(), time.Unix(0, newest.Int64).UTC()
+		summary.Oldest, summary.Newest = &first, &last
+	}
+
+	var err error
+	if summary.Providers, err = queryCounts(ctx, r.db, `SELECT provider, count(*) FROM locations
+		GROUP BY provider ORDER BY count(*) DESC, provider`); err != nil {
+		return models.LocationSummary{}, err
+	}
+	if summary.Countries, err = queryCounts(ctx, r.db, `SELECT country, count(*) FROM locations
+		GROUP BY country ORDER BY count(*) DESC, country LIMIT ?`, topCountries); err != nil {
+		return models.LocationSummary{}, err
+	}
+	return summary, nil
+}
+
+// GetAll returns a page of locations matching the query, keyset-paginated like LocRepository.GetAll
+func (r *SQLiteRepository) GetAll(ctx context.Context, q models.LocationQuery) (models.LocationPage, error) {
+	columns, ok := sqliteSortColumns[q.Sort]
+	if !ok {
+		return models.LocationPage{}, fmt.Errorf("unknown sort field %q", q.Sort)
+	}
+
+	var where []string
+	var args []any
+	if q.Country != "" {
+		where, args = append(where, "country = ?"), append(args, q.Country)
+	}
+	if q.City != "" {
+		where, args = append(where, "city = ?"), append(args, q.City)
+	}
+	if q.Provider != "" {
+		where, args = append(where, "provider = ?"), append(args, q.Provider)
+	}
+	if q.Network != "" {
+		within, err := parseNetwork(models.IPLocation{Network: q.Network})
+		if err != nil {
+			return models.LocationPage{}, err
+		}
+		where = append(where, "ip_from >= ?", "ip_to <= ?")
+		args = append(args, addrKey(within.Addr()), addrKey(ipaddr.LastAddr(within)))
+	}
+	if !q.CreatedFrom.IsZero() {
+		where, args = append(where, "created_at >= ?"), append(args, q.CreatedFrom.UnixNano())
+	}
+	if !q.CreatedTo.IsZero() {
+		where, args = append(where, "created_at < ?"), append(args, q.CreatedTo.UnixNano())
+	}
+
+	filter := ""
+	if len(where) > 0 {
+		filter = " WHERE " + strings.Join(where, " AND ")
+	}
+
+	var page models.LocationPage
+	if !q.SkipTotal {
+		if err := r.db.QueryRowContext(ctx, `SELECT count(*) FROM locations`+filter, args...).Scan(&page.Total); err != nil {
+			return models.LocationPage{}, err
+		}
+	}
+
+	op, dir := ">", "ASC"
+	if q.Desc {
+		op, dir = "<", "DESC"
+	}
+
+	if q.Cursor != "" {
+		values, err := sqliteCursor(q)
+		if err != nil {
+			return models.LocationPage{}, repositoryInterfaces.ErrInvalidCursor
+		}
+		where = append(where, fmt.Sprintf("(%s, id) %s (%s)", strings.Join(columns, ", "), op, sqlitePlaceholders(len(values))))
+		args = append(args, values...)
+		filter = " WHERE " + strings.Join(where, " AND ")
+	}
+
+	order := make([]string, 0, len(columns)+1)
+	for _, column := range append(slices.Clone(columns), "id") {
+		order = append(order, column+" "+dir)
+	}
+
+	// one more row tells whether there is a next page
+	query := fmt.Sprintf(`SELECT id, %s FROM locations%s ORDER BY %s LIMIT ?`, sqliteSelectColumns, filter, strings.Join(order, ", "))
+	rows, err := r.db.QueryContext(ctx, query, append(args, q.Limit+1)...)
+	if err != nil {
+		return models.LocationPage{}, err
+	}
+	defer rows.Close()
+
+	var last int64
+	for rows.Next() {
+		if len(page.Locations) == q.Limit {
+			page.NextCursor = encodeCursor(cursor{Sort: q.Sort, Desc: q.Desc, Value: sortValue(q.Sort, page.Locations[q.Limit-1]), ID: fmt.Sprint(last)})
+			break
+		}
+
+		var id int64
+		location, err := scanSQLiteLocation(rows, &id)
+		if err != nil {
+			return models.LocationPage{}, err
+		}
+		page.Locations = append(page.Locations, location)
+		last = id
+	}
+
+	return page, rows.Err()
+}
+
+// sqliteCursor decodes the cursor of the query into values of the sort columns and id
+func sqliteCursor(q models.LocationQuery) ([]any, error) {
+	c, err := decodeCursor(q.Cursor)
+	if err != nil || c.Sort != q.Sort || c.Desc != q.Desc {
+		return nil, repositoryInterfaces.ErrInvalidCursor
+	}
+
+	var id int64
+	if _, err := fmt.Sscan(c.ID, &id); err != nil {
+		return nil, err
+	}
+
+	switch q.Sort {
+	case models.SortCountry, models.SortCity:
+		return []any{c.Value, id}, nil
+	case models.SortNetwork:
+		network, err := netip.ParsePrefix(c.Value)
+		if err != nil {
+			return nil, err
+		}
+		return []any{addrKey(network.Addr()), network.Bits(), id}, nil
+	default:
+		createdAt, err := time.Parse(time.RFC3339Nano, c.Value)
+		if err != nil {
+			return nil, err
+		}
+		return []any{createdAt.UnixNano(), id}, nil
+	}
+}
+
+// sqliteError translates SQLite errors with a meaning for callers into repository errors
+func sqliteError(err error) error {
+	var sqliteErr *sqlite.Error
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return repositoryInterfaces.ErrNotFound
+	case errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE:
+		return fmt.Errorf("%w: %s", repositoryInterfaces.ErrConflict, sqliteErr.Error())
+	default:
+		return err
+	}
+}
+
+// SQLiteRangeRepository keeps the imported range database in the ip_ranges table of SQLite
+type SQLiteRangeRepository struct {
+	db *sql.DB
+}
+
+func NewSQLiteRangeRepository(db *sql.DB) *SQLiteRangeRepository {
+	return &SQLiteRangeRepository{db: db}
+}
+
+// FindRange returns the range containing ip, the one with the closest start not after ip
+func (r *SQLiteRangeRepository) FindRange(ctx context.Context, ip string) (models.IPRange, error) {
+	addr, err := ipaddr.Parse(ip)
+	if err != nil {
+		return models.IPRange{}, err
+	}
+
+	query := `SELECT ip_from, ip_to, country, region, city, zip, lat, lon, timezone FROM (
+			SELECT * FROM ip_ranges WHERE ip_from <= ?1 ORDER BY ip_from DESC LIMIT 1
+		) WHERE ip_to >= ?1`
+
+	var rng models.IPRange
+	var from, to []byte
+	err = r.db.QueryRowContext(ctx, query, addrKey(addr)).Scan(&from, &to, &rng.Country, &rng.Region, &rng.City, &rng.Zip, &rng.Lat, &rng.Lon, &rng.Timezone)
+	if err != nil {
+		return models.IPRange{}, sqliteError(err)
+	}
+
+	if rng.From, err = addrFromKey(from); err != nil {
+		return models.IPRange{}, err
+	}
+	if rng.To, err = addrFromKey(to); err != nil {
+		return models.IPRange{}, err
+	}
+	return rng, nil
+}
+
+// ReplaceRanges loads the ranges into a staging table in batches and swaps it with the active one
+// in a short transaction. Readers of the WAL database see the old dataset until the swap commits,
+// and a failed import drops the staging table leaving the active dataset as it was
+func (r *SQLiteRangeRepository) ReplaceRanges(ctx context.Context, ranges repositoryInterfaces.RangeReader) (int64, error) {
+	if _, err := r.db.ExecContext(ctx, `DROP TABLE IF EXISTS ip_ranges_import`); err != nil {
+		return 0, err
+	}
+	if _, err := r.db.ExecContext(ctx, `CREATE TABLE ip_ranges_import (`+sqliteRangeColumns+`) WITHOUT ROWID`); err != nil {
+		return 0, err
+	}
+
+	rows, err := r.loadRanges(ctx, ranges)
+	if err != nil {
+		// the request context may be the reason of the failure
+		r.db.ExecContext(context.WithoutCancel(ctx), `DROP TABLE IF EXISTS ip_ranges_import`)
+		return 0, err
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	for _, query := range []string{`DROP TABLE ip_ranges`, `ALTER TABLE ip_ranges_import RENAME TO ip_ranges`} {
+		if _, err := tx.ExecContext(ctx, query); err != nil {
+			return 0, err
+		}
+	}
+	return rows, tx.Commit()
+}
+
+// sqliteRangeColumns defines the ip_ranges table, ranges don't overlap so the start address is the key
+const sqliteRangeColumns = `ip_from BLOB NOT NULL PRIMARY KEY,
+	ip_to BLOB NOT NULL,
+	country TEXT NOT NULL DEFAULT '',
+	region TEXT NOT NULL DEFAULT '',
+	city TEXT NOT NULL DEFAULT '',
+	zip TEXT NOT NULL DEFAULT '',
+	lat REAL NOT NULL DEFAULT 0,
+	lon REAL NOT NULL DEFAULT 0,
+	timezone TEXT NOT NULL DEFAULT ''`
+
+// loadRanges inserts the ranges into the staging table committing every sqliteImportBatch rows
+func (r *SQLiteRangeRepository) loadRanges(ctx context.Context, ranges repositoryInterfaces.RangeReader) (int64, error) {
+	var rows int64
+	for {
+		n, err := r.loadBatch(ctx, ranges)
+		rows += n
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// loadBatch inserts up to sqliteImportBatch ranges in one transaction, io.EOF is returned
+// with the last batch
+func (r *SQLiteRangeRepository) loadBatch(ctx context.Context, ranges repositoryInterfaces.RangeReader) (int64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO ip_ranges_import (ip_from, ip_to, country, region, city, zip, lat, lon, timezone)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	var rows int64
+	var readErr error
+	for rows < sqliteImportBatch {
+		rng, err := ranges.Next()
+		if err == io.EOF {
+			readErr = err
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		if _, err := stmt.ExecContext(ctx, addrKey(rng.From), addrKey(rng.To), rng.Country, rng.Region, rng.City, rng.Zip, rng.Lat, rng.Lon, rng.Timezone); err != nil {
+			return 0, fmt.Errorf("insert range %s-%s: %w", rng.From, rng.To, err)
+		}
+		rows++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return rows, readErr
+}
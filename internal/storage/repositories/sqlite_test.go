@@ -0,0 +1,163 @@
+package repositories_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/Fyefhqdishka/LocFinder/internal/config"
+	"github.com/Fyefhqdishka/LocFinder/internal/importer"
+	"github.com/Fyefhqdishka/LocFinder/internal/models"
+	"github.com/Fyefhqdishka/LocFinder/internal/storage"
+	"github.com/Fyefhqdishka/LocFinder/internal/storage/repositories"
+	"github.com/Fyefhqdishka/LocFinder/internal/storage/repositoryInterfaces"
+	"github.com/stretchr/testify/assert"
+	"net/netip"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func openSQLite(t *testing.T) *sql.DB {
+	db, err := storage.ConnectSQLite(filepath.Join(t.TempDir(), "locfinder.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := storage.Migrate(context.Background(), db, config.DBDriverSQLite); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestSQLiteRepository(t *testing.T) {
+	ctx := context.Background()
+	repo := repositories.NewSQLiteRepository(openSQLite(t))
+
+	assert.NoError(t, repo.Save(ctx, models.IPLocation{Network: "37.99.0.0/16", Country: "Kazakhstan"}))
+	assert.NoError(t, repo.Save(ctx, models.IPLocation{Network: "37.99.42.0/24", City: "Almaty"}))
+	assert.NoError(t, repo.Create(ctx, models.IPLocation{IP: "2a00:1450::1", City: "Dublin"}))
+
+	location, err := repo.GetByIP(ctx, "37.99.42.212")
+	assert.NoError(t, err)
+	assert.Equal(t, "Almaty", location.City)
+	assert.Equal(t, "37.99.42.0/24", location.Network)
+
+	// the closest stored start 37.99.42.0 doesn't contain the IP, the enclosing network does
+	location, err = repo.GetByIP(ctx, "37.99.43.1")
+	assert.NoError(t, err)
+	assert.Equal(t, "37.99.0.0/16", location.Network)
+
+	location, err = repo.GetByIP(ctx, "2a00:1450::1")
+	assert.NoError(t, err)
+	assert.Equal(t, "Dublin", location.City)
+	assert.Nil(t, location.FetchedAt)
+
+	locations, err := repo.GetByIPs(ctx, []string{"37.99.43.1", "8.8.8.8", "37.99.42.212", "bad", "2a00:1450::1"})
+	assert.NoError(t, err)
+	if assert.Len(t, locations, 3) {
+		assert.Equal(t, "37.99.43.1", locations[0].IP)
+		assert.Equal(t, "37.99.0.0/16", locations[0].Network)
+		assert.Equal(t, "37.99.42.0/24", locations[1].Network)
+		assert.Equal(t, "Dublin", locations[2].City)
+	}
+
+	assert.ErrorIs(t, repo.Create(ctx, models.IPLocation{Network: "37.99.0.0/16"}), repositoryInterfaces.ErrConflict)
+	assert.ErrorIs(t, repo.Delete(ctx, "8.8.8.8"), repositoryInterfaces.ErrNotFound)
+
+	page, err := repo.GetAll(ctx, models.LocationQuery{Sort: models.SortNetwork, Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), page.Total)
+	assert.Equal(t, "37.99.0.0/16", page.Locations[0].Network)
+	assert.Equal(t, "37.99.42.0/24", page.Locations[1].Network)
+
+	page, err = repo.GetAll(ctx, models.LocationQuery{Sort: models.SortNetwork, Limit: 2, Cursor: page.NextCursor})
+	assert.NoError(t, err)
+	assert.Len(t, page.Locations, 1)
+	assert.Equal(t, "2a00:1450::1/128", page.Locations[0].Network)
+	assert.Empty(t, page.NextCursor)
+}
+
+func TestSQLiteRangeRepository(t *testing.T) {
+	ctx := context.Background()
+	repo := repositories.NewSQLiteRangeRepository(openSQLite(t))
+
+	_, err := repo.ReplaceRanges(ctx, &rangeSlice{
+		{From: netip.MustParseAddr("1.0.0.0"), To: netip.MustParseAddr("1.0.0.255"), City: "Sydney"},
+		{From: netip.MustParseAddr("2a00:1450::"), To: netip.MustParseAddr("2a00:1450:ffff:ffff:ffff:ffff:ffff:ffff"), City: "Dublin"},
+	})
+	assert.NoError(t, err)
+
+	rng, err := repo.FindRange(ctx, "2a00:1450::1")
+	assert.NoError(t, err)
+	assert.Equal(t, "Dublin", rng.City)
+	assert.Equal(t, netip.MustParseAddr("2a00:1450::"), rng.From)
+
+	_, err = repo.FindRange(ctx, "1.0.1.0")
+	assert.ErrorIs(t, err, repositoryInterfaces.ErrNotFound)
+}
+
+// failingRanges returns an error after its ranges are read
+type failingRanges struct {
+	rangeSlice
+}
+
+func (f *failingRanges) Next() (models.IPRange, error) {
+	if len(f.rangeSlice) == 0 {
+		return models.IPRange{}, errors.New("broken file")
+	}
+	return f.rangeSlice.Next()
+}
+
+func TestSQLiteRangeRepositoryFailedImport(t *testing.T) {
+	ctx := context.Background()
+	repo := repositories.NewSQLiteRangeRepository(openSQLite(t))
+
+	_, err := repo.ReplaceRanges(ctx, &rangeSlice{
+		{From: netip.MustParseAddr("1.0.0.0"), To: netip.MustParseAddr("1.0.0.255"), City: "Sydney"},
+	})
+	assert.NoError(t, err)
+
+	_, err = repo.ReplaceRanges(ctx, &failingRanges{rangeSlice{
+		{From: netip.MustParseAddr("1.0.0.0"), To: netip.MustParseAddr("1.0.0.127"), City: "Melbourne"},
+	}})
+	assert.Error(t, err)
+
+	rng, err := repo.FindRange(ctx, "1.0.0.200")
+	assert.NoError(t, err)
+	assert.Equal(t, "Sydney", rng.City)
+
+	n, err := repo.ReplaceRanges(ctx, &rangeSlice{
+		{From: netip.MustParseAddr("1.0.0.0"), To: netip.MustParseAddr("1.0.0.127"), City: "Melbourne"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+
+	_, err = repo.FindRange(ctx, "1.0.0.200")
+	assert.ErrorIs(t, err, repositoryInterfaces.ErrNotFound)
+}
+
+func TestSQLiteRangeRepositoryIP2LocationFiles(t *testing.T) {
+	ipv4 := `"0","16777215","-","-","-","-","0.000000","0.000000","-","-"
+"16777216","16777471","AU","Australia","Queensland","South Brisbane","-27.476080","153.016880","4101","+10:00"
+`
+	ipv6 := `"281470698520576","281470698520831","AU","Australia","Queensland","South Brisbane","-27.476080","153.016880","4101","+10:00"
+"55827987809411540836515382960316219392","55827987809411540836515382960316284927","DE","Germany","Hessen","Frankfurt am Main","50.115520","8.684170","60306","+01:00"
+`
+
+	ctx := context.Background()
+	repo := repositories.NewSQLiteRangeRepository(openSQLite(t))
+
+	// the IPv6 file repeats the ranges of the IPv4 one, they are stored once
+	n, err := repo.ReplaceRanges(ctx, importer.NewIP2LocationReader(strings.NewReader(ipv4), strings.NewReader(ipv6)))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+
+	rng, err := repo.FindRange(ctx, "1.0.0.1")
+	assert.NoError(t, err)
+	assert.Equal(t, "South Brisbane", rng.City)
+
+	rng, err = repo.FindRange(ctx, "2a00:1450::1")
+	assert.NoError(t, err)
+	assert.Equal(t, "Frankfurt am Main", rng.City)
+}
@@ -0,0 +1,19 @@
+package repositoryInterfaces
+
+import (
+	"context"
+	"github.com/Fyefhqdishka/LocFinder/internal/models"
+)
+
+// RangeStorage keeps an imported range database, importing replaces the whole dataset at once
+type RangeStorage interface {
+	// FindRange returns the range containing ip or ErrNotFound
+	FindRange(ctx context.Context, ip string) (models.IPRange, error)
+	// ReplaceRanges loads all ranges of the reader and makes them the active dataset
+	ReplaceRanges(ctx context.Context, ranges RangeReader) (int64, error)
+}
+
+// RangeReader streams ranges of a dataset, Next returns io.EOF after the last one
+type RangeReader interface {
+	Next() (models.IPRange, error)
+}
@@ -1,11 +1,43 @@
 package repositoryInterfaces
 
-import "github.com/Fyefhqdishka/LocFinder/internal/models"
+import (
+	"context"
+	"errors"
+	"github.com/Fyefhqdishka/LocFinder/internal/models"
+	"time"
+)
 
+// Errors returned by Storage implementations instead of driver errors
+var (
+	// ErrNotFound is returned when no stored network contains the IP
+	ErrNotFound = errors.New("location not found")
+	// ErrConflict is returned when the network is already stored
+	ErrConflict = errors.New("location already exists")
+	// ErrInvalidCursor is returned when a page cursor is malformed or belongs to another sort order
+	ErrInvalidCursor = errors.New("invalid page cursor")
+)
+
+// Storage keeps locations of networks. Lookups and changes by IP address apply
+// to the most specific stored network containing it, Save, Create and Replace store location.Network.
+// Locations written by Create, Replace and Update are manual: they have no fetched_at and are never stale
 type Storage interface {
-	GetByIP(ip string) (string, string, error)
-	Save(ip, country, city string) error
-	Update(ip, country, city string) error
-	Delete(ip string) error
-	GetAll() ([]models.IPLocation, error)
+	GetByIP(ctx context.Context, ip string) (models.IPLocation, error)
+	GetByIPs(ctx context.Context, ips []string) ([]models.IPLocation, error)
+	Save(ctx context.Context, location models.IPLocation) error
+	// Create fails with ErrConflict when the network is already stored
+	Create(ctx context.Context, location models.IPLocation) error
+	// Replace creates the network or replaces its data and reports whether it was created
+	Replace(ctx context.Context, location models.IPLocation) (bool, error)
+	Update(ctx context.Context, location models.IPLocation) error
+	Refresh(ctx context.Context, location models.IPLocation) error
+	// GetStale returns up to limit locations fetched before the given time, the oldest first.
+	// A network doesn't keep the IP it was looked up by, the IP of a location is its first address
+	GetStale(ctx context.Context, before time.Time, limit int) ([]models.IPLocation, error)
+	Delete(ctx context.Context, ip string) error
+	// DeleteNetwork removes the stored network itself, networks nested in it are kept
+	DeleteNetwork(ctx context.Context, network string) error
+	GetAll(ctx context.Context, query models.LocationQuery) (models.LocationPage, error)
+	// Summarize counts the stored locations with aggregate queries. Locations fetched before
+	// staleBefore are stale, zero counts none. Countries holds the topCountries most frequent ones
+	Summarize(ctx context.Context, staleBefore time.Time, topCountries int) (models.LocationSummary, error)
 }
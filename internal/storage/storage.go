@@ -1,37 +1,160 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
+	"github.com/Fyefhqdishka/LocFinder/internal/config"
+	"github.com/Fyefhqdishka/LocFinder/internal/storage/repositories"
+	"github.com/Fyefhqdishka/LocFinder/internal/storage/repositoryInterfaces"
+	"github.com/Fyefhqdishka/LocFinder/migrations"
 	_ "github.com/lib/pq"
 	"github.com/pressly/goose/v3"
+	"github.com/pressly/goose/v3/lock"
+	"log/slog"
+	_ "modernc.org/sqlite"
 )
 
-func ConnectDB(connStr string) (*sql.DB, error) {
-	db, err := sql.Open("postgres", connStr)
+// Backend holds the repositories of the configured database driver
+type Backend struct {
+	Locations repositoryInterfaces.Storage
+	Ranges    repositoryInterfaces.RangeStorage
+	db        *sql.DB
+}
+
+// Open connects to the database selected by cfg.Driver and applies its migrations,
+// with cfg.AutoMigrate off pending migrations are only reported
+func Open(cfg config.DB, log *slog.Logger) (*Backend, error) {
+	if cfg.Driver == config.DBDriverMemory {
+		return &Backend{
+			Locations: repositories.NewMemoryRepository(),
+			Ranges:    repositories.NewMemoryRangeRepository(),
+		}, nil
+	}
+
+	db, err := OpenDB(cfg)
 	if err != nil {
 		return nil, err
 	}
+	if err := prepareSchema(context.Background(), db, cfg, log); err != nil {
+		db.Close()
+		return nil, err
+	}
 
-	migrationsDir := "./migrations"
+	if cfg.Driver == config.DBDriverSQLite {
+		return &Backend{
+			Locations: repositories.NewSQLiteRepository(db),
+			Ranges:    repositories.NewSQLiteRangeRepository(db),
+			db:        db,
+		}, nil
+	}
+	return &Backend{
+		Locations: repositories.NewLocRepository(db, log),
+		Ranges:    repositories.NewRangeRepository(db),
+		db:        db,
+	}, nil
+}
 
-	if err = initMigrations(db, migrationsDir); err != nil {
-		return nil, err
+// Close closes the database connection, the memory backend has none
+func (b *Backend) Close() error {
+	if b.db == nil {
+		return nil
 	}
+	return b.db.Close()
+}
+
+// OpenDB connects to the SQL database selected by cfg.Driver without migrating it
+func OpenDB(cfg config.DB) (*sql.DB, error) {
+	switch cfg.Driver {
+	case config.DBDriverSQLite:
+		return ConnectSQLite(cfg.Path)
+	case config.DBDriverPostgres, "":
+		db, err := ConnectDB(cfg.ConnString())
+		if err != nil {
+			return nil, err
+		}
+		if err := db.Ping(); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to ping database: %v", err)
+		}
+		return db, nil
+	case config.DBDriverMemory:
+		return nil, fmt.Errorf("the memory driver has no database")
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", cfg.Driver)
+	}
+}
+
+func ConnectDB(connStr string) (*sql.DB, error) {
+	return sql.Open("postgres", connStr)
+}
 
+// sqliteMaxConns bounds the SQLite pool, in WAL mode readers run concurrently with the writer
+const sqliteMaxConns = 8
+
+// ConnectSQLite opens the SQLite database file in WAL mode, creating it when missing. SQLite allows
+// a single writer, so transactions take the write lock when they begin and wait on a locked
+// database instead of failing when a read inside them would need upgrading
+func ConnectSQLite(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate")
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(sqliteMaxConns)
 	return db, nil
 }
 
-// Initializing database migration
-func initMigrations(db *sql.DB, dir string) error {
-	err := goose.SetDialect("postgres")
+// NewMigrator returns the migration provider of the embedded migrations of driver. Postgres
+// migrations hold an advisory lock, so instances started together don't apply them twice
+func NewMigrator(db *sql.DB, driver string) (*goose.Provider, error) {
+	switch driver {
+	case config.DBDriverSQLite:
+		return goose.NewProvider(goose.DialectSQLite3, db, migrations.SQLite())
+	case config.DBDriverPostgres, "":
+		locker, err := lock.NewPostgresSessionLocker()
+		if err != nil {
+			return nil, err
+		}
+		return goose.NewProvider(goose.DialectPostgres, db, migrations.Postgres(), goose.WithSessionLocker(locker))
+	default:
+		return nil, fmt.Errorf("the %q driver has no migrations", driver)
+	}
+}
+
+// Migrate applies all pending migrations of driver
+func Migrate(ctx context.Context, db *sql.DB, driver string) error {
+	migrator, err := NewMigrator(db, driver)
 	if err != nil {
 		return err
 	}
+	_, err = migrator.Up(ctx)
+	return err
+}
 
-	err = goose.Up(db, dir)
+// prepareSchema migrates the database or, when auto-migrate is off, warns about pending migrations
+func prepareSchema(ctx context.Context, db *sql.DB, cfg config.DB, log *slog.Logger) error {
+	migrator, err := NewMigrator(db, cfg.Driver)
 	if err != nil {
 		return err
 	}
 
+	if !cfg.AutoMigrate {
+		pending, err := migrator.HasPending(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to check migrations: %v", err)
+		}
+		if pending {
+			log.Warn("Есть непримененные миграции базы данных, выполните app migrate up", "driver", cfg.Driver)
+		}
+		return nil
+	}
+
+	results, err := migrator.Up(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to apply migrations: %v", err)
+	}
+	for _, result := range results {
+		log.Info("Применена миграция", "version", result.Source.Version, "duration", result.Duration)
+	}
 	return nil
 }
@@ -0,0 +1,71 @@
+package storage_test
+
+import (
+	"context"
+	"github.com/Fyefhqdishka/LocFinder/internal/config"
+	"github.com/Fyefhqdishka/LocFinder/internal/models"
+	"github.com/Fyefhqdishka/LocFinder/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenAppliesEmbeddedMigrations(t *testing.T) {
+	ctx := context.Background()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := config.DB{Driver: config.DBDriverSQLite, Path: filepath.Join(t.TempDir(), "locfinder.db"), AutoMigrate: true}
+
+	backend, err := storage.Open(cfg, log)
+	require.NoError(t, err)
+	defer backend.Close()
+
+	require.NoError(t, backend.Locations.Save(ctx, models.IPLocation{IP: "8.8.8.8", Country: "United States"}))
+}
+
+func TestOpenWithoutAutoMigrate(t *testing.T) {
+	ctx := context.Background()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := config.DB{Driver: config.DBDriverSQLite, Path: filepath.Join(t.TempDir(), "locfinder.db")}
+
+	backend, err := storage.Open(cfg, log)
+	require.NoError(t, err)
+	defer backend.Close()
+
+	// the schema is left to the migrate command
+	assert.Error(t, backend.Locations.Save(ctx, models.IPLocation{IP: "8.8.8.8"}))
+}
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+	db, err := storage.ConnectSQLite(filepath.Join(t.TempDir(), "locfinder.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	migrator, err := storage.NewMigrator(db, config.DBDriverSQLite)
+	require.NoError(t, err)
+	sources := migrator.ListSources()
+	require.NotEmpty(t, sources)
+	latest := sources[len(sources)-1].Version
+
+	_, err = migrator.Up(ctx)
+	require.NoError(t, err)
+	version, err := migrator.GetDBVersion(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, latest, version)
+
+	pending, err := migrator.HasPending(ctx)
+	require.NoError(t, err)
+	assert.False(t, pending)
+
+	_, err = migrator.DownTo(ctx, 0)
+	require.NoError(t, err)
+	version, err = migrator.GetDBVersion(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, version)
+
+	_, err = storage.NewMigrator(db, config.DBDriverMemory)
+	assert.Error(t, err)
+}
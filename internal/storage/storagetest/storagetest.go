@@ -0,0 +1,546 @@
+// Package storagetest is a conformance suite for repositoryInterfaces.Storage implementations
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Fyefhqdishka/LocFinder/internal/models"
+	"github.com/Fyefhqdishka/LocFinder/internal/storage/repositoryInterfaces"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Factory returns an empty storage, it's called for every test of the suite
+type Factory func(t *testing.T) repositoryInterfaces.Storage
+
+// Run runs the conformance suite against storages returned by newStorage
+func Run(t *testing.T, newStorage Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s repositoryInterfaces.Storage)
+	}{
+		{"SaveAndGet", testSaveAndGet},
+		{"MostSpecificNetwork", testMostSpecificNetwork},
+		{"GetByIPs", testGetByIPs},
+		{"UpdateAndRefresh", testUpdateAndRefresh},
+		{"Delete", testDelete},
+		{"DeleteNetwork", testDeleteNetwork},
+		{"NotFound", testNotFound},
+		{"SaveReplacesNetwork", testSaveReplacesNetwork},
+		{"CreateConflict", testCreateConflict},
+		{"Replace", testReplace},
+		{"GetStale", testGetStale},
+		{"ConcurrentSave", testConcurrentSave},
+		{"ConcurrentCreate", testConcurrentCreate},
+		{"ListOrder", testListOrder},
+		{"ListPages", testListPages},
+		{"ListSkipTotal", testListSkipTotal},
+		{"ListFilters", testListFilters},
+		{"ListInvalidCursor", testListInvalidCursor},
+		{"Summarize", testSummarize},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newStorage(t))
+		})
+	}
+}
+
+func location(network, country, city string) models.IPLocation {
+	return models.IPLocation{
+		Network:  network,
+		Country:  country,
+		Region:   "Region of " + city,
+		City:     city,
+		Zip:      "050000",
+		Lat:      43.25,
+		Lon:      76.95,
+		Timezone: "Asia/Almaty",
+		ISP:      "ISP",
+		Org:      "Org",
+		AS:       "AS9198",
+		Provider: "ipapi",
+	}
+}
+
+// data clears the fields a storage fills itself
+func data(l models.IPLocation) models.IPLocation {
+	l.IP, l.Network, l.CreatedAt, l.FetchedAt = "", "", nil, nil
+	return l
+}
+
+func testSaveAndGet(t *testing.T, s repositoryInterfaces.Storage) {
+	ctx := context.Background()
+	want := location("37.99.42.0/24", "Kazakhstan", "Almaty")
+	require.NoError(t, s.Save(ctx, want))
+
+	got, err := s.GetByIP(ctx, "37.99.42.212")
+	require.NoError(t, err)
+	assert.Equal(t, "37.99.42.212", got.IP)
+	assert.Equal(t, "37.99.42.0/24", got.Network)
+	assert.Equal(t, data(want), data(got))
+	assert.NotNil(t, got.CreatedAt)
+	assert.NotNil(t, got.FetchedAt)
+
+	// without a network the single IP is stored
+	require.NoError(t, s.Save(ctx, models.IPLocation{IP: "2a00:1450::1", City: "Dublin"}))
+	got, err = s.GetByIP(ctx, "2a00:1450::1")
+	require.NoError(t, err)
+	assert.Equal(t, "2a00:1450::1/128", got.Network)
+	assert.Equal(t, "Dublin", got.City)
+}
+
+func testMostSpecificNetwork(t *testing.T, s repositoryInterfaces.Storage) {
+	ctx := context.Background()
+	require.NoError(t, s.Save(ctx, location("37.99.0.0/16", "Kazakhstan", "")))
+	require.NoError(t, s.Save(ctx, location("37.99.42.0/24", "Kazakhstan", "Almaty")))
+	require.NoError(t, s.Save(ctx, location("2a00:1450::/32", "Ireland", "Dublin")))
+
+	tests := []struct {
+		ip      string
+		network string
+	}{
+		{"37.99.42.212", "37.99.42.0/24"},
+		{"37.99.43.1", "37.99.0.0/16"},
+		{"2a00:1450:4001::1", "2a00:1450::/32"},
+	}
+	for _, tt := range tests {
+		got, err := s.GetByIP(ctx, tt.ip)
+		require.NoError(t, err, tt.ip)
+		assert.Equal(t, tt.network, got.Network, tt.ip)
+	}
+
+	// changes by IP apply to the most specific network only
+	require.NoError(t, s.Delete(ctx, "37.99.42.212"))
+	got, err := s.GetByIP(ctx, "37.99.42.212")
+	require.NoError(t, err)
+	assert.Equal(t, "37.99.0.0/16", got.Network)
+}
+
+func testGetByIPs(t *testing.T, s repositoryInterfaces.Storage) {
+	ctx := context.Background()
+	require.NoError(t, s.Save(ctx, location("37.99.42.0/24", "Kazakhstan", "Almaty")))
+	require.NoError(t, s.Save(ctx, location("8.8.8.0/24", "United States", "Ashburn")))
+
+	got, err := s.GetByIPs(ctx, []string{"37.99.42.1", "1.1.1.1", "8.8.8.8", "37.99.42.2"})
+	require.NoError(t, err)
+
+	cities := make(map[string]string)
+	for _, l := range got {
+		cities[l.IP] = l.City
+	}
+	assert.Equal(t, map[string]string{"37.99.42.1": "Almaty", "37.99.42.2": "Almaty", "8.8.8.8": "Ashburn"}, cities)
+}
+
+func testUpdateAndRefresh(t *testing.T, s repositoryInterfaces.Storage) {
+	ctx := context.Background()
+	require.NoError(t, s.Save(ctx, location("37.99.42.0/24", "Kazakhstan", "Almaty")))
+	saved, err := s.GetByIP(ctx, "37.99.42.1")
+	require.NoError(t, err)
+
+	updated := location("", "Kazakhstan", "Astana")
+	updated.IP, updated.Provider = "37.99.42.1", "manual"
+	require.NoError(t, s.Update(ctx, updated))
+
+	got, err := s.GetByIP(ctx, "37.99.42.200")
+	require.NoError(t, err)
+	assert.Equal(t, data(updated), data(got))
+	assert.Equal(t, "37.99.42.0/24", got.Network)
+	assert.Equal(t, saved.CreatedAt.UTC(), got.CreatedAt.UTC())
+	assert.Nil(t, got.FetchedAt, "updated locations are manual")
+
+	refreshed := location("", "Kazakhstan", "Shymkent")
+	refreshed.IP = "37.99.42.1"
+	require.NoError(t, s.Refresh(ctx, refreshed))
+
+	got, err = s.GetByIP(ctx, "37.99.42.1")
+	require.NoError(t, err)
+	assert.Equal(t, data(refreshed), data(got))
+	assert.NotNil(t, got.FetchedAt)
+}
+
+func testDelete(t *testing.T, s repositoryInterfaces.Storage) {
+	ctx := context.Background()
+	require.NoError(t, s.Save(ctx, location("37.99.42.0/24", "Kazakhstan", "Almaty")))
+	require.NoError(t, s.Delete(ctx, "37.99.42.7"))
+
+	_, err := s.GetByIP(ctx, "37.99.42.7")
+	assert.ErrorIs(t, err, repositoryInterfaces.ErrNotFound)
+	assert.ErrorIs(t, s.Delete(ctx, "37.99.42.7"), repositoryInterfaces.ErrNotFound)
+}
+
+func testDeleteNetwork(t *testing.T, s repositoryInterfaces.Storage) {
+	ctx := context.Background()
+	require.NoError(t, s.Save(ctx, location("37.99.0.0/16", "Kazakhstan", "")))
+	require.NoError(t, s.Save(ctx, location("37.99.0.0/24", "Kazakhstan", "Almaty")))
+	require.NoError(t, s.DeleteNetwork(ctx, "37.99.0.0/16"))
+
+	// the nested network starting at the same address is kept
+	got, err := s.GetByIP(ctx, "37.99.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, "37.99.0.0/24", got.Network)
+	_, err = s.GetByIP(ctx, "37.99.1.1")
+	assert.ErrorIs(t, err, repositoryInterfaces.ErrNotFound)
+
+	assert.ErrorIs(t, s.DeleteNetwork(ctx, "37.99.0.0/16"), repositoryInterfaces.ErrNotFound)
+}
+
+func testNotFound(t *testing.T, s repositoryInterfaces.Storage) {
+	ctx := context.Background()
+	require.NoError(t, s.Save(ctx, location("37.99.42.0/24", "Kazakhstan", "Almaty")))
+
+	missing := location("", "United States", "Ashburn")
+	missing.IP = "8.8.8.8"
+
+	_, err := s.GetByIP(ctx, "8.8.8.8")
+	assert.ErrorIs(t, err, repositoryInterfaces.ErrNotFound)
+	assert.ErrorIs(t, s.Update(ctx, missing), repositoryInterfaces.ErrNotFound)
+	assert.ErrorIs(t, s.Refresh(ctx, missing), repositoryInterfaces.ErrNotFound)
+	assert.ErrorIs(t, s.Delete(ctx, "8.8.8.8"), repositoryInterfaces.ErrNotFound)
+
+	got, err := s.GetByIPs(ctx, []string{"8.8.8.8"})
+	require.NoError(t, err)
+	assert.Empty(t, got)
+}
+
+func testSaveReplacesNetwork(t *testing.T, s repositoryInterfaces.Storage) {
+	ctx := context.Background()
+	require.NoError(t, s.Save(ctx, location("37.99.42.0/24", "Kazakhstan", "Almaty")))
+	first, err := s.GetByIP(ctx, "37.99.42.1")
+	require.NoError(t, err)
+
+	require.NoError(t, s.Save(ctx, location("37.99.42.0/24", "Kazakhstan", "Astana")))
+	got, err := s.GetByIP(ctx, "37.99.42.1")
+	require.NoError(t, err)
+	assert.Equal(t, "Astana", got.City)
+	assert.Equal(t, first.CreatedAt.UTC(), got.CreatedAt.UTC())
+
+	page, err := s.GetAll(ctx, models.LocationQuery{Sort: models.SortCreatedAt, Limit: 10})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), page.Total)
+}
+
+func testCreateConflict(t *testing.T, s repositoryInterfaces.Storage) {
+	ctx := context.Background()
+	manual := location("37.99.42.0/24", "Kazakhstan", "Almaty")
+	require.NoError(t, s.Create(ctx, manual))
+
+	got, err := s.GetByIP(ctx, "37.99.42.1")
+	require.NoError(t, err)
+	assert.Equal(t, data(manual), data(got))
+	assert.Nil(t, got.FetchedAt, "created locations are manual")
+
+	assert.ErrorIs(t, s.Create(ctx, location("37.99.42.0/24", "Kazakhstan", "Astana")), repositoryInterfaces.ErrConflict)
+
+	// a more specific network isn't a duplicate
+	require.NoError(t, s.Create(ctx, models.IPLocation{IP: "37.99.42.1", City: "Astana"}))
+	got, err = s.GetByIP(ctx, "37.99.42.1")
+	require.NoError(t, err)
+	assert.Equal(t, "Astana", got.City)
+}
+
+func testReplace(t *testing.T, s repositoryInterfaces.Storage) {
+	ctx := context.Background()
+	require.NoError(t, s.Save(ctx, location("37.99.42.0/24", "Kazakhstan", "Almaty")))
+
+	created, err := s.Replace(ctx, location("37.99.42.0/24", "Kazakhstan", "Astana"))
+	require.NoError(t, err)
+	assert.False(t, created)
+
+	got, err := s.GetByIP(ctx, "37.99.42.1")
+	require.NoError(t, err)
+	assert.Equal(t, "Astana", got.City)
+	assert.Nil(t, got.FetchedAt, "replaced locations are manual")
+
+	created, err = s.Replace(ctx, models.IPLocation{IP: "8.8.8.8", City: "Ashburn"})
+	require.NoError(t, err)
+	assert.True(t, created)
+}
+
+func testGetStale(t *testing.T, s repositoryInterfaces.Storage) {
+	ctx := context.Background()
+	for i := range 3 {
+		require.NoError(t, s.Save(ctx, location(fmt.Sprintf("10.0.%d.0/24", i), "", fmt.Sprint(i))))
+		time.Sleep(5 * time.Millisecond)
+	}
+	require.NoError(t, s.Create(ctx, location("10.0.9.0/24", "", "manual")))
+
+	stale, err := s.GetStale(ctx, time.Now().Add(time.Hour), 2)
+	require.NoError(t, err)
+	require.Len(t, stale, 2)
+	assert.Equal(t, "10.0.0.0/24", stale[0].Network, "the oldest come first")
+	assert.Equal(t, "10.0.0.0", stale[0].IP, "stale locations are refreshed by the first address")
+	assert.Equal(t, "10.0.1.0/24", stale[1].Network)
+
+	stale, err = s.GetStale(ctx, time.Now().Add(time.Hour), 10)
+	require.NoError(t, err)
+	assert.Len(t, stale, 3, "manual locations are never stale")
+
+	stale, err = s.GetStale(ctx, time.Now().Add(-time.Hour), 10)
+	require.NoError(t, err)
+	assert.Empty(t, stale)
+}
+
+func testConcurrentSave(t *testing.T, s repositoryInterfaces.Storage) {
+	ctx := context.Background()
+	const writers = 20
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for i := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- s.Save(ctx, location("37.99.42.0/24", "Kazakhstan", fmt.Sprint(i)))
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+
+	page, err := s.GetAll(ctx, models.LocationQuery{Sort: models.SortCreatedAt, Limit: 10})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), page.Total)
+}
+
+func testConcurrentCreate(t *testing.T, s repositoryInterfaces.Storage) {
+	ctx := context.Background()
+	const writers = 20
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for i := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- s.Create(ctx, location("37.99.42.0/24", "Kazakhstan", fmt.Sprint(i)))
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	created := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			created++
+		case !errors.Is(err, repositoryInterfaces.ErrConflict):
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	assert.Equal(t, 1, created)
+}
+
+// saveAll stores the locations one after another, so their creation times are ordered
+func saveAll(t *testing.T, s repositoryInterfaces.Storage, locations ...models.IPLocation) {
+	for _, l := range locations {
+		require.NoError(t, s.Save(context.Background(), l))
+		time.Sleep(2 * time.Millisecond)
+	}
+}
+
+func networks(locations []models.IPLocation) []string {
+	var result []string
+	for _, l := range locations {
+		result = append(result, l.Network)
+	}
+	return result
+}
+
+func testListOrder(t *testing.T, s repositoryInterfaces.Storage) {
+	ctx := context.Background()
+	saveAll(t, s,
+		location("37.99.42.0/24", "Kazakhstan", "Almaty"),
+		location("2a00:1450::/32", "Ireland", "Dublin"),
+		location("8.8.8.0/24", "United States", "Ashburn"),
+		location("37.99.0.0/16", "Kazakhstan", "Astana"),
+	)
+
+	tests := []struct {
+		sort string
+		desc bool
+		want []string
+	}{
+		{models.SortCreatedAt, true, []string{"37.99.0.0/16", "8.8.8.0/24", "2a00:1450::/32", "37.99.42.0/24"}},
+		{models.SortCreatedAt, false, []string{"37.99.42.0/24", "2a00:1450::/32", "8.8.8.0/24", "37.99.0.0/16"}},
+		{models.SortCity, false, []string{"37.99.42.0/24", "8.8.8.0/24", "37.99.0.0/16", "2a00:1450::/32"}},
+		{models.SortNetwork, false, []string{"8.8.8.0/24", "37.99.0.0/16", "37.99.42.0/24", "2a00:1450::/32"}},
+		{models.SortNetwork, true, []string{"2a00:1450::/32", "37.99.42.0/24", "37.99.0.0/16", "8.8.8.0/24"}},
+	}
+	for _, tt := range tests {
+		page, err := s.GetAll(ctx, models.LocationQuery{Sort: tt.sort, Desc: tt.desc, Limit: 10})
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, networks(page.Locations), "sort %s desc %v", tt.sort, tt.desc)
+		assert.Equal(t, int64(4), page.Total)
+		assert.Empty(t, page.NextCursor)
+	}
+
+	// equal countries are ordered by id, which isn't necessarily the creation order
+	page, err := s.GetAll(ctx, models.LocationQuery{Sort: models.SortCountry, Limit: 10})
+	require.NoError(t, err)
+	assert.True(t, slices.IsSortedFunc(page.Locations, func(a, b models.IPLocation) int {
+		return strings.Compare(a.Country, b.Country)
+	}))
+
+	page, err = s.GetAll(ctx, models.LocationQuery{Sort: models.SortNetwork, Limit: 10})
+	require.NoError(t, err)
+	assert.Equal(t, "37.99.0.0", page.Locations[1].IP, "listed locations stand for the first address of the network")
+}
+
+func testListPages(t *testing.T, s repositoryInterfaces.Storage) {
+	ctx := context.Background()
+	var all []string
+	for i := range 7 {
+		network := fmt.Sprintf("10.%d.0.0/16", i)
+		// equal countries make pages rely on the id tiebreaker
+		require.NoError(t, s.Save(ctx, location(network, "Kazakhstan", fmt.Sprint(i%2))))
+		all = append(all, network)
+	}
+
+	for _, sort := range []string{models.SortCreatedAt, models.SortCountry, models.SortCity, models.SortNetwork} {
+		for _, desc := range []bool{false, true} {
+			query := models.LocationQuery{Sort: sort, Desc: desc, Limit: 3}
+			var listed []string
+			for pages := 0; ; pages++ {
+				require.Less(t, pages, 5, "too many pages")
+
+				page, err := s.GetAll(ctx, query)
+				require.NoError(t, err)
+				assert.Equal(t, int64(7), page.Total)
+				assert.LessOrEqual(t, len(page.Locations), 3)
+				listed = append(listed, networks(page.Locations)...)
+
+				if page.NextCursor == "" {
+					break
+				}
+				query.Cursor = page.NextCursor
+			}
+			assert.ElementsMatch(t, all, listed, "sort %s desc %v", sort, desc)
+		}
+	}
+}
+
+func testListSkipTotal(t *testing.T, s repositoryInterfaces.Storage) {
+	ctx := context.Background()
+	saveAll(t, s,
+		location("37.99.42.0/24", "Kazakhstan", "Almaty"),
+		location("37.99.0.0/16", "Kazakhstan", "Astana"),
+		location("8.8.8.0/24", "United States", "Ashburn"),
+	)
+
+	query := models.LocationQuery{Sort: models.SortNetwork, Limit: 2, SkipTotal: true}
+	page, err := s.GetAll(ctx, query)
+	require.NoError(t, err)
+	assert.Zero(t, page.Total)
+	assert.Equal(t, []string{"8.8.8.0/24", "37.99.0.0/16"}, networks(page.Locations))
+
+	query.Cursor = page.NextCursor
+	page, err = s.GetAll(ctx, query)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"37.99.42.0/24"}, networks(page.Locations))
+	assert.Empty(t, page.NextCursor)
+}
+
+func testListFilters(t *testing.T, s repositoryInterfaces.Storage) {
+	ctx := context.Background()
+	saveAll(t, s,
+		location("37.99.42.0/24", "Kazakhstan", "Almaty"),
+		location("37.99.0.0/16", "Kazakhstan", "Astana"),
+		location("8.8.8.0/24", "United States", "Ashburn"),
+	)
+	dublin := location("2a00:1450::/32", "Ireland", "Dublin")
+	dublin.Provider = "mmdb"
+	saveAll(t, s, dublin)
+
+	astana, err := s.GetByIP(ctx, "37.99.1.1")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		query models.LocationQuery
+		want  []string
+	}{
+		{"country", models.LocationQuery{Country: "Kazakhstan"}, []string{"37.99.0.0/16", "37.99.42.0/24"}},
+		{"city", models.LocationQuery{City: "Ashburn"}, []string{"8.8.8.0/24"}},
+		{"provider", models.LocationQuery{Provider: "mmdb"}, []string{"2a00:1450::/32"}},
+		{"network", models.LocationQuery{Network: "37.99.0.0/16"}, []string{"37.99.0.0/16", "37.99.42.0/24"}},
+		{"narrower network", models.LocationQuery{Network: "37.99.42.0/23"}, []string{"37.99.42.0/24"}},
+		{"ipv6 network", models.LocationQuery{Network: "2a00::/16"}, []string{"2a00:1450::/32"}},
+		{"created from", models.LocationQuery{CreatedFrom: *astana.CreatedAt}, []string{"8.8.8.0/24", "37.99.0.0/16", "2a00:1450::/32"}},
+		{"created to", models.LocationQuery{CreatedTo: *astana.CreatedAt}, []string{"37.99.42.0/24"}},
+		{"nothing", models.LocationQuery{Country: "Kazakhstan", City: "Ashburn"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := tt.query
+			query.Sort, query.Limit = models.SortNetwork, 10
+
+			page, err := s.GetAll(ctx, query)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, networks(page.Locations))
+			assert.Equal(t, int64(len(tt.want)), page.Total)
+		})
+	}
+}
+
+func testListInvalidCursor(t *testing.T, s repositoryInterfaces.Storage) {
+	ctx := context.Background()
+	saveAll(t, s,
+		location("37.99.42.0/24", "Kazakhstan", "Almaty"),
+		location("8.8.8.0/24", "United States", "Ashburn"),
+	)
+
+	page, err := s.GetAll(ctx, models.LocationQuery{Sort: models.SortCountry, Limit: 1})
+	require.NoError(t, err)
+	require.NotEmpty(t, page.NextCursor)
+
+	_, err = s.GetAll(ctx, models.LocationQuery{Sort: models.SortCity, Limit: 1, Cursor: page.NextCursor})
+	assert.ErrorIs(t, err, repositoryInterfaces.ErrInvalidCursor, "cursor of another sort")
+
+	_, err = s.GetAll(ctx, models.LocationQuery{Sort: models.SortCountry, Limit: 1, Cursor: "not a cursor"})
+	assert.ErrorIs(t, err, repositoryInterfaces.ErrInvalidCursor)
+}
+
+func testSummarize(t *testing.T, s repositoryInterfaces.Storage) {
+	ctx := context.Background()
+	summary, err := s.Summarize(ctx, time.Time{}, 10)
+	require.NoError(t, err)
+	assert.Zero(t, summary.Total)
+	assert.Empty(t, summary.Countries)
+	assert.Nil(t, summary.Oldest)
+
+	require.NoError(t, s.Save(ctx, location("37.99.42.0/24", "Kazakhstan", "Almaty")))
+	require.NoError(t, s.Save(ctx, location("37.99.43.0/24", "Kazakhstan", "Astana")))
+	require.NoError(t, s.Save(ctx, location("2a00:1450::/32", "Ireland", "Dublin")))
+	manual := location("8.8.8.0/24", "United States", "Ashburn")
+	manual.Provider = "manual"
+	require.NoError(t, s.Create(ctx, manual))
+
+	summary, err = s.Summarize(ctx, time.Time{}, 2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), summary.Total)
+	assert.Equal(t, int64(3), summary.IPv4)
+	assert.Equal(t, int64(1), summary.IPv6)
+	assert.Zero(t, summary.Stale)
+	assert.Equal(t, []models.NameCount{{Name: "ipapi", Count: 3}, {Name: "manual", Count: 1}}, summary.Providers)
+	assert.Equal(t, []models.NameCount{{Name: "Kazakhstan", Count: 2}, {Name: "Ireland", Count: 1}}, summary.Countries)
+	require.NotNil(t, summary.Oldest)
+	require.NotNil(t, summary.Newest)
+	assert.False(t, summary.Newest.Before(*summary.Oldest))
+
+	// manual locations are never stale
+	summary, err = s.Summarize(ctx, time.Now().Add(time.Hour), 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), summary.Stale)
+	assert.Empty(t, summary.Countries)
+}
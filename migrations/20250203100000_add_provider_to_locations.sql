@@ -0,0 +1,9 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE locations ADD COLUMN IF NOT EXISTS provider VARCHAR(32) NOT NULL DEFAULT '';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE locations DROP COLUMN IF EXISTS provider;
+-- +goose StatementEnd
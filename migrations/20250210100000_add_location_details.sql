@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE locations
+    ADD COLUMN IF NOT EXISTS region VARCHAR(100) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS zip VARCHAR(20) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS lat DOUBLE PRECISION NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS lon DOUBLE PRECISION NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS timezone VARCHAR(64) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS isp VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS org VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS asn VARCHAR(255) NOT NULL DEFAULT '';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE locations
+    DROP COLUMN IF EXISTS region,
+    DROP COLUMN IF EXISTS zip,
+    DROP COLUMN IF EXISTS lat,
+    DROP COLUMN IF EXISTS lon,
+    DROP COLUMN IF EXISTS timezone,
+    DROP COLUMN IF EXISTS isp,
+    DROP COLUMN IF EXISTS org,
+    DROP COLUMN IF EXISTS asn;
+-- +goose StatementEnd
@@ -0,0 +1,45 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE locations ADD COLUMN IF NOT EXISTS network CIDR;
+
+-- every stored address becomes a single-address network, rows with junk addresses are dropped
+DO $$
+DECLARE
+    r RECORD;
+BEGIN
+    FOR r IN SELECT id, ip_address FROM locations LOOP
+        BEGIN
+            UPDATE locations SET network = trim(r.ip_address)::inet::cidr WHERE id = r.id;
+        EXCEPTION WHEN invalid_text_representation THEN
+            DELETE FROM locations WHERE id = r.id;
+        END;
+    END LOOP;
+END $$;
+
+DELETE FROM locations a USING locations b
+WHERE a.network = b.network AND (a.fetched_at, a.id) < (b.fetched_at, b.id);
+
+ALTER TABLE locations ALTER COLUMN network SET NOT NULL;
+ALTER TABLE locations ADD CONSTRAINT locations_network_key UNIQUE (network);
+ALTER TABLE locations DROP COLUMN ip_address;
+
+CREATE INDEX IF NOT EXISTS idx_locations_network ON locations USING GIST (network inet_ops);
+CREATE INDEX IF NOT EXISTS idx_country_city ON locations(country, city);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_country_city;
+DROP INDEX IF EXISTS idx_locations_network;
+
+-- ranges can't be represented by a single address
+DELETE FROM locations WHERE masklen(network) < CASE WHEN family(network) = 4 THEN 32 ELSE 128 END;
+
+ALTER TABLE locations ADD COLUMN ip_address VARCHAR(45);
+UPDATE locations SET ip_address = host(network);
+ALTER TABLE locations ALTER COLUMN ip_address SET NOT NULL;
+ALTER TABLE locations ADD CONSTRAINT locations_ip_address_key UNIQUE (ip_address);
+ALTER TABLE locations DROP COLUMN network;
+
+CREATE INDEX idx_ip_country_city ON locations(ip_address, country, city);
+-- +goose StatementEnd
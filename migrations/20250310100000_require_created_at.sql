@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+UPDATE locations SET created_at = fetched_at WHERE created_at IS NULL;
+ALTER TABLE locations ALTER COLUMN created_at SET NOT NULL;
+
+-- keyset pagination orders by the sort column and id
+DROP INDEX IF EXISTS idx_created_at;
+CREATE INDEX idx_created_at ON locations(created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_provider ON locations(provider);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_provider;
+DROP INDEX IF EXISTS idx_created_at;
+CREATE INDEX idx_created_at ON locations(created_at DESC);
+ALTER TABLE locations ALTER COLUMN created_at DROP NOT NULL;
+-- +goose StatementEnd
@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+-- manually entered locations were never fetched from a provider and are not refreshed
+ALTER TABLE locations ALTER COLUMN fetched_at DROP DEFAULT;
+ALTER TABLE locations ALTER COLUMN fetched_at DROP NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+UPDATE locations SET fetched_at = created_at WHERE fetched_at IS NULL;
+ALTER TABLE locations ALTER COLUMN fetched_at SET NOT NULL;
+ALTER TABLE locations ALTER COLUMN fetched_at SET DEFAULT NOW();
+-- +goose StatementEnd
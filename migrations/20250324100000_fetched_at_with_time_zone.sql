@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+-- fetched_at is compared with the application clock, a value without a time zone is read
+-- back in the zone of the session and shifts staleness by the offset between the two.
+-- Existing values were written in the session zone by NOW()
+ALTER TABLE locations ALTER COLUMN fetched_at TYPE TIMESTAMPTZ;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE locations ALTER COLUMN fetched_at TYPE TIMESTAMP;
+-- +goose StatementEnd
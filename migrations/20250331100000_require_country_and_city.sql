@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+-- keyset pagination compares (country, id) and (city, id) rows, NULL would end the page early
+UPDATE locations SET country = '' WHERE country IS NULL;
+UPDATE locations SET city = '' WHERE city IS NULL;
+ALTER TABLE locations
+    ALTER COLUMN country SET DEFAULT '',
+    ALTER COLUMN country SET NOT NULL,
+    ALTER COLUMN city SET DEFAULT '',
+    ALTER COLUMN city SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_country_id ON locations(country, id);
+CREATE INDEX IF NOT EXISTS idx_city_id ON locations(city, id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_city_id;
+DROP INDEX IF EXISTS idx_country_id;
+ALTER TABLE locations
+    ALTER COLUMN country DROP NOT NULL,
+    ALTER COLUMN country DROP DEFAULT,
+    ALTER COLUMN city DROP NOT NULL,
+    ALTER COLUMN city DROP DEFAULT;
+-- +goose StatementEnd
@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+-- created_at is filtered by instants of the API, a value without a time zone is read back
+-- in the zone of the session and shifts the filters by the offset between the two.
+-- Existing values were written in the session zone by NOW()
+ALTER TABLE locations ALTER COLUMN created_at TYPE TIMESTAMPTZ;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE locations ALTER COLUMN created_at TYPE TIMESTAMP;
+-- +goose StatementEnd
@@ -0,0 +1,28 @@
+// Package migrations embeds the database schema migrations into the binary,
+// so they don't depend on the working directory the service is started from
+package migrations
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed *.sql
+var postgres embed.FS
+
+//go:embed sqlite/*.sql
+var sqlite embed.FS
+
+// Postgres holds the migrations of the postgres driver
+func Postgres() fs.FS {
+	return postgres
+}
+
+// SQLite holds the migrations of the sqlite driver
+func SQLite() fs.FS {
+	sub, err := fs.Sub(sqlite, "sqlite")
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
@@ -0,0 +1,37 @@
+-- +goose Up
+-- +goose StatementBegin
+-- ip_from and ip_to keys are the address length in bits followed by the address bytes,
+-- so comparing them byte by byte orders IPv4 before IPv6 and addresses numerically
+CREATE TABLE IF NOT EXISTS locations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    network TEXT NOT NULL UNIQUE,
+    ip_from BLOB NOT NULL,
+    ip_to BLOB NOT NULL,
+    bits INTEGER NOT NULL,
+    country TEXT NOT NULL DEFAULT '',
+    region TEXT NOT NULL DEFAULT '',
+    city TEXT NOT NULL DEFAULT '',
+    zip TEXT NOT NULL DEFAULT '',
+    lat REAL NOT NULL DEFAULT 0,
+    lon REAL NOT NULL DEFAULT 0,
+    timezone TEXT NOT NULL DEFAULT '',
+    isp TEXT NOT NULL DEFAULT '',
+    org TEXT NOT NULL DEFAULT '',
+    asn TEXT NOT NULL DEFAULT '',
+    provider TEXT NOT NULL DEFAULT '',
+    -- unix time in nanoseconds, fetched_at is NULL for manual locations
+    created_at INTEGER NOT NULL,
+    fetched_at INTEGER
+);
+
+CREATE INDEX idx_locations_range ON locations(ip_from, ip_to);
+CREATE INDEX idx_created_at ON locations(created_at, id);
+CREATE INDEX idx_fetched_at ON locations(fetched_at);
+CREATE INDEX idx_country_city ON locations(country, city);
+CREATE INDEX idx_provider ON locations(provider);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS locations;
+-- +goose StatementEnd
@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS ip_ranges (
+    ip_from BLOB NOT NULL,
+    ip_to BLOB NOT NULL,
+    country TEXT NOT NULL DEFAULT '',
+    region TEXT NOT NULL DEFAULT '',
+    city TEXT NOT NULL DEFAULT '',
+    zip TEXT NOT NULL DEFAULT '',
+    lat REAL NOT NULL DEFAULT 0,
+    lon REAL NOT NULL DEFAULT 0,
+    timezone TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX idx_ip_ranges_from ON ip_ranges(ip_from);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS ip_ranges;
+-- +goose StatementEnd
@@ -0,0 +1,40 @@
+-- +goose Up
+-- +goose StatementBegin
+-- ranges don't overlap, so keying the table by its start address replaces the separate index
+-- and lets an import build the new table fully indexed before swapping it in
+CREATE TABLE ip_ranges_keyed (
+    ip_from BLOB NOT NULL PRIMARY KEY,
+    ip_to BLOB NOT NULL,
+    country TEXT NOT NULL DEFAULT '',
+    region TEXT NOT NULL DEFAULT '',
+    city TEXT NOT NULL DEFAULT '',
+    zip TEXT NOT NULL DEFAULT '',
+    lat REAL NOT NULL DEFAULT 0,
+    lon REAL NOT NULL DEFAULT 0,
+    timezone TEXT NOT NULL DEFAULT ''
+) WITHOUT ROWID;
+
+INSERT INTO ip_ranges_keyed SELECT ip_from, ip_to, country, region, city, zip, lat, lon, timezone FROM ip_ranges;
+DROP TABLE ip_ranges;
+ALTER TABLE ip_ranges_keyed RENAME TO ip_ranges;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+CREATE TABLE ip_ranges_indexed (
+    ip_from BLOB NOT NULL,
+    ip_to BLOB NOT NULL,
+    country TEXT NOT NULL DEFAULT '',
+    region TEXT NOT NULL DEFAULT '',
+    city TEXT NOT NULL DEFAULT '',
+    zip TEXT NOT NULL DEFAULT '',
+    lat REAL NOT NULL DEFAULT 0,
+    lon REAL NOT NULL DEFAULT 0,
+    timezone TEXT NOT NULL DEFAULT ''
+);
+
+INSERT INTO ip_ranges_indexed SELECT * FROM ip_ranges;
+DROP TABLE ip_ranges;
+ALTER TABLE ip_ranges_indexed RENAME TO ip_ranges;
+CREATE INDEX idx_ip_ranges_from ON ip_ranges(ip_from);
+-- +goose StatementEnd
@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+-- keyset pagination orders by the sort column and id
+CREATE INDEX IF NOT EXISTS idx_country_id ON locations(country, id);
+CREATE INDEX IF NOT EXISTS idx_city_id ON locations(city, id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_city_id;
+DROP INDEX IF EXISTS idx_country_id;
+-- +goose StatementEnd
@@ -10,17 +10,27 @@ func RegisterRoutes(r *mux.Router, h handlers.LocHandler) {
 	LocRoutes(r, h)
 }
 
+// AdminRoutes registers administrative endpoints, every request must carry the admin token
+func AdminRoutes(r *mux.Router, h handlers.AdminHandler) {
+	r.HandleFunc("/admin/import", h.Import).Methods("POST", "OPTIONS")
+	r.HandleFunc("/admin/import/{id}", h.ImportStatus).Methods("GET", "OPTIONS")
+}
+
 func LocRoutes(r *mux.Router, h handlers.LocHandler) {
 	r.HandleFunc("/location", h.GetLocationByIP).Methods("GET", "OPTIONS")
 	r.HandleFunc("/location/{ip}", h.GetLocationForProvidedIP).Methods("GET", "OPTIONS")
-	r.HandleFunc("/location/{ip}", h.UpdateLocation).Methods("PUT", "OPTIONS")
+	r.HandleFunc("/location/{ip}", h.ReplaceLocation).Methods("PUT", "OPTIONS")
+	r.HandleFunc("/location/{ip}", h.PatchLocation).Methods("PATCH", "OPTIONS")
 	r.HandleFunc("/location/{ip}", h.DeleteLocation).Methods("DELETE", "OPTIONS")
 	r.HandleFunc("/locations", h.GetAllLocations).Methods("GET", "OPTIONS")
+	r.HandleFunc("/locations", h.CreateLocation).Methods("POST", "OPTIONS")
+	r.HandleFunc("/locations/lookup", h.LookupLocations).Methods("POST", "OPTIONS")
+	r.HandleFunc("/health", h.Health).Methods("GET", "OPTIONS")
 
 	r.HandleFunc("/location", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == http.MethodOptions {
 			w.Header().Set("Access-Control-Allow-Origin", "http://localhost:5173")
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
 			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 			w.WriteHeader(http.StatusOK)
 			return